@@ -0,0 +1,255 @@
+package bitcast_go
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"bitcask-go/pkg/disk"
+)
+
+const (
+	// backupManifestFileName 备份的所有文件落盘后写入的清单，有它才说明备份是完整的
+	backupManifestFileName = "backup-manifest"
+	// copyBufferSize 复制数据文件时每次读写的大小
+	copyBufferSize = 1 << 20
+)
+
+// BackupManifest 备份的清单，记录备份时冻结的所有数据文件
+type BackupManifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	// Files 按文件ID从小到大排列
+	Files []BackupFile `json:"files"`
+}
+
+// BackupFile 备份中的一个数据文件
+type BackupFile struct {
+	ID   uint64 `json:"id"`
+	Size int64  `json:"size"`
+	// Hint 是否同时备份了hint文件
+	Hint bool `json:"hint"`
+}
+
+// Backup 在不停止读写的情况下把DB当前的状态备份到dir，dir必须不存在或者是空目录
+// 先在写锁内切换活跃文件，此前完成的写入全部在被冻结的older file中，之后的写入都不在备份里
+// 数据文件和hint文件优先使用硬链接，不支持硬链接或者数据文件不在本地文件系统时复制，使用同一个存储后端
+// ID最大的文件总是复制的，因为打开备份时它会作为活跃文件继续追加写，不能和源文件共享
+// 备份期间不能merge，Merge返回ErrMergeInProgress
+func (d *DB) Backup(dir string) (*BackupManifest, error) {
+	if !d.merging.CompareAndSwap(false, true) {
+		return nil, ErrMergeInProgress
+	}
+	defer d.merging.Store(false)
+
+	s := d.Opts.storage()
+	if err := checkEmptyDir(s, dir); err != nil {
+		return nil, err
+	}
+	files, err := d.freezeFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer d.mergeWG.Done()
+	manifest := &BackupManifest{CreatedAt: time.Now(), Files: files}
+	if err = copyFiles(s, d.Opts.Dir, dir, files); err == nil {
+		err = writeBackupManifest(dir, manifest)
+	}
+	if err != nil {
+		_ = disk.RemoveDataFiles(s, dir)
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// freezeFiles 切换活跃文件，返回当前所有的数据文件，返回的文件都不会再被写入
+// 批量写入在一次追加写中完成，切换不会把一个批量写入分到两个文件里
+// 成功时在mergeWG中登记，Close会等待备份完成，调用方负责Done
+func (d *DB) freezeFiles() ([]BackupFile, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, ErrDBClosed
+	}
+	// 空的活跃文件不需要切换，也不需要备份，切换出去的文件由ToOlderFile落盘
+	if d.activeFile != nil && d.activeFile.Size() > int64(d.activeFile.Header().DataOffset()) {
+		if err := d.rotateActiveFile(); err != nil {
+			return nil, err
+		}
+	}
+	files := make([]BackupFile, 0, len(d.oldFiles))
+	for fid, dataFile := range d.oldFiles {
+		files = append(files, BackupFile{ID: fid, Size: dataFile.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+	d.mergeWG.Add(1)
+	return files, nil
+}
+
+// Restore 检查backupDir中的备份是否完整，把它恢复到opts.Dir后作为普通的DB打开
+// opts.Dir必须不存在或者是空目录，备份本身不会被修改，可以多次恢复
+// 备份目录本身也是合法的数据目录，只需要查看备份的内容时可以直接以只读方式Open
+func Restore(backupDir string, opts *Options) (*DB, error) {
+	manifest, err := ReadBackupManifest(backupDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	s := opts.storage()
+	if err = checkEmptyDir(s, opts.Dir); err != nil {
+		return nil, err
+	}
+	if err = copyFiles(s, backupDir, opts.Dir, manifest.Files); err != nil {
+		_ = disk.RemoveDataFiles(s, opts.Dir)
+		_ = os.RemoveAll(opts.Dir)
+		return nil, err
+	}
+	return Open(opts)
+}
+
+// ReadBackupManifest 读取dir中备份的清单，并检查清单中的文件都存在并且大小一致
+// 数据文件通过opts中的存储后端读取
+func ReadBackupManifest(dir string, opts *Options) (*BackupManifest, error) {
+	bs, err := os.ReadFile(filepath.Join(dir, backupManifestFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s has no manifest", ErrInvalidBackup, dir)
+		}
+		return nil, err
+	}
+	manifest := new(BackupManifest)
+	if err = json.Unmarshal(bs, manifest); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidBackup, dir, err)
+	}
+	s := opts.storage()
+	for _, f := range manifest.Files {
+		file, err := s.Open(disk.DataFileName(dir, f.ID), f.ID, false)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("%w: data file %d is missing", ErrInvalidBackup, f.ID)
+			}
+			return nil, err
+		}
+		size := file.Offset()
+		if err = file.Close(); err != nil {
+			return nil, err
+		}
+		if size != f.Size {
+			return nil, fmt.Errorf("%w: data file %d has %d bytes, expected %d", ErrInvalidBackup, f.ID, size, f.Size)
+		}
+		if f.Hint {
+			if _, err = os.Stat(disk.HintFileName(dir, f.ID)); err != nil {
+				return nil, fmt.Errorf("%w: hint file %d: %v", ErrInvalidBackup, f.ID, err)
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// checkEmptyDir 检查dir不存在或者是空目录，包括存储后端中的数据文件
+func checkEmptyDir(s disk.Storage, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fileIDs, err := disk.ListDataFileIDs(s, dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 || len(fileIDs) > 0 {
+		return fmt.Errorf("%w: %s", ErrDirNotEmpty, dir)
+	}
+	return nil
+}
+
+// copyFiles 把srcDir中的files和对应的hint文件备份到dstDir，并同步dstDir的目录项
+// 会填上files中的Hint
+func copyFiles(s disk.Storage, srcDir, dstDir string, files []BackupFile) error {
+	if err := os.MkdirAll(dstDir, os.FileMode(0755)); err != nil {
+		return err
+	}
+	for i := range files {
+		fid := files[i].ID
+		link := i < len(files)-1
+		if err := copyFile(s, disk.DataFileName(srcDir, fid), disk.DataFileName(dstDir, fid), fid, files[i].Size, link); err != nil {
+			return err
+		}
+		// hint文件始终在本地文件系统上，只会被整体替换或者删除，总是可以共享
+		info, err := os.Stat(disk.HintFileName(srcDir, fid))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = copyFile(disk.FileStorage{}, disk.HintFileName(srcDir, fid), disk.HintFileName(dstDir, fid), fid, info.Size(), true)
+		if err != nil {
+			return err
+		}
+		files[i].Hint = true
+	}
+	return syncDirs(s, dstDir)
+}
+
+// copyFile 把src的前size个字节复制到dst并落盘，link为true并且在本地文件系统上时优先使用硬链接
+func copyFile(s disk.Storage, src, dst string, fid uint64, size int64, link bool) error {
+	if _, ok := s.(disk.FileStorage); ok && link {
+		if err := os.Link(src, dst); err == nil {
+			return syncFile(dst)
+		}
+	}
+	in, err := s.Open(src, fid, false)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := s.Open(dst, fid, true)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, copyBufferSize)
+	for offset := int64(0); offset < size && err == nil; {
+		n := int64(len(buf))
+		if size-offset < n {
+			n = size - offset
+		}
+		if _, err = in.ReadFromDisk(buf[:n], uint64(offset)); err == nil {
+			_, _, err = out.WriteToDisk(buf[:n])
+		}
+		offset += n
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncFile 硬链接和源文件共享数据，保证备份中的数据已经落盘
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeBackupManifest 所有文件落盘之后最后写入清单
+func writeBackupManifest(dir string, manifest *BackupManifest) error {
+	bs, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err = writeFileSync(filepath.Join(dir, backupManifestFileName), bs); err != nil {
+		return err
+	}
+	return disk.FileStorage{}.SyncDir(dir)
+}
@@ -0,0 +1,254 @@
+package bitcast_go
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitcask-go/pkg/disk"
+)
+
+func TestDB_Backup(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	// merge生成hint文件
+	require.NoError(t, db.Merge())
+	for i := 20; i < 40; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("new-value-%02d", i))))
+	}
+	_, err = db.Del([]byte("key-00"))
+	require.NoError(t, err)
+	check := func(db *DB) {
+		val, err := db.Get([]byte("key-00"))
+		require.NoError(t, err)
+		require.Nil(t, val)
+		for i := 1; i < 40; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%02d", i)))
+			require.NoError(t, err)
+			if i < 20 {
+				require.Equal(t, []byte(fmt.Sprintf("value-%02d", i)), val)
+			} else {
+				require.Equal(t, []byte(fmt.Sprintf("new-value-%02d", i)), val)
+			}
+		}
+	}
+
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	manifest, err := db.Backup(backupDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, manifest.Files)
+	// 备份之后的写入不在备份中
+	require.NoError(t, db.Put([]byte("after-backup"), []byte("value")))
+
+	hints := 0
+	for i, f := range manifest.Files {
+		if f.Hint {
+			hints++
+		}
+		src, err := os.Stat(disk.DataFileName(opts.Dir, f.ID))
+		require.NoError(t, err)
+		dst, err := os.Stat(disk.DataFileName(backupDir, f.ID))
+		require.NoError(t, err)
+		require.Equal(t, f.Size, dst.Size())
+		// 除了ID最大的文件都是硬链接
+		require.Equal(t, i < len(manifest.Files)-1, os.SameFile(src, dst), "file %d", f.ID)
+	}
+	require.Greater(t, hints, 0)
+	read, err := ReadBackupManifest(backupDir, opts)
+	require.NoError(t, err)
+	require.Equal(t, manifest.Files, read.Files)
+
+	_, err = db.Backup(backupDir)
+	require.ErrorIs(t, err, ErrDirNotEmpty)
+	require.NoError(t, db.Close())
+
+	// 备份目录可以直接以只读方式打开
+	backup, err := Open(NewOptions([]OptionsFunc{DirOption(backupDir), ReadOnlyOption(true)}))
+	require.NoError(t, err)
+	check(backup)
+	require.NoError(t, backup.Close())
+
+	restoreOpts := NewOptions([]OptionsFunc{
+		DirOption(filepath.Join(t.TempDir(), "restore")),
+		MaxSizeOption(256),
+	})
+	restored, err := Restore(backupDir, restoreOpts)
+	require.NoError(t, err)
+	check(restored)
+	val, err := restored.Get([]byte("after-backup"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+	// 恢复出来的DB是普通的DB，写入和merge都不会影响源DB和备份
+	for i := 1; i < 40; i++ {
+		require.NoError(t, restored.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte("restored")))
+	}
+	require.NoError(t, restored.Merge())
+	require.NoError(t, restored.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	val, err = db.Get([]byte("after-backup"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), val)
+	require.NoError(t, db.Close())
+	_, err = ReadBackupManifest(backupDir, opts)
+	require.NoError(t, err)
+
+	_, err = Restore(backupDir, restoreOpts)
+	require.ErrorIs(t, err, ErrDirNotEmpty)
+	_, err = Restore(opts.Dir, NewOptions([]OptionsFunc{DirOption(t.TempDir())}))
+	require.ErrorIs(t, err, ErrInvalidBackup)
+
+	// 备份中的文件被修改过
+	last := manifest.Files[len(manifest.Files)-1]
+	f, err := os.OpenFile(disk.DataFileName(backupDir, last.ID), os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("garbage"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = ReadBackupManifest(backupDir, opts)
+	require.ErrorIs(t, err, ErrInvalidBackup)
+}
+
+func TestDB_BackupWithConcurrentWrites(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(1024),
+		SyncPolicyOption(disk.SyncNever),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	const writers = 4
+	var (
+		wg       sync.WaitGroup
+		stop     atomic.Bool
+		progress [writers]atomic.Int64
+	)
+	for n := 0; n < writers; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; !stop.Load(); i++ {
+				if i%2 == 0 {
+					require.NoError(t, db.Put([]byte(fmt.Sprintf("w%d-%05d", n, i)), []byte(fmt.Sprint(i))))
+				} else {
+					// 批量写入中的两个key要么都在备份中，要么都不在
+					wb := db.NewWriteBatch()
+					require.NoError(t, wb.Put([]byte(fmt.Sprintf("w%d-%05d", n, i)), []byte(fmt.Sprint(i))))
+					require.NoError(t, wb.Put([]byte(fmt.Sprintf("b%d-%05d", n, i)), []byte(fmt.Sprint(i))))
+					require.NoError(t, wb.Commit())
+				}
+				progress[n].Store(int64(i + 1))
+			}
+		}(n)
+	}
+
+	var dirs []string
+	var before [][writers]int64
+	for round := 0; round < 3; round++ {
+		for n := 0; n < writers; n++ {
+			for progress[n].Load() < int64(100*(round+1)) {
+				runtime.Gosched()
+			}
+		}
+		// Backup之前已经完成的写入一定在备份中
+		var done [writers]int64
+		for n := range done {
+			done[n] = progress[n].Load()
+		}
+		dir := filepath.Join(t.TempDir(), "backup")
+		_, err := db.Backup(dir)
+		require.NoError(t, err)
+		dirs = append(dirs, dir)
+		before = append(before, done)
+	}
+	stop.Store(true)
+	wg.Wait()
+	require.NoError(t, db.Close())
+
+	for round, dir := range dirs {
+		backup, err := Restore(dir, NewOptions([]OptionsFunc{DirOption(filepath.Join(t.TempDir(), "restore"))}))
+		require.NoError(t, err)
+		for n := 0; n < writers; n++ {
+			// 每个writer按顺序写入，备份中是它写入的一个前缀
+			count := 0
+			for ; ; count++ {
+				val, err := backup.Get([]byte(fmt.Sprintf("w%d-%05d", n, count)))
+				require.NoError(t, err)
+				if val == nil {
+					break
+				}
+				require.Equal(t, []byte(fmt.Sprint(count)), val)
+				val, err = backup.Get([]byte(fmt.Sprintf("b%d-%05d", n, count)))
+				require.NoError(t, err)
+				if count%2 == 0 {
+					require.Nil(t, val)
+				} else {
+					require.Equal(t, []byte(fmt.Sprint(count)), val)
+				}
+			}
+			require.GreaterOrEqual(t, int64(count), before[round][n], "round %d writer %d", round, n)
+			it := backup.NewIterator(IteratorOptions{Prefix: []byte(fmt.Sprintf("w%d-", n))})
+			keys := 0
+			for ; it.Valid(); it.Next() {
+				keys++
+			}
+			it.Close()
+			require.Equal(t, count, keys, "round %d writer %d", round, n)
+		}
+		require.NoError(t, backup.Close())
+	}
+}
+
+func TestDB_BackupMemStorage(t *testing.T) {
+	storage := disk.NewMemStorage()
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+		StorageOption(storage),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	manifest, err := db.Backup(backupDir)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// 数据文件复制到了同一个存储后端中
+	ids, err := disk.ListDataFileIDs(storage, backupDir)
+	require.NoError(t, err)
+	require.Len(t, ids, len(manifest.Files))
+	ids, err = disk.ListDataFileIDs(disk.FileStorage{}, backupDir)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	restored, err := Restore(backupDir, NewOptions([]OptionsFunc{
+		DirOption(filepath.Join(t.TempDir(), "restore")),
+		StorageOption(storage),
+	}))
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		val, err := restored.Get([]byte(fmt.Sprintf("key-%02d", i)))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("value-%02d", i)), val)
+	}
+	require.NoError(t, restored.Close())
+}
@@ -0,0 +1,159 @@
+package bitcast_go
+
+import (
+	"sort"
+	"sync"
+
+	"bitcask-go/pkg/disk"
+	"bitcask-go/pkg/index"
+)
+
+// pendingBatches 重放时按序号暂存还没有读到提交标记的批量写入
+type pendingBatches map[uint64][]pendingRecord
+
+type pendingRecord struct {
+	record *disk.LogRecord
+	vMeta  *index.ValueMetadata
+}
+
+// batchOp 暂存在WriteBatch中的一次写操作
+type batchOp struct {
+	key   []byte
+	value []byte
+	del   bool
+}
+
+// WriteBatch 批量写入，Commit时所有的Put和Delete要么全部生效要么全部不生效
+// 所有LogRecord和提交标记作为一次追加写写入活跃文件，重启时只有读到提交标记的批量写入才会生效
+type WriteBatch struct {
+	db *DB
+	mu sync.Mutex
+	// ops 按写入顺序排列，同一个key只保留最后一次操作
+	ops     []batchOp
+	indexes map[string]int
+}
+
+// NewWriteBatch 创建一个空的批量写入
+func (d *DB) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{db: d, indexes: make(map[string]int)}
+}
+
+// Put 暂存一次写入，Commit之前不可见
+func (wb *WriteBatch) Put(key, value []byte) error {
+	if key == nil {
+		return index.ErrKeyIsNil
+	}
+	wb.stage(batchOp{key: key, value: value})
+	return nil
+}
+
+// Delete 暂存一次删除，Commit之前不可见
+func (wb *WriteBatch) Delete(key []byte) error {
+	if key == nil {
+		return index.ErrKeyIsNil
+	}
+	wb.stage(batchOp{key: key, del: true})
+	return nil
+}
+
+func (wb *WriteBatch) stage(op batchOp) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if i, ok := wb.indexes[string(op.key)]; ok {
+		wb.ops[i] = op
+		return
+	}
+	wb.indexes[string(op.key)] = len(wb.ops)
+	wb.ops = append(wb.ops, op)
+}
+
+// Commit 分配序号并写入所有暂存的操作和提交标记，成功后清空WriteBatch，可以继续复用
+func (wb *WriteBatch) Commit() error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if len(wb.ops) == 0 {
+		return nil
+	}
+
+	d := wb.db
+	keys := make([][]byte, 0, len(wb.ops))
+	for _, op := range wb.ops {
+		keys = append(keys, op.key)
+	}
+	unlock := d.lockKeys(keys)
+	defer unlock()
+	return wb.commitLocked()
+}
+
+// commitLocked 写入所有暂存的操作，调用方持有所有key的锁，并且保证没有并发修改wb
+func (wb *WriteBatch) commitLocked() error {
+	d := wb.db
+	seq := d.seq.Add(1)
+	records := make([]*disk.LogRecord, 0, len(wb.ops)+1)
+	for _, op := range wb.ops {
+		var (
+			record *disk.LogRecord
+			err    error
+		)
+		if op.del {
+			record, err = disk.NewDeleteLogRecord(op.key)
+		} else {
+			record, err = disk.NewNormalLogRecord(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+		record.SetSeq(seq)
+		records = append(records, record)
+	}
+	commit, err := disk.NewBatchCommitLogRecord(seq)
+	if err != nil {
+		return err
+	}
+	records = append(records, commit)
+
+	err = d.appendToActiveFile(func(activeFile disk.DataFile, force bool) error {
+		vs, err := activeFile.WriteLogRecords(records, force)
+		if err != nil {
+			return err
+		}
+		for i, op := range wb.ops {
+			if op.del {
+				err = d.index.Del(op.key)
+			} else {
+				err = d.index.Set(op.key, vs[i])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	wb.ops = nil
+	wb.indexes = make(map[string]int)
+	return nil
+}
+
+// lockKeys 锁住所有key所在的分段，按分段的顺序加锁避免死锁
+func (d *DB) lockKeys(keys [][]byte) (unlock func()) {
+	slots := make(map[uint32]struct{}, len(keys))
+	for _, key := range keys {
+		slots[keySlot(key)] = struct{}{}
+	}
+	sorted := make([]uint32, 0, len(slots))
+	for slot := range slots {
+		sorted = append(sorted, slot)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, slot := range sorted {
+		d.keyLocks[slot].Lock()
+	}
+	return func() {
+		for _, slot := range sorted {
+			d.keyLocks[slot].Unlock()
+		}
+	}
+}
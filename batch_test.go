@@ -0,0 +1,155 @@
+package bitcast_go
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitcask-go/pkg/disk"
+)
+
+func TestWriteBatch_Commit(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(128),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key-0"), []byte("old")))
+
+	wb := db.NewWriteBatch()
+	require.Error(t, wb.Put(nil, []byte("v")))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, wb.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	require.NoError(t, wb.Delete([]byte("key-9")))
+	require.NoError(t, wb.Put([]byte("key-1"), []byte("value-1-new")))
+
+	// 提交之前不可见
+	val, err := db.Get([]byte("key-0"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("old"), val)
+	val, err = db.Get([]byte("key-1"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+
+	require.NoError(t, wb.Commit())
+	require.Equal(t, uint64(1), db.seq.Load())
+	check := func(db *DB) {
+		for i := 0; i < 10; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%d", i)))
+			require.NoError(t, err)
+			switch i {
+			case 1:
+				require.Equal(t, []byte("value-1-new"), val)
+			case 9:
+				require.Nil(t, val)
+			default:
+				require.Equal(t, []byte(fmt.Sprintf("value-%d", i)), val)
+			}
+		}
+	}
+	check(db)
+
+	// 空的批量写入什么也不做，提交后可以复用
+	require.NoError(t, wb.Commit())
+	require.NoError(t, wb.Put([]byte("key-10"), []byte("value-10")))
+	require.NoError(t, wb.Commit())
+	require.Equal(t, uint64(2), db.seq.Load())
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	require.Equal(t, uint64(2), db.seq.Load())
+
+	// merge之后批量写入的数据仍然有效
+	require.NoError(t, db.Put([]byte("rotate"), make([]byte, 128)))
+	require.NoError(t, db.Merge())
+	check(db)
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	val, err = db.Get([]byte("key-10"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-10"), val)
+	require.NoError(t, db.Close())
+}
+
+func TestWriteBatch_Uncommitted(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(defaultDataFileSize),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key"), []byte("old")))
+
+	// 模拟提交标记写入之前进程崩溃
+	var records []*disk.LogRecord
+	for _, k := range []string{"key", "other"} {
+		record, err := disk.NewNormalLogRecord([]byte(k), []byte("new"))
+		require.NoError(t, err)
+		record.SetSeq(7)
+		records = append(records, record)
+	}
+	_, err = db.activeFile.WriteLogRecords(records, true)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	val, err := db.Get([]byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("old"), val)
+	val, err = db.Get([]byte("other"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+	// 没有提交的序号也不会再被使用
+	require.Equal(t, uint64(7), db.seq.Load())
+
+	wb := db.NewWriteBatch()
+	require.NoError(t, wb.Put([]byte("other"), []byte("committed")))
+	require.NoError(t, wb.Commit())
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	val, err = db.Get([]byte("other"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("committed"), val)
+	require.NoError(t, db.Close())
+}
+
+func TestWriteBatch_TornCommit(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(defaultDataFileSize),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	wb := db.NewWriteBatch()
+	require.NoError(t, wb.Put([]byte("a"), []byte("1")))
+	require.NoError(t, wb.Put([]byte("b"), []byte("2")))
+	require.NoError(t, wb.Commit())
+	require.NoError(t, db.Close())
+
+	// 提交标记只写了一半
+	name := disk.DataFileName(opts.Dir, 1)
+	content, err := os.ReadFile(name)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(name, content[:len(content)-1], 0600))
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.NotZero(t, db.DiscardedBytes())
+	for _, k := range []string{"a", "b"} {
+		val, err := db.Get([]byte(k))
+		require.NoError(t, err)
+		require.Nil(t, val)
+	}
+	require.NoError(t, db.Close())
+}
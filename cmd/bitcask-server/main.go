@@ -0,0 +1,81 @@
+// bitcask-server 使用redis协议对外提供bitcask数据目录的读写，指定-http时同时提供HTTP/JSON接口
+//
+//	bitcask-server [-dir DIR] [-addr ADDR] [-http ADDR] [-sync-interval DURATION]
+//
+// 收到SIGINT或SIGTERM时关闭所有连接，等待正在执行的命令和请求完成后关闭DB
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	bitcask "bitcask-go"
+	"bitcask-go/pkg/disk"
+	"bitcask-go/pkg/gateway"
+	"bitcask-go/pkg/server"
+)
+
+func main() {
+	opts := bitcask.NewDefaultOptions()
+	flag.StringVar(&opts.Dir, "dir", opts.Dir, "bitcask data directory")
+	addr := flag.String("addr", "127.0.0.1:6379", "TCP address to listen on")
+	httpAddr := flag.String("http", "", "also serve the HTTP/JSON gateway on `ADDR`")
+	syncInterval := flag.Duration("sync-interval", time.Second, "fsync the active file every `DURATION`, 0 leaves it to the OS")
+	flag.Parse()
+	if *syncInterval > 0 {
+		opts.SyncPolicy = disk.SyncEveryInterval
+		opts.SyncInterval = *syncInterval
+	}
+
+	db, err := bitcask.Open(opts)
+	if err != nil {
+		log.Fatalf("open %s: %v", opts.Dir, err)
+	}
+	srv := server.New(db)
+	var httpSrv *http.Server
+	httpDone := make(chan struct{})
+	if *httpAddr != "" {
+		httpSrv = &http.Server{Addr: *httpAddr, Handler: gateway.NewHandler(db)}
+		go func() {
+			defer close(httpDone)
+			log.Printf("serving HTTP on %s", *httpAddr)
+			if err := httpSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("serve HTTP: %v", err)
+				_ = srv.Close()
+			}
+		}()
+	} else {
+		close(httpDone)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("received %v, shutting down", sig)
+		_ = srv.Close()
+	}()
+
+	log.Printf("serving %s on %s", opts.Dir, *addr)
+	err = srv.ListenAndServe(*addr)
+	if !errors.Is(err, server.ErrServerClosed) {
+		log.Printf("serve: %v", err)
+	}
+	if httpSrv != nil {
+		// 等待正在处理的HTTP请求完成
+		_ = httpSrv.Shutdown(context.Background())
+	}
+	<-httpDone
+	if closeErr := db.Close(); closeErr != nil {
+		log.Fatalf("close %s: %v", opts.Dir, closeErr)
+	}
+	if err != nil && !errors.Is(err, server.ErrServerClosed) {
+		os.Exit(1)
+	}
+}
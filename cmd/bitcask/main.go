@@ -0,0 +1,493 @@
+// bitcask 查看和操作bitcask数据目录的命令行工具
+//
+//	bitcask [-dir DIR] [-key-file FILE [-encrypt-keys]] <command> [args]
+//
+// 读取类的命令以只读方式打开数据目录，可以和其他只读的进程同时使用
+// 加密的数据目录需要通过-key-file提供密钥，文件中每行是十进制的密钥ID和十六进制的密钥，ID最大的密钥用于加密新写入的数据
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	bitcask "bitcask-go"
+	"bitcask-go/pkg/disk"
+)
+
+var errKeyNotFound = errors.New("key not found")
+
+// command 一个子命令，args是子命令名之后的参数
+type command struct {
+	usage string
+	desc  string
+	run   func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"get":     {usage: "get [-raw] KEY", desc: "print the value of KEY", run: (*cli).get},
+	"put":     {usage: "put [-ttl DURATION] KEY VALUE", desc: "set KEY to VALUE", run: (*cli).put},
+	"del":     {usage: "del KEY...", desc: "delete keys and print how many existed", run: (*cli).del},
+	"scan":    {usage: "scan [-prefix PREFIX] [-limit N] [-keys]", desc: "list keys and values in order", run: (*cli).scan},
+	"stats":   {usage: "stats", desc: "print key and data file statistics", run: (*cli).stats},
+	"dump":    {usage: "dump FILE.db", desc: "decode every log record in a data file", run: (*cli).dump},
+	"merge":   {usage: "merge", desc: "compact older data files", run: (*cli).merge},
+	"verify":  {usage: "verify [-repair]", desc: "check every log record in every data file", run: (*cli).verify},
+	"backup":  {usage: "backup TARGET", desc: "copy a consistent snapshot into the empty directory TARGET", run: (*cli).backup},
+	"restore": {usage: "restore BACKUP", desc: "restore a backup into the empty data directory", run: (*cli).restore},
+}
+
+// cli 命令执行的上下文
+type cli struct {
+	dir string
+	// encryption 没有指定-key-file时为nil
+	encryption *disk.Encryption
+	stdout     io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run 执行命令并返回进程的退出码
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("bitcask", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dir := fs.String("dir", ".", "bitcask data directory")
+	keyFile := fs.String("key-file", "", "read encryption keys from `FILE`, one \"ID HEXKEY\" per line")
+	encryptKeys := fs.Bool("encrypt-keys", false, "also encrypt keys in new data files, requires -key-file")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: bitcask [-dir DIR] [-key-file FILE [-encrypt-keys]] <command> [args]\n\ncommands:\n")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stderr, "  %-42s %s\n", commands[name].usage, commands[name].desc)
+		}
+		fmt.Fprintf(stderr, "\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "bitcask: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+	if *encryptKeys && *keyFile == "" {
+		fmt.Fprintf(stderr, "bitcask: -encrypt-keys requires -key-file\n")
+		return 2
+	}
+	c := &cli{dir: *dir, stdout: stdout}
+	if *keyFile != "" {
+		var err error
+		if c.encryption, err = loadKeyFile(*keyFile, *encryptKeys); err != nil {
+			fmt.Fprintf(stderr, "bitcask: %v\n", err)
+			return 1
+		}
+	}
+	if err := cmd.run(c, fs.Args()[1:]); err != nil {
+		fmt.Fprintf(stderr, "bitcask %s: %v\n", fs.Arg(0), err)
+		if errors.Is(err, flag.ErrHelp) || errors.Is(err, errUsage) {
+			fmt.Fprintf(stderr, "usage: bitcask %s\n", cmd.usage)
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+var errUsage = errors.New("wrong number of arguments")
+
+// options 返回数据目录和加密的配置，其余都是默认值
+func (c *cli) options() *bitcask.Options {
+	opts := bitcask.NewDefaultOptions()
+	opts.Dir = c.dir
+	opts.Encryption = c.encryption
+	return opts
+}
+
+// loadKeyFile 读取密钥文件，每行是十进制的密钥ID和十六进制的密钥，忽略空行和#开头的行
+func loadKeyFile(path string, encryptKeys bool) (*disk.Encryption, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := &disk.StaticKeyProvider{Keys: make(map[uint32][]byte)}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"ID HEXKEY\"", path, i+1)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid key id %q", path, i+1, fields[0])
+		}
+		// 不在错误信息中输出密钥
+		key, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: key is not hex encoded", path, i+1)
+		}
+		if len(keys.Keys) == 0 || uint32(id) > keys.Current {
+			keys.Current = uint32(id)
+		}
+		keys.Keys[uint32(id)] = key
+	}
+	if len(keys.Keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	encryption := &disk.Encryption{Keys: keys, EncryptKeys: encryptKeys}
+	if err = encryption.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return encryption, nil
+}
+
+// open 打开数据目录，readOnly为true时不修改目录中的任何文件
+func (c *cli) open(readOnly bool) (*bitcask.DB, error) {
+	opts := c.options()
+	opts.ReadOnly = readOnly
+	return bitcask.Open(opts)
+}
+
+// withDB 打开数据目录执行fn，fn返回后关闭
+func (c *cli) withDB(readOnly bool, fn func(db *bitcask.DB) error) error {
+	db, err := c.open(readOnly)
+	if err != nil {
+		return err
+	}
+	err = fn(db)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// subFlags 创建子命令的FlagSet，错误输出由run统一处理
+func subFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// get 和scan一样转义不可打印的value，-raw时原样输出value，不加换行
+func (c *cli) get(args []string) error {
+	fs := subFlags("get")
+	raw := fs.Bool("raw", false, "write the value as is without a trailing newline")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	key := fs.Arg(0)
+	return c.withDB(true, func(db *bitcask.DB) error {
+		value, err := db.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return fmt.Errorf("%w: %s", errKeyNotFound, key)
+		}
+		if *raw {
+			_, err = c.stdout.Write(value)
+		} else {
+			_, err = fmt.Fprintln(c.stdout, printable(value))
+		}
+		return err
+	})
+}
+
+func (c *cli) put(args []string) error {
+	fs := subFlags("put")
+	ttl := fs.Duration("ttl", 0, "expire the key after `DURATION`")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+	key, value := []byte(fs.Arg(0)), []byte(fs.Arg(1))
+	return c.withDB(false, func(db *bitcask.DB) error {
+		if *ttl != 0 {
+			return db.PutWithTTL(key, value, *ttl)
+		}
+		return db.Put(key, value)
+	})
+}
+
+func (c *cli) del(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	return c.withDB(false, func(db *bitcask.DB) error {
+		n := 0
+		for _, key := range args {
+			existed, err := db.Del([]byte(key))
+			if err != nil {
+				return err
+			}
+			if existed {
+				n++
+			}
+		}
+		_, err := fmt.Fprintln(c.stdout, n)
+		return err
+	})
+}
+
+func (c *cli) scan(args []string) error {
+	fs := subFlags("scan")
+	prefix := fs.String("prefix", "", "only list keys starting with `PREFIX`")
+	limit := fs.Int("limit", 0, "stop after `N` keys, 0 means no limit")
+	keysOnly := fs.Bool("keys", false, "only print keys")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+	return c.withDB(true, func(db *bitcask.DB) error {
+		it := db.NewIterator(bitcask.IteratorOptions{Prefix: []byte(*prefix)})
+		defer it.Close()
+		for n := 0; it.Valid() && (*limit <= 0 || n < *limit); it.Next() {
+			var err error
+			if *keysOnly {
+				_, err = fmt.Fprintln(c.stdout, printable(it.Key()))
+			} else {
+				var value []byte
+				if value, err = it.Value(); err != nil {
+					return err
+				}
+				// 迭代期间被删除的key
+				if value == nil {
+					continue
+				}
+				_, err = fmt.Fprintf(c.stdout, "%s\t%s\n", printable(it.Key()), printable(value))
+			}
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+}
+
+func (c *cli) stats(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	return c.withDB(true, func(db *bitcask.DB) error {
+		stats, err := db.Stats()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.stdout, "keys:            %d\ndata files:      %d\ndisk size:       %d\ndiscarded bytes: %d\n",
+			stats.KeyNum, stats.DataFileNum, stats.DiskSize, stats.DiscardedBytes)
+		return err
+	})
+}
+
+func (c *cli) merge(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	return c.withDB(false, func(db *bitcask.DB) error {
+		before, err := db.Stats()
+		if err != nil {
+			return err
+		}
+		if err = db.Merge(); err != nil {
+			return err
+		}
+		after, err := db.Stats()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.stdout, "disk size: %d -> %d\n", before.DiskSize, after.DiskSize)
+		return err
+	})
+}
+
+// backup 以只读方式打开时所有文件都不会再被写入，不需要切换活跃文件
+func (c *cli) backup(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return c.withDB(true, func(db *bitcask.DB) error {
+		manifest, err := db.Backup(args[0])
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.stdout, "backed up %d data files to %s\n", len(manifest.Files), args[0])
+		return err
+	})
+}
+
+func (c *cli) restore(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	db, err := bitcask.Restore(args[0], c.options())
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// dump 逐条打印数据文件中的LogRecord，crc校验失败的LogRecord也会打印出来
+func (c *cli) dump(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	dir, fid, ok := disk.ParseDataFileName(args[0])
+	if !ok {
+		return fmt.Errorf("%s is not a data file", args[0])
+	}
+	dataFile, err := disk.NewManager(dir, fid, false, 0, disk.WithEncryption(c.encryption))
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+
+	h := dataFile.Header()
+	fmt.Fprintf(c.stdout, "file %s: id=%d version=%d flags=%#x key_id=%d size=%d\n",
+		args[0], fid, h.Version, h.Flags, h.KeyID, dataFile.Size())
+	if h.Version != disk.FormatV0 {
+		fmt.Fprintf(c.stdout, "created at %s\n", time.Unix(0, h.CreatedAt).UTC().Format(time.RFC3339Nano))
+	}
+	return inspectDataFile(dataFile, func(info *disk.RecordInfo) {
+		fmt.Fprintln(c.stdout, formatRecord(info))
+	})
+}
+
+// inspectDataFile 对dataFile中的每条LogRecord调用fn，遇到无法确定长度的LogRecord时返回错误
+func inspectDataFile(dataFile disk.DataFile, fn func(info *disk.RecordInfo)) error {
+	offset := dataFile.Header().DataOffset()
+	for {
+		info, err := dataFile.InspectLogRecord(offset)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("offset %d: %w (%d trailing bytes)", offset, err, dataFile.Size()-int64(offset))
+		}
+		fn(info)
+		offset += info.Size
+	}
+}
+
+func formatRecord(info *disk.RecordInfo) string {
+	s := fmt.Sprintf("offset=%d size=%d type=%s ts=%s", info.Offset, info.Size, info.Type,
+		time.Unix(int64(info.TmStamp), 0).UTC().Format(time.RFC3339))
+	if info.ExpireAt != 0 {
+		s += " expire=" + time.Unix(0, int64(info.ExpireAt)).UTC().Format(time.RFC3339Nano)
+	}
+	if info.Seq != 0 {
+		s += " seq=" + strconv.FormatUint(info.Seq, 10)
+	}
+	if info.Type != disk.BatchCommitRecord {
+		s += " key=" + strconv.Quote(string(info.Key))
+	}
+	if info.Type == disk.NormalRecord {
+		s += " value_size=" + strconv.FormatUint(info.ValueSz, 10)
+	}
+	if info.Compressed {
+		s += " compressed"
+	}
+	switch {
+	case !info.CrcOK:
+		s += " crc=FAILED"
+	case info.Err != nil:
+		s += " crc=ok error=" + strconv.Quote(info.Err.Error())
+	default:
+		s += " crc=ok"
+	}
+	return s
+}
+
+// verify 检查目录下所有数据文件中的每一条LogRecord，-repair时重写有损坏的数据文件，只保留完整的LogRecord
+func (c *cli) verify(args []string) error {
+	fs := subFlags("verify")
+	repair := fs.Bool("repair", false, "rewrite damaged data files keeping only valid records")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+	check := bitcask.Verify
+	if *repair {
+		check = bitcask.Repair
+	}
+	report, err := check(c.options())
+	if report == nil {
+		return err
+	}
+	damaged := 0
+	for _, file := range report.Files {
+		if file.Err != nil {
+			fmt.Fprintf(c.stdout, "%s: %v\n", file.Path, file.Err)
+			damaged++
+			continue
+		}
+		for _, p := range file.Problems {
+			s := fmt.Sprintf("%s: offset=%d size=%d %s", file.Path, p.Offset, p.Size, p.Kind)
+			if p.Key != nil {
+				s += " key=" + strconv.Quote(string(p.Key))
+			}
+			fmt.Fprintln(c.stdout, s)
+		}
+		fmt.Fprintf(c.stdout, "%s: %d records, %d damaged\n", file.Path, file.Records, len(file.Problems))
+		if !file.OK() {
+			damaged++
+		}
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case damaged == 0:
+		fmt.Fprintf(c.stdout, "ok: %d data files\n", len(report.Files))
+	case *repair:
+		fmt.Fprintf(c.stdout, "repaired: %d of %d data files\n", damaged, len(report.Files))
+	default:
+		return fmt.Errorf("%d of %d data files are damaged", damaged, len(report.Files))
+	}
+	return nil
+}
+
+// printable key和value是可打印的文本时原样输出，否则输出转义后的字符串
+func printable(b []byte) string {
+	if !utf8.Valid(b) {
+		return strconv.Quote(string(b))
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) {
+			return strconv.Quote(string(b))
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitcask-go/pkg/disk"
+)
+
+func runCLI(t *testing.T, dir string, args ...string) (stdout, stderr string, code int) {
+	var out, errOut bytes.Buffer
+	code = run(append([]string{"-dir", dir}, args...), &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+func TestCLI(t *testing.T) {
+	dir := t.TempDir()
+	for _, kv := range [][2]string{{"user:1", "alice"}, {"user:2", "bob"}, {"order:1", "book"}} {
+		_, stderr, code := runCLI(t, dir, "put", kv[0], kv[1])
+		require.Zero(t, code, stderr)
+	}
+	_, stderr, code := runCLI(t, dir, "put", "-ttl", "1h", "session", "\x00\x01")
+	require.Zero(t, code, stderr)
+
+	stdout, _, code := runCLI(t, dir, "get", "user:1")
+	require.Zero(t, code)
+	require.Equal(t, "alice\n", stdout)
+	_, stderr, code = runCLI(t, dir, "get", "missing")
+	require.Equal(t, 1, code)
+	require.Contains(t, stderr, "key not found")
+	// 不可打印的value被转义，-raw时原样输出
+	stdout, _, _ = runCLI(t, dir, "get", "session")
+	require.Equal(t, "\"\\x00\\x01\"\n", stdout)
+	stdout, _, _ = runCLI(t, dir, "get", "-raw", "session")
+	require.Equal(t, "\x00\x01", stdout)
+
+	stdout, _, code = runCLI(t, dir, "scan", "-prefix", "user:")
+	require.Zero(t, code)
+	require.Equal(t, "user:1\talice\nuser:2\tbob\n", stdout)
+	stdout, _, _ = runCLI(t, dir, "scan", "-keys", "-limit", "2")
+	require.Equal(t, "order:1\nsession\n", stdout)
+	stdout, _, _ = runCLI(t, dir, "scan", "-prefix", "session")
+	require.Equal(t, "session\t\"\\x00\\x01\"\n", stdout)
+
+	stdout, _, code = runCLI(t, dir, "del", "user:2", "missing")
+	require.Zero(t, code)
+	require.Equal(t, "1\n", stdout)
+
+	stdout, _, code = runCLI(t, dir, "stats")
+	require.Zero(t, code)
+	require.Contains(t, stdout, "keys:            3\ndata files:      1\n")
+
+	stdout, _, code = runCLI(t, dir, "dump", disk.DataFileName(dir, 1))
+	require.Zero(t, code)
+	require.Contains(t, stdout, `type=normal`)
+	require.Contains(t, stdout, `key="user:1" value_size=5 crc=ok`)
+
+	stdout, stderr, code = runCLI(t, dir, "merge")
+	require.Zero(t, code, stderr)
+	require.Contains(t, stdout, "disk size:")
+	stdout, _, code = runCLI(t, dir, "verify")
+	require.Zero(t, code)
+	require.Contains(t, stdout, "ok:")
+
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	stdout, stderr, code = runCLI(t, dir, "backup", backupDir)
+	require.Zero(t, code, stderr)
+	require.Contains(t, stdout, "backed up")
+	restoreDir := filepath.Join(t.TempDir(), "restore")
+	_, stderr, code = runCLI(t, restoreDir, "restore", backupDir)
+	require.Zero(t, code, stderr)
+	stdout, _, code = runCLI(t, restoreDir, "scan", "-keys")
+	require.Zero(t, code)
+	require.Equal(t, "order:1\nsession\nuser:1\n", stdout)
+	_, stderr, code = runCLI(t, restoreDir, "restore", backupDir)
+	require.Equal(t, 1, code)
+	require.Contains(t, stderr, "directory is not empty")
+
+	_, _, code = runCLI(t, dir, "unknown")
+	require.Equal(t, 2, code)
+	_, stderr, code = runCLI(t, dir, "get")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "usage: bitcask get [-raw] KEY")
+}
+
+func TestCLI_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(keyFile, []byte("# 旧密钥\n1 000102030405060708090a0b0c0d0e0f\n\n2 0f0e0d0c0b0a09080706050403020100\n"), 0600))
+	_, stderr, code := runCLI(t, dir, "-key-file", keyFile, "put", "user:1", "alice")
+	require.Zero(t, code, stderr)
+
+	_, stderr, code = runCLI(t, dir, "get", "user:1")
+	require.Equal(t, 1, code)
+	require.Contains(t, stderr, "encrypted")
+	stdout, stderr, code := runCLI(t, dir, "-key-file", keyFile, "get", "user:1")
+	require.Zero(t, code, stderr)
+	require.Equal(t, "alice\n", stdout)
+	stdout, _, _ = runCLI(t, dir, "-key-file", keyFile, "scan")
+	require.Equal(t, "user:1\talice\n", stdout)
+
+	// dump和get使用同样的密钥
+	name := disk.DataFileName(dir, 1)
+	_, stderr, code = runCLI(t, dir, "dump", name)
+	require.Equal(t, 1, code)
+	require.Contains(t, stderr, "encrypted")
+	stdout, stderr, code = runCLI(t, dir, "-key-file", keyFile, "dump", name)
+	require.Zero(t, code, stderr)
+	require.Contains(t, stdout, "key_id=2")
+	require.Contains(t, stdout, `key="user:1"`)
+	require.Contains(t, stdout, "crc=ok")
+
+	require.NoError(t, os.WriteFile(keyFile, []byte("1 not-hex\n"), 0600))
+	_, stderr, code = runCLI(t, dir, "-key-file", keyFile, "get", "user:1")
+	require.Equal(t, 1, code)
+	require.Contains(t, stderr, "keys:1: key is not hex encoded")
+	_, stderr, code = runCLI(t, dir, "-encrypt-keys", "get", "user:1")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "-encrypt-keys requires -key-file")
+}
+
+func TestCLI_DumpCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	for _, key := range []string{"k1", "k2"} {
+		_, stderr, code := runCLI(t, dir, "put", key, "value")
+		require.Zero(t, code, stderr)
+	}
+	// 第一个文件的value被篡改，并且末尾有不完整的数据
+	name := disk.DataFileName(dir, 1)
+	content, err := os.ReadFile(name)
+	require.NoError(t, err)
+	content[len(content)-1] ^= 0xff
+	content = append(content, 1, 2, 3)
+	require.NoError(t, os.WriteFile(name, content, 0600))
+
+	stdout, stderr, code := runCLI(t, dir, "dump", name)
+	require.Equal(t, 1, code)
+	require.Contains(t, stdout, "crc=FAILED")
+	require.Contains(t, stderr, "3 trailing bytes")
+
+	stdout, stderr, code = runCLI(t, dir, "verify")
+	require.Equal(t, 1, code)
+	require.Contains(t, stdout, "crc-mismatch key=\"k2\"\n")
+	require.Contains(t, stdout, "size=3 truncated\n")
+	require.Contains(t, stdout, filepath.Base(name)+": 1 records, 2 damaged\n")
+	require.Contains(t, stderr, "1 of 1 data files are damaged")
+
+	// 修复后只剩下完整的LogRecord
+	stdout, stderr, code = runCLI(t, dir, "verify", "-repair")
+	require.Zero(t, code, stderr)
+	require.Contains(t, stdout, "repaired: 1 of 1 data files\n")
+	stdout, _, code = runCLI(t, dir, "verify")
+	require.Zero(t, code)
+	require.Equal(t, name+": 1 records, 0 damaged\nok: 1 data files\n", stdout)
+	stdout, _, _ = runCLI(t, dir, "scan")
+	require.Equal(t, "k1\tvalue\n", stdout)
+}
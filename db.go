@@ -2,20 +2,56 @@ package bitcast_go
 
 import (
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"bitcask-go/pkg/disk"
 	"bitcask-go/pkg/index"
 )
 
+// DB 可以在多个goroutine中并发使用
+//
+// 并发模型:
+//   - mu保护数据文件集合(activeFile、oldFiles)和closed。Get、Put、Del、批量写入在追加写和读文件时都只持有读锁，
+//     只有切换活跃文件、merge替换文件和Close持有写锁
+//   - 索引自身是并发安全的，读索引不需要额外的锁
+//   - 同一个key的写入由keyLocks串行化，保证索引中的顺序和磁盘上的顺序一致，不同key的写入可以并发并共享fsync
 type DB struct {
 	Opts       *Options
 	maxFileID  atomic.Uint64
 	index      index.Indexer
 	activeFile disk.DataFile
 	oldFiles   map[uint64]disk.DataFile
+	// mu 保护activeFile、oldFiles和closed
+	mu sync.RWMutex
+	// closed Close之后所有操作返回ErrDBClosed
+	closed bool
+	// merging 同一时刻只允许一个merge，备份期间也不允许merge
+	merging atomic.Bool
+	// mergeWG Close等待正在进行的merge和备份结束之后才能关闭文件和释放目录锁
+	mergeWG sync.WaitGroup
+	// seq 最近一次批量写入使用的序号
+	seq atomic.Uint64
+	// discardedBytes 启动时从活跃文件末尾丢弃的不完整LogRecord的字节数
+	discardedBytes int64
+	// keyLocks 按key分段的写锁
+	keyLocks [keyLockSlots]sync.Mutex
+	// closeCh 关闭时通知后台的syncLoop退出
+	closeCh      chan struct{}
+	syncLoopDone chan struct{}
+	closeOnce    sync.Once
+	// lock 数据目录上的文件锁，Open时获取，Close时释放，NewDb创建的DB没有
+	lock *dirLock
 }
 
+const keyLockSlots = 64
+
 func NewDb(opts *Options) *DB {
 	db := new(DB)
 	db.Opts = opts
@@ -24,80 +60,652 @@ func NewDb(opts *Options) *DB {
 	return db
 }
 
+// Open 打开opts.Dir下的数据库
+// 目录下已有的数据文件会按文件ID顺序重放进内存索引，ID最大的文件作为活跃文件继续追加写
+// 没有文件头的旧格式数据文件会先被改写成当前的格式
+// opts.ReadOnly为true时以只读方式打开所有数据文件，不会对目录做任何修改，多个只读的DB可以同时打开同一个目录
+// 只读的DB持有目录的共享锁，和以读写方式打开的DB互斥，加载和读取期间数据文件不会被merge替换
+func Open(opts *Options) (*DB, error) {
+	if !disk.ValidFormatVersion(opts.formatVersion()) {
+		return nil, fmt.Errorf("%w: %d", disk.ErrUnsupportedVersion, opts.formatVersion())
+	}
+	if !opts.Compression.Valid() {
+		return nil, fmt.Errorf("%w: %d", disk.ErrUnknownCompression, opts.Compression)
+	}
+	if opts.Encryption != nil {
+		if err := opts.Encryption.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.ReadOnly {
+		if _, err := os.Stat(opts.Dir); err != nil {
+			return nil, err
+		}
+	} else if err := os.MkdirAll(opts.Dir, os.FileMode(0755)); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(opts.Dir, opts.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ReadOnly {
+		err = checkPendingMerge(opts.Dir)
+	} else if err = recoverMerge(opts.storage(), opts.Dir); err == nil {
+		err = migrateDataFiles(opts.storage(), opts.Dir)
+	}
+	if err != nil {
+		_ = lock.release()
+		return nil, err
+	}
+	db := NewDb(opts)
+	db.lock = lock
+	if err := db.loadDataFiles(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err := db.loadIndex(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err := db.rotateForEncryption(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if syncOpts := opts.syncOptions(); !opts.ReadOnly && syncOpts.Policy == disk.SyncEveryInterval && syncOpts.Interval > 0 {
+		db.closeCh = make(chan struct{})
+		db.syncLoopDone = make(chan struct{})
+		go db.syncLoop(syncOpts.Interval)
+	}
+	return db, nil
+}
+
+// rotateForEncryption 活跃文件的加密方式或者密钥和当前配置不一致时切换到新的活跃文件
+// 保证打开之后的写入都按照当前的配置加密
+func (d *DB) rotateForEncryption() error {
+	if d.Opts.ReadOnly || d.activeFile == nil {
+		return nil
+	}
+	matches, err := d.Opts.Encryption.Matches(d.activeFile.Header())
+	if err != nil || matches {
+		return err
+	}
+	return d.rotateActiveFile()
+}
+
+// migrateDataFiles 将目录下所有FormatV0的数据文件改写成当前的格式
+func migrateDataFiles(s disk.Storage, dir string) error {
+	fileIDs, err := disk.ListDataFileIDs(s, dir)
+	if err != nil {
+		return err
+	}
+	for _, fid := range fileIDs {
+		if _, err = disk.MigrateDataFile(s, dir, fid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadDataFiles 打开目录下所有的数据文件，ID最大的作为活跃文件，其余作为older file
+// 只读模式下所有文件都作为older file打开
+func (d *DB) loadDataFiles() error {
+	fileIDs, err := disk.ListDataFileIDs(d.Opts.storage(), d.Opts.Dir)
+	if err != nil {
+		return err
+	}
+	for i, fid := range fileIDs {
+		isActiveFile := i == len(fileIDs)-1 && !d.Opts.ReadOnly
+		dataFile, err := d.openDataFile(fid, isActiveFile)
+		if err != nil {
+			return err
+		}
+		if isActiveFile {
+			d.activeFile = dataFile
+		} else {
+			d.oldFiles[fid] = dataFile
+		}
+		d.maxFileID.Store(fid)
+	}
+	return nil
+}
+
+// loadIndex 按文件ID从小到大重放所有LogRecord，重建内存索引
+func (d *DB) loadIndex() error {
+	fileIDs := make([]uint64, 0, len(d.oldFiles)+1)
+	for fid := range d.oldFiles {
+		fileIDs = append(fileIDs, fid)
+	}
+	sort.Slice(fileIDs, func(i, j int) bool { return fileIDs[i] < fileIDs[j] })
+	if d.activeFile != nil {
+		fileIDs = append(fileIDs, d.activeFile.ID())
+	}
+
+	// 批量写入可能跨越多个文件，还没读到提交标记的LogRecord在所有文件之间共享
+	batches := make(pendingBatches)
+	for i, fid := range fileIDs {
+		dataFile := d.oldFiles[fid]
+		if dataFile == nil {
+			dataFile = d.activeFile
+		}
+		// 优先使用merge生成的hint文件，不存在或者校验不通过时再扫描数据文件
+		loaded, err := d.loadIndexFromHint(dataFile)
+		if err != nil {
+			return err
+		}
+		if loaded {
+			continue
+		}
+		offset, err := d.loadIndexFromDataFile(dataFile, batches)
+		if err == nil {
+			continue
+		}
+		// 损坏的数据后面还有完整的LogRecord说明不是写到一半崩溃，截断会丢掉这些数据，需要用Repair处理
+		if i != len(fileIDs)-1 || !disk.IsCorruptRecord(err) || !disk.IsTornTail(dataFile, offset) {
+			return fmt.Errorf("load data file %d at offset %d: %w", fid, offset, err)
+		}
+		// 写入活跃文件的过程中进程崩溃，末尾会留下不完整的LogRecord，截断到最后一条完整的LogRecord继续使用
+		// 只读模式下不能修改文件，只是忽略末尾的数据
+		d.discardedBytes = dataFile.Size() - int64(offset)
+		if d.Opts.ReadOnly {
+			continue
+		}
+		if err = dataFile.Truncate(int64(offset)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiscardedBytes 返回启动恢复时从活跃文件末尾丢弃的字节数
+func (d *DB) DiscardedBytes() int64 {
+	return d.discardedBytes
+}
+
+// Stats DB的统计信息
+type Stats struct {
+	// KeyNum 没有过期的key的数量
+	KeyNum int
+	// DataFileNum 数据文件的数量，包括活跃文件
+	DataFileNum int
+	// DiskSize 所有数据文件的总大小
+	DiskSize int64
+	// DiscardedBytes 启动恢复时从活跃文件末尾丢弃的字节数
+	DiscardedBytes int64
+}
+
+// Stats 返回DB当前的统计信息，统计key的数量需要遍历整个索引
+func (d *DB) Stats() (Stats, error) {
+	d.mu.RLock()
+	if d.closed {
+		d.mu.RUnlock()
+		return Stats{}, ErrDBClosed
+	}
+	stats := Stats{DataFileNum: len(d.oldFiles), DiscardedBytes: d.discardedBytes}
+	for _, dataFile := range d.oldFiles {
+		stats.DiskSize += dataFile.Size()
+	}
+	if d.activeFile != nil {
+		stats.DataFileNum++
+		stats.DiskSize += d.activeFile.Size()
+	}
+	d.mu.RUnlock()
+
+	it := d.NewIterator(IteratorOptions{})
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		stats.KeyNum++
+	}
+	return stats, nil
+}
+
+// loadIndexFromHint 使用hint文件加载dataFile中的key，hint文件不可用时返回false
+func (d *DB) loadIndexFromHint(dataFile disk.DataFile) (bool, error) {
+	entries, err := disk.ReadHintFile(d.Opts.Dir, dataFile)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, disk.ErrInvalidHint) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UnixNano()
+	for _, entry := range entries {
+		// 已经过期的key当作被删除处理，同时覆盖掉更早文件中的旧值
+		if entry.VMeta.IsExpired(now) {
+			err = d.index.Del(entry.Key)
+		} else {
+			err = d.index.Set(entry.Key, entry.VMeta)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// loadIndexFromDataFile 重放dataFile中的所有LogRecord，返回最后一条完整LogRecord的结束位置
+func (d *DB) loadIndexFromDataFile(dataFile disk.DataFile, batches pendingBatches) (offset uint64, err error) {
+	offset = dataFile.Header().DataOffset()
+	for {
+		record, size, err := dataFile.ReadLogRecord(offset)
+		if errors.Is(err, io.EOF) {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		vMeta := index.NewValueMetadata(dataFile.ID(), size, offset, int64(record.TmStamp()))
+		vMeta.ExpireAt = int64(record.ExpireAt())
+		if err = d.replayLogRecord(record, vMeta, batches); err != nil {
+			return offset, err
+		}
+		offset += size
+	}
+}
+
+// replayLogRecord 将一条LogRecord应用到索引，批量写入的LogRecord要等读到提交标记后才生效
+func (d *DB) replayLogRecord(record *disk.LogRecord, vMeta *index.ValueMetadata, batches pendingBatches) error {
+	seq := record.Seq()
+	if seq == 0 {
+		return d.applyLogRecord(record, vMeta)
+	}
+	if seq > d.seq.Load() {
+		d.seq.Store(seq)
+	}
+	if record.Op() != disk.BatchCommitRecord {
+		batches[seq] = append(batches[seq], pendingRecord{record: record, vMeta: vMeta})
+		return nil
+	}
+	for _, p := range batches[seq] {
+		if err := d.applyLogRecord(p.record, p.vMeta); err != nil {
+			return err
+		}
+	}
+	delete(batches, seq)
+	return nil
+}
+
+func (d *DB) applyLogRecord(record *disk.LogRecord, vMeta *index.ValueMetadata) error {
+	switch record.Op() {
+	case disk.NormalRecord:
+		// 已经过期的LogRecord和删除记录一样，同一个key更早的值也不再可见
+		if record.IsExpired(time.Now()) {
+			return d.index.Del(record.Key())
+		}
+		return d.index.Set(record.Key(), vMeta)
+	case disk.DeleteRecord:
+		return d.index.Del(record.Key())
+	}
+	return nil
+}
+
 // Put - put key-value to db
 func (d *DB) Put(key, value []byte) (err error) {
-	if d.activeFile == nil {
-		d.activeFile, err = disk.NewManager(d.Opts.Dir, d.maxFileID.Add(1), true, d.Opts.MaxSize)
+	unlock := d.lockKey(key)
+	defer unlock()
+	return d.appendToActiveFile(func(activeFile disk.DataFile, force bool) error {
+		vMeta, err := activeFile.Write(key, value, force)
 		if err != nil {
-			return
+			return err
 		}
+		return d.index.Set(key, vMeta)
+	})
+}
+
+// PutWithTTL 写入一个ttl之后过期的key，过期后Get返回nil，recovery和merge会丢弃过期的数据
+func (d *DB) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidTTL, ttl)
 	}
+	unlock := d.lockKey(key)
+	defer unlock()
+	return d.putWithTTL(key, value, ttl)
+}
 
-	vMeta, err := d.activeFile.Write(key, value, false)
-	if errors.Is(err, disk.ErrFileTooSmall) {
-		// active file to old file
-		oldFile, err := d.activeFile.ToOlderFile()
+// Expire 让已有的key在ttl之后过期，返回key是否存在，key不存在或者已经过期时什么都不写
+// 会用原来的value重新写入一条带有过期时间的LogRecord
+func (d *DB) Expire(key []byte, ttl time.Duration) (existed bool, err error) {
+	if ttl <= 0 {
+		return false, fmt.Errorf("%w: %v", ErrInvalidTTL, ttl)
+	}
+	if d.Opts.ReadOnly {
+		return false, ErrReadOnly
+	}
+	unlock := d.lockKey(key)
+	defer unlock()
+	vMeta, err := d.lookup(key)
+	if err != nil || vMeta == nil {
+		return false, err
+	}
+	value, err := d.Get(key)
+	if err != nil {
+		return false, err
+	}
+	err = d.putWithTTL(key, value, ttl)
+	return err == nil, err
+}
+
+// putWithTTL 写入一个ttl之后过期的key，调用方需要持有key的锁
+func (d *DB) putWithTTL(key, value []byte, ttl time.Duration) error {
+	record, err := disk.NewNormalLogRecord(key, value)
+	if err != nil {
+		return err
+	}
+	record.SetExpireAt(uint64(time.Now().Add(ttl).UnixNano()))
+	return d.appendToActiveFile(func(activeFile disk.DataFile, force bool) error {
+		vMeta, err := activeFile.WriteLogRecord(record, force)
 		if err != nil {
 			return err
 		}
-		d.oldFiles[oldFile.ID()] = oldFile
-		d.activeFile, err = disk.NewManager(d.Opts.Dir, d.maxFileID.Add(1), true, d.Opts.MaxSize)
-		if err != nil {
+		return d.index.Set(key, vMeta)
+	})
+}
+
+// appendToActiveFile 在活跃文件中追加写并更新索引，活跃文件空间不足时(write返回disk.ErrFileTooSmall)切换到新的活跃文件再强制写入
+// 追加写只持有读锁，不同key的写入可以并发进行并共享同一次fsync，只有切换活跃文件时才持有写锁
+// 更新索引也要在锁内完成，否则merge可能在写入和更新索引之间把刚写入的LogRecord当作无效数据丢掉
+func (d *DB) appendToActiveFile(write func(activeFile disk.DataFile, force bool) error) error {
+	if d.Opts.ReadOnly {
+		return ErrReadOnly
+	}
+	d.mu.RLock()
+	if d.closed {
+		d.mu.RUnlock()
+		return ErrDBClosed
+	}
+	activeFile := d.activeFile
+	if activeFile != nil {
+		err := write(activeFile, false)
+		if !errors.Is(err, disk.ErrFileTooSmall) {
+			d.mu.RUnlock()
 			return err
 		}
-		vMeta, err = d.activeFile.Write(key, value, true)
+	}
+	d.mu.RUnlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrDBClosed
+	}
+	// 等待写锁期间其他写入者可能已经切换过活跃文件了
+	if d.activeFile != nil && d.activeFile != activeFile {
+		err := write(d.activeFile, false)
+		if !errors.Is(err, disk.ErrFileTooSmall) {
+			return err
+		}
+	}
+	if err := d.rotateActiveFile(); err != nil {
+		return err
+	}
+	return write(d.activeFile, true)
+}
+
+// rotateActiveFile 将当前活跃文件转换成older file，并新开一个活跃文件，调用方需要持有写锁
+func (d *DB) rotateActiveFile() error {
+	if d.activeFile != nil {
+		oldFile, err := d.activeFile.ToOlderFile()
 		if err != nil {
 			return err
 		}
+		d.oldFiles[oldFile.ID()] = oldFile
 	}
+	activeFile, err := d.openDataFile(d.maxFileID.Add(1), true)
+	if err != nil {
+		return err
+	}
+	d.activeFile = activeFile
+	return nil
+}
+
+// openDataFile 按照Options打开Dir下的数据文件
+func (d *DB) openDataFile(fid uint64, isActiveFile bool) (disk.DataFile, error) {
+	opts := append(d.Opts.encodingOptions(), disk.WithSyncOptions(d.Opts.syncOptions()), disk.WithMmap(d.Opts.Mmap))
+	return disk.NewManager(d.Opts.Dir, fid, isActiveFile, d.Opts.MaxSize, opts...)
+}
 
-	return d.index.Set(key, vMeta)
+// lockKey 锁住key所在的分段，同一个key的写入串行执行，保证索引中的顺序和磁盘上的顺序一致
+func (d *DB) lockKey(key []byte) (unlock func()) {
+	mu := &d.keyLocks[keySlot(key)]
+	mu.Lock()
+	return mu.Unlock
+}
 
+func keySlot(key []byte) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return h.Sum32() % keyLockSlots
+}
+
+// Sync 将活跃文件中已经写入的数据同步到磁盘
+func (d *DB) Sync() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return ErrDBClosed
+	}
+	if d.activeFile == nil {
+		return nil
+	}
+	return d.activeFile.Sync()
+}
+
+// syncLoop SyncEveryInterval策略下，即使没有新的写入也按时同步
+func (d *DB) syncLoop(interval time.Duration) {
+	defer close(d.syncLoopDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = d.Sync()
+		case <-d.closeCh:
+			return
+		}
+	}
 }
 
 // Get - get value from db
+// 返回的value由调用方持有，打开Mmap时会从映射的内存中拷贝出来
 func (d *DB) Get(key []byte) (value []byte, err error) {
+	// 读锁保证读取期间对应的数据文件不会被merge替换
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	value, zeroCopy, err := d.read(key)
+	if err != nil || !zeroCopy {
+		return value, err
+	}
+	return append([]byte(nil), value...), nil
+}
+
+// View 读取key的值交给fn处理，key不存在时value为nil
+// 打开Mmap时value可能直接引用映射的内存，只在fn执行期间有效，需要保留的话要自己拷贝
+// fn执行期间持有读锁，fn中不能再调用DB的方法
+func (d *DB) View(key []byte, fn func(value []byte) error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	value, _, err := d.read(key)
+	if err != nil {
+		return err
+	}
+	return fn(value)
+}
+
+// read 读取key的值，zeroCopy表示value可能引用了mmap映射的内存，调用方需要持有读锁
+func (d *DB) read(key []byte) (value []byte, zeroCopy bool, err error) {
+	if d.closed {
+		return nil, false, ErrDBClosed
+	}
 	vMeta, err := d.index.Get(key)
 	if err != nil {
-		return
+		return nil, false, err
+	}
+	// key not seen or expired
+	if vMeta == nil || vMeta.IsExpired(time.Now().UnixNano()) {
+		return nil, false, nil
+	}
+	dataFile, err := d.getDataFile(vMeta.FileID)
+	if err != nil {
+		return nil, false, err
+	}
+	value, err = dataFile.Read(vMeta)
+	return value, d.Opts.Mmap && dataFile != d.activeFile, err
+}
+
+// getDataFile 根据文件ID找到对应的数据文件，活跃文件和older file都会查找
+func (d *DB) getDataFile(fid uint64) (disk.DataFile, error) {
+	if d.activeFile != nil && d.activeFile.ID() == fid {
+		return d.activeFile, nil
 	}
-	// key not seen
-	if vMeta == nil {
-		return nil, nil
+	if dataFile, ok := d.oldFiles[fid]; ok {
+		return dataFile, nil
 	}
-	return d.activeFile.Read(vMeta)
+	return nil, fmt.Errorf("%w: file id %d", ErrDataFileNotFound, fid)
 }
 
 // Del - delete key-value from db
-func (d *DB) Del(key []byte) (err error) {
-	// TODO 这里可以优化，如果key根本不在Index中直接返回就行了。
-	// 当前的实现甭管有没有都会生成logRecord
-	vm, err := d.activeFile.Del(key, true)
-	if err != nil {
-		return err
+// 返回key删除之前是否存在，key不存在或者已经过期时什么都不写
+func (d *DB) Del(key []byte) (existed bool, err error) {
+	if d.Opts.ReadOnly {
+		return false, ErrReadOnly
 	}
-	err = d.index.Del(key)
-	if err != nil {
-		return err
+	unlock := d.lockKey(key)
+	defer unlock()
+	// 持有key的锁，查询之后到写入删除记录之前key不会被其他写入者修改
+	vMeta, err := d.lookup(key)
+	if err != nil || vMeta == nil {
+		return false, err
 	}
-	if vm != nil {
-		return d.index.Set(key, vm)
+	err = d.appendToActiveFile(func(activeFile disk.DataFile, force bool) error {
+		if _, err := activeFile.Del(key, force); err != nil {
+			return err
+		}
+		return d.index.Del(key)
+	})
+	return err == nil, err
+}
+
+// DeleteRange 删除[start, end)中的所有key，start或end为nil时表示不限制，返回删除的key的数量
+// 所有删除作为一次批量写入原子地生效，只删除调用时已经存在并且提交时仍然存在的key，调用期间新写入的key可能不会被删除
+func (d *DB) DeleteRange(start, end []byte) (int, error) {
+	return d.deleteKeys(IteratorOptions{Start: start, End: end})
+}
+
+// DeletePrefix 删除所有带有prefix前缀的key，返回删除的key的数量
+func (d *DB) DeletePrefix(prefix []byte) (int, error) {
+	return d.deleteKeys(IteratorOptions{Prefix: prefix})
+}
+
+// deleteKeys 在一次批量写入中删除opts范围内的所有key
+func (d *DB) deleteKeys(opts IteratorOptions) (int, error) {
+	if d.Opts.ReadOnly {
+		return 0, ErrReadOnly
 	}
-	return nil
+	d.mu.RLock()
+	closed := d.closed
+	d.mu.RUnlock()
+	if closed {
+		return 0, ErrDBClosed
+	}
+	var keys [][]byte
+	it := d.NewIterator(opts)
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	it.Close()
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	// 遍历时没有持有key的锁，加锁之后重新检查，遍历之后被删除或者过期的key不再写入删除记录
+	unlock := d.lockKeys(keys)
+	defer unlock()
+	wb := d.NewWriteBatch()
+	n := 0
+	for _, key := range keys {
+		vMeta, err := d.lookup(key)
+		if err != nil {
+			return 0, err
+		}
+		if vMeta == nil {
+			continue
+		}
+		if err = wb.Delete(key); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := wb.commitLocked(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Exists 判断key是否存在并且没有过期，不需要读取value
+func (d *DB) Exists(key []byte) (bool, error) {
+	vMeta, err := d.lookup(key)
+	return vMeta != nil, err
 }
 
+// lookup 返回key在索引中的位置，key不存在或者已经过期时返回nil
+func (d *DB) lookup(key []byte) (*index.ValueMetadata, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return nil, ErrDBClosed
+	}
+	vMeta, err := d.index.Get(key)
+	if err != nil || vMeta == nil || vMeta.IsExpired(time.Now().UnixNano()) {
+		return nil, err
+	}
+	return vMeta, nil
+}
+
+// Close 关闭所有数据文件，会等待正在进行的读写、merge和备份完成，重复Close返回ErrDBClosed
 func (d *DB) Close() error {
-	if err := d.activeFile.Close(); err != nil {
-		return err
+	// syncLoop会获取读锁，必须在获取写锁之前让它退出
+	d.closeOnce.Do(func() {
+		if d.closeCh != nil {
+			close(d.closeCh)
+			<-d.syncLoopDone
+		}
+	})
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return ErrDBClosed
 	}
+	d.closed = true
+	d.mu.Unlock()
+	// merge替换文件时需要写锁，不能持有写锁等待，merge发现DB关闭之后会尽快退出
+	d.mergeWG.Wait()
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
 	// 返回遇到的第一个错误
 	var err error
+	if d.activeFile != nil {
+		// SyncNever之外的策略下正常关闭之后所有写入都已经落盘，新建文件的目录项在创建时已经同步过
+		if d.Opts.syncOptions().Policy != disk.SyncNever {
+			err = d.activeFile.Sync()
+		}
+		if closeErr := d.activeFile.Close(); err == nil {
+			err = closeErr
+		}
+	}
 	for _, v := range d.oldFiles {
 		newErr := v.Close()
 		if err == nil && newErr != nil {
 			err = newErr
 		}
 	}
+	// 所有文件关闭之后才能释放目录锁
+	if d.lock != nil {
+		if newErr := d.lock.release(); err == nil {
+			err = newErr
+		}
+	}
 	return err
 }
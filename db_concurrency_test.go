@@ -0,0 +1,243 @@
+package bitcast_go
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 这些测试需要配合 go test -race 运行
+
+func TestDB_ConcurrentPutGetDel(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		// 很小的文件，让写入不停地切换活跃文件
+		MaxSizeOption(256),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	const (
+		writers = 8
+		keys    = 50
+		rounds  = 20
+	)
+	var wg sync.WaitGroup
+	// 每个writer只写自己的key，最后可以校验结果
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				for k := 0; k < keys; k++ {
+					key := []byte(fmt.Sprintf("w%d-key-%d", w, k))
+					if !assert.NoError(t, db.Put(key, []byte(fmt.Sprintf("w%d-value-%d-%d", w, k, r)))) {
+						return
+					}
+					if k%5 == 0 {
+						if _, err := db.Del(key); !assert.NoError(t, err) {
+							return
+						}
+					}
+				}
+			}
+		}(w)
+	}
+	// 所有goroutine共享的key，只用来制造竞争
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(g)))
+			for i := 0; i < 500; i++ {
+				key := []byte(fmt.Sprintf("shared-%d", rnd.Intn(10)))
+				var err error
+				switch rnd.Intn(3) {
+				case 0:
+					err = db.Put(key, []byte(fmt.Sprintf("%d-%d", g, i)))
+				case 1:
+					_, err = db.Del(key)
+				default:
+					_, err = db.Get(key)
+				}
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+		}(g)
+	}
+	// 读者
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if _, err := db.Get([]byte(fmt.Sprintf("w%d-key-%d", i%writers, i%keys))); !assert.NoError(t, err) {
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	check := func(db *DB) {
+		for w := 0; w < writers; w++ {
+			for k := 0; k < keys; k++ {
+				val, err := db.Get([]byte(fmt.Sprintf("w%d-key-%d", w, k)))
+				require.NoError(t, err)
+				if k%5 == 0 {
+					require.Nil(t, val)
+				} else {
+					require.Equal(t, []byte(fmt.Sprintf("w%d-value-%d-%d", w, k, rounds-1)), val)
+				}
+			}
+		}
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_ConcurrentMergeBatchIterator(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(512),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	var (
+		wg   sync.WaitGroup
+		stop = make(chan struct{})
+	)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 300; i++ {
+				if !assert.NoError(t, db.Put([]byte(fmt.Sprintf("w%d-%d", w, i%30)), []byte(fmt.Sprintf("%d", i)))) {
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			wb := db.NewWriteBatch()
+			if !assert.NoError(t, wb.Put([]byte("batch-a"), []byte(fmt.Sprintf("%d", i)))) ||
+				!assert.NoError(t, wb.Put([]byte("batch-b"), []byte(fmt.Sprintf("%d", i)))) ||
+				!assert.NoError(t, wb.Commit()) {
+				return
+			}
+		}
+	}()
+
+	var bg sync.WaitGroup
+	bg.Add(2)
+	go func() {
+		defer bg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := db.Merge(); err != nil && !assert.ErrorIs(t, err, ErrMergeInProgress) {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	go func() {
+		defer bg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			it := db.NewIterator(IteratorOptions{Prefix: []byte("w")})
+			for ; it.Valid(); it.Next() {
+				if _, err := it.Value(); !assert.NoError(t, err) {
+					break
+				}
+			}
+			it.Close()
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	bg.Wait()
+
+	check := func(db *DB) {
+		for w := 0; w < 4; w++ {
+			for k := 0; k < 30; k++ {
+				val, err := db.Get([]byte(fmt.Sprintf("w%d-%d", w, k)))
+				require.NoError(t, err)
+				require.Equal(t, []byte(fmt.Sprintf("%d", 270+k)), val)
+			}
+		}
+		a, err := db.Get([]byte("batch-a"))
+		require.NoError(t, err)
+		b, err := db.Get([]byte("batch-b"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("99"), a)
+		require.Equal(t, a, b)
+	}
+	check(db)
+	require.NoError(t, db.Merge())
+	check(db)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Closed(t *testing.T) {
+	db, err := Open(NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(defaultDataFileSize),
+	}))
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key"), []byte("value")))
+
+	// Close会等待正在进行的写入完成，之后的操作都返回ErrDBClosed
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				err := db.Put([]byte(fmt.Sprintf("%d-%d", g, i)), []byte("value"))
+				if err != nil {
+					assert.ErrorIs(t, err, ErrDBClosed)
+					return
+				}
+			}
+		}(g)
+	}
+	require.NoError(t, db.Close())
+	wg.Wait()
+
+	require.ErrorIs(t, db.Close(), ErrDBClosed)
+	require.ErrorIs(t, db.Put([]byte("key"), []byte("value")), ErrDBClosed)
+	_, err = db.Del([]byte("key"))
+	require.ErrorIs(t, err, ErrDBClosed)
+	_, err = db.Get([]byte("key"))
+	require.ErrorIs(t, err, ErrDBClosed)
+	require.ErrorIs(t, db.Sync(), ErrDBClosed)
+	require.ErrorIs(t, db.Merge(), ErrDBClosed)
+}
@@ -1,9 +1,21 @@
 package bitcast_go
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"bitcask-go/pkg/disk"
 )
 
 func TestDB_All(t *testing.T) {
@@ -52,10 +64,12 @@ func TestDB_AllOption(t *testing.T) {
 	require.Equal(t, []byte("value2"), val)
 
 	// del
-	err = db.Del([]byte("key1"))
+	existed, err := db.Del([]byte("key1"))
 	require.NoError(t, err)
-	err = db.Del([]byte("keyNotExist"))
+	require.True(t, existed)
+	existed, err = db.Del([]byte("keyNotExist"))
 	require.NoError(t, err)
+	require.False(t, existed)
 	gotV, err := db.Get([]byte("keyNotExist"))
 	require.NoError(t, err)
 	require.Nil(t, gotV)
@@ -69,3 +83,1222 @@ func TestDB_AllOption(t *testing.T) {
 	}
 	require.NoError(t, err)
 }
+
+func TestOpen_Reload(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(defaultDataFileSize),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key1"), []byte("value1")))
+	require.NoError(t, db.Put([]byte("key2"), []byte("value2")))
+	require.NoError(t, db.Put([]byte("key1"), []byte("value1-new")))
+	_, err = db.Del([]byte("key2"))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	val, err := db.Get([]byte("key1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value1-new"), val)
+	val, err = db.Get([]byte("key2"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+
+	// 重新打开后继续在活跃文件末尾追加
+	require.NoError(t, db.Put([]byte("key3"), []byte("value3")))
+	val, err = db.Get([]byte("key3"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value3"), val)
+	require.NoError(t, db.Close())
+}
+
+func TestOpen_ReloadMultiFiles(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(64),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Put([]byte("key"), []byte{byte(i)}))
+	}
+	require.NoError(t, db.Put([]byte("other"), []byte("value")))
+	maxFileID := db.maxFileID.Load()
+	require.Greater(t, maxFileID, uint64(1))
+	want, err := db.index.Get([]byte("key"))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Equal(t, maxFileID, db.maxFileID.Load())
+	require.Equal(t, maxFileID, db.activeFile.ID())
+	require.Len(t, db.oldFiles, int(maxFileID-1))
+	got, err := db.index.Get([]byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	val, err := db.Get([]byte("other"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), val)
+	require.NoError(t, db.Close())
+}
+
+func TestOpen_EmptyDir(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(defaultDataFileSize),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.Nil(t, db.activeFile)
+	require.Zero(t, db.maxFileID.Load())
+	require.NoError(t, db.Close())
+}
+
+func TestDB_GetAcrossRotation(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(100),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	const n = 50
+	for i := 0; i < n; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i))))
+	}
+	require.Greater(t, len(db.oldFiles), 10)
+	check := func() {
+		for i := 0; i < n; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%03d", i)))
+			require.NoError(t, err)
+			require.Equal(t, []byte(fmt.Sprintf("value-%03d", i)), val)
+		}
+	}
+	check()
+	require.NoError(t, db.Close())
+
+	// 重启后older file中的key同样可以读到
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check()
+
+	// 数据文件缺失时返回ErrDataFileNotFound
+	vMeta, err := db.index.Get([]byte("key-000"))
+	require.NoError(t, err)
+	oldFile := db.oldFiles[vMeta.FileID]
+	require.NotNil(t, oldFile)
+	require.NoError(t, oldFile.Close())
+	delete(db.oldFiles, vMeta.FileID)
+	_, err = db.Get([]byte("key-000"))
+	require.ErrorIs(t, err, ErrDataFileNotFound)
+	require.NoError(t, db.Close())
+}
+
+func TestOpen_TruncateTornTail(t *testing.T) {
+	src := t.TempDir()
+	db, err := Open(NewOptions([]OptionsFunc{
+		DirOption(src),
+		MaxSizeOption(defaultDataFileSize),
+	}))
+	require.NoError(t, err)
+	// 记录每条LogRecord结束的位置
+	var boundaries []int64
+	for i := 0; i < 5; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))))
+		boundaries = append(boundaries, db.activeFile.Size())
+	}
+	_, err = db.Del([]byte("key-0"))
+	require.NoError(t, err)
+	boundaries = append(boundaries, db.activeFile.Size())
+	dataOffset := int64(db.activeFile.Header().DataOffset())
+	require.NoError(t, db.Close())
+	content, err := os.ReadFile(disk.DataFileName(src, 1))
+	require.NoError(t, err)
+
+	// 在每一个字节处截断活跃文件
+	for cut := 0; cut <= len(content); cut++ {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(disk.DataFileName(dir, 1), content[:cut], 0600))
+		db, err := Open(NewOptions([]OptionsFunc{
+			DirOption(dir),
+			MaxSizeOption(defaultDataFileSize),
+		}))
+		require.NoError(t, err, "cut at %d", cut)
+
+		complete := 0
+		for complete < len(boundaries) && boundaries[complete] <= int64(cut) {
+			complete++
+		}
+		good := dataOffset
+		if complete > 0 {
+			good = boundaries[complete-1]
+		}
+		if int64(cut) < dataOffset {
+			// 文件头不完整时重新写入文件头，没有LogRecord被丢弃
+			require.Zero(t, db.DiscardedBytes(), "cut at %d", cut)
+		} else {
+			require.Equal(t, int64(cut)-good, db.DiscardedBytes(), "cut at %d", cut)
+		}
+		require.Equal(t, good, db.activeFile.Size(), "cut at %d", cut)
+		for i := 0; i < 5; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%d", i)))
+			require.NoError(t, err)
+			if i < complete && !(i == 0 && complete == 6) {
+				require.Equal(t, []byte(fmt.Sprintf("value-%d", i)), val, "cut at %d", cut)
+			} else {
+				require.Nil(t, val, "cut at %d", cut)
+			}
+		}
+
+		// 截断后可以继续写入
+		require.NoError(t, db.Put([]byte("after"), []byte("crash")))
+		require.NoError(t, db.Close())
+		db, err = Open(db.Opts)
+		require.NoError(t, err)
+		require.Zero(t, db.DiscardedBytes())
+		val, err := db.Get([]byte("after"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("crash"), val)
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestOpen_TruncateCrcFailedTail(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(defaultDataFileSize),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key1"), []byte("value1")))
+	good := db.activeFile.Size()
+	require.NoError(t, db.Put([]byte("key2"), []byte("value2")))
+	size := db.activeFile.Size()
+	require.NoError(t, db.Close())
+
+	// 篡改最后一条LogRecord的value
+	name := disk.DataFileName(opts.Dir, 1)
+	content, err := os.ReadFile(name)
+	require.NoError(t, err)
+	content[len(content)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(name, content, 0600))
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Equal(t, size-good, db.DiscardedBytes())
+	val, err := db.Get([]byte("key1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value1"), val)
+	val, err = db.Get([]byte("key2"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+	require.NoError(t, db.Close())
+}
+
+func TestOpen_CorruptMiddleOfActiveFile(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(defaultDataFileSize),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key1"), []byte("value1")))
+	require.NoError(t, db.Put([]byte("key2"), []byte("value2")))
+	middle := db.activeFile.Size() - 1
+	require.NoError(t, db.Put([]byte("key3"), []byte("value3")))
+	require.NoError(t, db.Close())
+
+	// 篡改中间一条LogRecord的value，后面的LogRecord仍然完整
+	name := disk.DataFileName(opts.Dir, 1)
+	content, err := os.ReadFile(name)
+	require.NoError(t, err)
+	content[middle] ^= 0xff
+	require.NoError(t, os.WriteFile(name, content, 0600))
+
+	// 不是写到一半的末尾，不能截断
+	_, err = Open(opts)
+	require.ErrorIs(t, err, disk.ErrCrcCheckFailed)
+	after, err := os.ReadFile(name)
+	require.NoError(t, err)
+	require.Equal(t, content, after)
+
+	_, err = Repair(opts)
+	require.NoError(t, err)
+	db, err = Open(opts)
+	require.NoError(t, err)
+	for key, want := range map[string][]byte{"key1": []byte("value1"), "key2": nil, "key3": []byte("value3")} {
+		val, err := db.Get([]byte(key))
+		require.NoError(t, err)
+		require.Equal(t, want, val, key)
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestOpen_CorruptOlderFile(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(64),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte("value")))
+	}
+	require.NoError(t, db.Close())
+
+	// older file是不可变的，损坏时不能截断，直接报错
+	name := disk.DataFileName(opts.Dir, 1)
+	content, err := os.ReadFile(name)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(name, content[:len(content)-1], 0600))
+	_, err = Open(opts)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestDB_SyncPolicy(t *testing.T) {
+	for _, opt := range []OptionsFunc{
+		AlwaysSyncOption(true),
+		SyncPolicyOption(disk.SyncAlways),
+		SyncPolicyOption(disk.SyncNever),
+		func(o *Options) {
+			o.SyncPolicy = disk.SyncEveryBytes
+			o.SyncBytes = 128
+		},
+		func(o *Options) {
+			o.SyncPolicy = disk.SyncEveryInterval
+			o.SyncInterval = 10 * time.Millisecond
+		},
+	} {
+		opts := NewOptions([]OptionsFunc{
+			DirOption(t.TempDir()),
+			MaxSizeOption(256),
+			opt,
+		})
+		db, err := Open(opts)
+		require.NoError(t, err)
+		// 空库也可以Sync
+		require.NoError(t, db.Sync())
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 20; i++ {
+					require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%d-%d", g, i)), []byte("value")))
+				}
+			}(g)
+		}
+		wg.Wait()
+		require.NoError(t, db.Sync())
+		require.NoError(t, db.Close())
+
+		db, err = Open(opts)
+		require.NoError(t, err)
+		for g := 0; g < 8; g++ {
+			for i := 0; i < 20; i++ {
+				val, err := db.Get([]byte(fmt.Sprintf("key-%d-%d", g, i)))
+				require.NoError(t, err)
+				require.Equal(t, []byte("value"), val)
+			}
+		}
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestDB_CloseSyncs(t *testing.T) {
+	for _, policy := range []disk.SyncPolicy{disk.SyncEveryBytes, disk.SyncEveryInterval, disk.SyncNever} {
+		storage := disk.NewFaultStorage(disk.NewMemStorage())
+		opts := NewOptions([]OptionsFunc{
+			DirOption(t.TempDir()),
+			StorageOption(storage),
+			SyncPolicyOption(policy),
+			SyncBytesOption(1 << 20),
+			SyncIntervalOption(time.Hour),
+		})
+		db, err := Open(opts)
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte("value")))
+		}
+		syncs := storage.Syncs()
+		require.NoError(t, db.Close())
+		require.NoError(t, storage.Crash())
+
+		db, err = Open(NewOptions([]OptionsFunc{DirOption(opts.Dir), StorageOption(storage.Storage)}))
+		require.NoError(t, err)
+		val, err := db.Get([]byte("key-9"))
+		require.NoError(t, err)
+		if policy == disk.SyncNever {
+			// 不同步，掉电后连同文件一起丢失
+			require.Equal(t, syncs, storage.Syncs(), policy)
+			require.Nil(t, val)
+		} else {
+			// Close时同步了活跃文件，掉电后数据仍然在
+			require.Equal(t, syncs+1, storage.Syncs(), policy)
+			require.Equal(t, []byte("value"), val)
+		}
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestOpen_DatabaseInUse(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(defaultDataFileSize),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	_, err = Open(opts)
+	require.ErrorIs(t, err, ErrDatabaseInUse)
+
+	// 关闭后释放锁，可以再次打开
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestOpen_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	opts := NewOptions([]OptionsFunc{
+		DirOption(dir),
+		MaxSizeOption(64),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	require.NoError(t, db.Close())
+	// 末尾留下一条不完整的LogRecord
+	ids, err := disk.ListDataFileIDs(disk.FileStorage{}, dir)
+	require.NoError(t, err)
+	last := disk.DataFileName(dir, ids[len(ids)-1])
+	content, err := os.ReadFile(last)
+	require.NoError(t, err)
+	content = append(content, 1, 2, 3)
+	require.NoError(t, os.WriteFile(last, content, 0600))
+
+	roOpts := NewOptions([]OptionsFunc{
+		DirOption(dir),
+		MaxSizeOption(64),
+		ReadOnlyOption(true),
+	})
+	ro1, err := Open(roOpts)
+	require.NoError(t, err)
+	// 多个只读的DB可以同时打开，但不能再以读写方式打开
+	ro2, err := Open(roOpts)
+	require.NoError(t, err)
+	_, err = Open(opts)
+	require.ErrorIs(t, err, ErrDatabaseInUse)
+
+	require.Nil(t, ro1.activeFile)
+	require.Equal(t, int64(3), ro1.DiscardedBytes())
+	for i := 0; i < 10; i++ {
+		val, err := ro1.Get([]byte(fmt.Sprintf("key-%d", i)))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("value-%d", i)), val)
+	}
+	it := ro2.NewIterator(IteratorOptions{})
+	keys, _ := collectIterator(t, it)
+	require.Len(t, keys, 10)
+	it.Close()
+
+	require.ErrorIs(t, ro1.Put([]byte("key"), []byte("value")), ErrReadOnly)
+	_, err = ro1.Del([]byte("key-1"))
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.ErrorIs(t, ro1.Merge(), ErrReadOnly)
+	wb := ro1.NewWriteBatch()
+	require.NoError(t, wb.Put([]byte("key"), []byte("value")))
+	require.ErrorIs(t, wb.Commit(), ErrReadOnly)
+	require.NoError(t, ro1.Close())
+	require.NoError(t, ro2.Close())
+
+	// 只读模式不会截断文件
+	got, err := os.ReadFile(last)
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+	ids2, err := disk.ListDataFileIDs(disk.FileStorage{}, dir)
+	require.NoError(t, err)
+	require.Equal(t, ids, ids2)
+
+	// 目录不存在时不会创建
+	_, err = Open(NewOptions([]OptionsFunc{
+		DirOption(dir + "/not-exist"),
+		ReadOnlyOption(true),
+	}))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestOpen_ReadOnlyDuringMerge(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte("value")))
+	}
+	// merge之后删除记录不再存在，只读的DB不能在merge替换文件的过程中加载到新旧混合的文件
+	for i := 0; i < 100; i += 2 {
+		_, err = db.Del([]byte(fmt.Sprintf("key-%03d", i)))
+		require.NoError(t, err)
+	}
+
+	roOpts := NewOptions([]OptionsFunc{DirOption(opts.Dir), ReadOnlyOption(true)})
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for round := 0; round < 5 && err == nil; round++ {
+			err = db.Merge()
+		}
+		done <- err
+	}()
+	for merging := true; merging; {
+		select {
+		case err = <-done:
+			require.NoError(t, err)
+			merging = false
+		default:
+			_, err := Open(roOpts)
+			require.ErrorIs(t, err, ErrDatabaseInUse)
+		}
+	}
+	require.NoError(t, db.Close())
+
+	ro, err := Open(roOpts)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		val, err := ro.Get([]byte(fmt.Sprintf("key-%03d", i)))
+		require.NoError(t, err)
+		if i%2 == 0 {
+			require.Nil(t, val)
+		} else {
+			require.Equal(t, []byte("value"), val)
+		}
+	}
+	// 只读的DB打开期间也不能以读写方式打开
+	_, err = Open(opts)
+	require.ErrorIs(t, err, ErrDatabaseInUse)
+	require.NoError(t, ro.Close())
+}
+
+func TestOpen_ReadOnlyPendingMerge(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, writeMergeFinished(dir+"/"+mergeDirName, &mergeFinished{Inputs: []uint64{1}}))
+	_, err := Open(NewOptions([]OptionsFunc{
+		DirOption(dir),
+		ReadOnlyOption(true),
+	}))
+	require.ErrorIs(t, err, ErrReadOnly)
+}
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.ErrorIs(t, db.PutWithTTL([]byte("key"), []byte("value"), 0), ErrInvalidTTL)
+
+	require.NoError(t, db.Put([]byte("short"), []byte("old")))
+	require.NoError(t, db.PutWithTTL([]byte("short"), []byte("value"), 50*time.Millisecond))
+	require.NoError(t, db.PutWithTTL([]byte("long"), []byte("value"), time.Hour))
+	require.NoError(t, db.Put([]byte("plain"), []byte("value")))
+	val, err := db.Get([]byte("short"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), val)
+
+	time.Sleep(100 * time.Millisecond)
+	check := func(db *DB) {
+		// 过期之后不能再读到更早写入的旧值
+		val, err := db.Get([]byte("short"))
+		require.NoError(t, err)
+		require.Nil(t, val)
+		for _, key := range []string{"long", "plain"} {
+			val, err = db.Get([]byte(key))
+			require.NoError(t, err)
+			require.Equal(t, []byte("value"), val)
+		}
+		keys, _ := collectIterator(t, db.NewIterator(IteratorOptions{}))
+		require.Equal(t, []string{"long", "plain"}, keys)
+		keys, _ = collectIterator(t, db.NewIterator(IteratorOptions{Reverse: true}))
+		require.Equal(t, []string{"plain", "long"}, keys)
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	// 重启时跳过过期的LogRecord
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	vMeta, err := db.index.Get([]byte("short"))
+	require.NoError(t, err)
+	require.Nil(t, vMeta)
+
+	// 重新写入之后不再过期
+	require.NoError(t, db.Put([]byte("short"), []byte("new")))
+	val, err = db.Get([]byte("short"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("new"), val)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Expire(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key"), []byte("value")))
+	require.NoError(t, db.Put([]byte("other"), []byte("value")))
+	_, err = db.Expire([]byte("key"), 0)
+	require.ErrorIs(t, err, ErrInvalidTTL)
+
+	existed, err := db.Expire([]byte("missing"), time.Hour)
+	require.NoError(t, err)
+	require.False(t, existed)
+	exists, err := db.Exists([]byte("missing"))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	existed, err = db.Expire([]byte("key"), 50*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, existed)
+	val, err := db.Get([]byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), val)
+	exists, err = db.Exists([]byte("key"))
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	time.Sleep(100 * time.Millisecond)
+	exists, err = db.Exists([]byte("key"))
+	require.NoError(t, err)
+	require.False(t, exists)
+	existed, err = db.Expire([]byte("key"), time.Hour)
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.NoError(t, db.Close())
+
+	// 过期时间在重启后仍然有效
+	db, err = Open(opts)
+	require.NoError(t, err)
+	val, err = db.Get([]byte("key"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+	exists, err = db.Exists([]byte("other"))
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.NoError(t, db.Close())
+	_, err = db.Exists([]byte("other"))
+	require.ErrorIs(t, err, ErrDBClosed)
+}
+
+func TestOpen_MigrateV0Files(t *testing.T) {
+	dir := t.TempDir()
+	// 构造没有文件头的旧格式数据文件
+	for fid, kvs := range map[uint64][]string{1: {"k1", "v1", "k2", "v2"}, 2: {"k1", "v3"}} {
+		var content []byte
+		for i := 0; i < len(kvs); i += 2 {
+			record, err := disk.NewNormalLogRecord([]byte(kvs[i]), []byte(kvs[i+1]))
+			require.NoError(t, err)
+			bs, err := record.Serialize()
+			require.NoError(t, err)
+			content = append(content, bs...)
+		}
+		require.NoError(t, os.WriteFile(disk.DataFileName(dir, fid), content, 0600))
+	}
+	check := func(db *DB) {
+		for k, v := range map[string]string{"k1": "v3", "k2": "v2"} {
+			val, err := db.Get([]byte(k))
+			require.NoError(t, err)
+			require.Equal(t, []byte(v), val)
+		}
+	}
+
+	// 只读模式直接读取旧格式
+	opts := NewOptions([]OptionsFunc{DirOption(dir), ReadOnlyOption(true)})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	check(db)
+	require.Equal(t, disk.FormatV0, db.oldFiles[1].Header().Version)
+	require.NoError(t, db.Close())
+
+	db, err = Open(NewOptions([]OptionsFunc{DirOption(dir)}))
+	require.NoError(t, err)
+	check(db)
+	require.Equal(t, disk.FormatV1, db.oldFiles[1].Header().Version)
+	require.Equal(t, disk.DefaultFormatVersion, db.activeFile.Header().Version)
+	require.NoError(t, db.Put([]byte("k2"), []byte("v4")))
+	require.NoError(t, db.Close())
+
+	db, err = Open(NewOptions([]OptionsFunc{DirOption(dir)}))
+	require.NoError(t, err)
+	val, err := db.Get([]byte("k2"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("v4"), val)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_FormatVersion(t *testing.T) {
+	_, err := Open(NewOptions([]OptionsFunc{DirOption(t.TempDir()), FormatVersionOption(disk.CurrentFormatVersion + 1)}))
+	require.ErrorIs(t, err, disk.ErrUnsupportedVersion)
+	require.ErrorContains(t, err, fmt.Sprintf(": %d", disk.CurrentFormatVersion+1))
+
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	require.NoError(t, db.Close())
+
+	// 已有的文件保持原来的格式，新文件使用新的格式
+	opts.FormatVersion = disk.FormatV2
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Equal(t, disk.FormatV1, db.oldFiles[1].Header().Version)
+	for i := 20; i < 40; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	require.Equal(t, disk.FormatV2, db.activeFile.Header().Version)
+	check := func(db *DB) {
+		for i := 0; i < 40; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%02d", i)))
+			require.NoError(t, err)
+			require.Equal(t, []byte(fmt.Sprintf("value-%02d", i)), val)
+		}
+	}
+	check(db)
+
+	// merge之后所有文件都改写成新的格式
+	require.NoError(t, db.Merge())
+	check(db)
+	for _, dataFile := range db.oldFiles {
+		require.Equal(t, disk.FormatV2, dataFile.Header().Version)
+	}
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Compression(t *testing.T) {
+	_, err := Open(NewOptions([]OptionsFunc{DirOption(t.TempDir()), CompressionOption(disk.CompressionHigh + 1)}))
+	require.ErrorIs(t, err, disk.ErrUnknownCompression)
+
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf(`{"id":%d,"name":"user-%d"},`, i, i)), 20)
+	}
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(4096),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), value(i)))
+	}
+	require.NoError(t, db.Close())
+	plainSize := dataFilesSize(t, opts.Dir)
+
+	// 打开压缩之后旧数据仍然可以读取
+	opts.Compression = disk.CompressionFast
+	db, err = Open(opts)
+	require.NoError(t, err)
+	for i := 20; i < 40; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), value(i)))
+	}
+	check := func(db *DB) {
+		for i := 0; i < 40; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%02d", i)))
+			require.NoError(t, err)
+			require.Equal(t, value(i), val)
+		}
+	}
+	check(db)
+	require.Less(t, dataFilesSize(t, opts.Dir)-plainSize, plainSize/3)
+
+	// merge之后旧数据也被压缩
+	require.NoError(t, db.Merge())
+	check(db)
+	require.NoError(t, db.Close())
+	require.Less(t, dataFilesSize(t, opts.Dir), plainSize/2)
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Encryption(t *testing.T) {
+	_, err := Open(NewOptions([]OptionsFunc{DirOption(t.TempDir()), EncryptionOption(&disk.Encryption{
+		Keys: &disk.StaticKeyProvider{Current: 1, Keys: map[uint32][]byte{1: []byte("short")}},
+	})}))
+	require.Error(t, err)
+
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(512),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("plain-value-%02d", i))))
+	}
+	require.NoError(t, db.Close())
+
+	// 打开加密之后切换到新的活跃文件，新写入的数据都是加密的
+	keys := &disk.StaticKeyProvider{Current: 1, Keys: map[uint32][]byte{
+		1: bytes.Repeat([]byte{1}, 32),
+		2: bytes.Repeat([]byte{2}, 32),
+	}}
+	opts.Encryption = &disk.Encryption{Keys: keys}
+	db, err = Open(opts)
+	require.NoError(t, err)
+	lastPlain := db.maxFileID.Load() - 1
+	require.Zero(t, db.oldFiles[lastPlain].Header().Flags&disk.FlagEncrypted)
+	require.Equal(t, uint32(1), db.activeFile.Header().KeyID)
+	for i := 20; i < 40; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("plain-value-%02d", i))))
+	}
+	check := func(db *DB) {
+		for i := 0; i < 40; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%02d", i)))
+			require.NoError(t, err)
+			require.Equal(t, []byte(fmt.Sprintf("plain-value-%02d", i)), val)
+		}
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	// 轮换密钥之后merge，所有旧文件都用新的密钥重新加密
+	keys.Current = 2
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Merge())
+	check(db)
+	for _, dataFile := range db.oldFiles {
+		require.Equal(t, uint32(2), dataFile.Header().KeyID)
+	}
+	require.Equal(t, uint32(2), db.activeFile.Header().KeyID)
+	require.NoError(t, db.Close())
+	ids, err := disk.ListDataFileIDs(disk.FileStorage{}, opts.Dir)
+	require.NoError(t, err)
+	for _, id := range ids {
+		content, err := os.ReadFile(disk.DataFileName(opts.Dir, id))
+		require.NoError(t, err)
+		require.NotContains(t, string(content), "plain-value")
+	}
+
+	// 旧的密钥已经不再需要
+	delete(keys.Keys, 1)
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+
+	// 没有配置加密时无法打开
+	opts.Encryption = nil
+	_, err = Open(opts)
+	require.ErrorIs(t, err, disk.ErrEncryptionRequired)
+}
+
+func TestDB_EncryptKeys(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(512),
+		EncryptionOption(&disk.Encryption{
+			Keys:        &disk.StaticKeyProvider{Current: 7, Keys: map[uint32][]byte{7: bytes.Repeat([]byte{7}, 16)}},
+			EncryptKeys: true,
+		}),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 40; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("secret-%02d", i%20)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Close())
+
+	entries, err := os.ReadDir(opts.Dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		// 加密了key的文件不生成hint文件
+		require.NotEqual(t, ".hint", filepath.Ext(entry.Name()))
+		if filepath.Ext(entry.Name()) == ".db" {
+			content, err := os.ReadFile(filepath.Join(opts.Dir, entry.Name()))
+			require.NoError(t, err)
+			require.NotContains(t, string(content), "secret-")
+		}
+	}
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	for i := 20; i < 40; i++ {
+		val, err := db.Get([]byte(fmt.Sprintf("secret-%02d", i%20)))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("value-%02d", i)), val)
+	}
+	keys, _ := collectIterator(t, db.NewIterator(IteratorOptions{Prefix: []byte("secret-")}))
+	require.Len(t, keys, 20)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Mmap(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+		MmapOption(true),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 60; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i%30)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	check := func(db *DB) {
+		for i := 30; i < 60; i++ {
+			key, want := []byte(fmt.Sprintf("key-%02d", i%30)), []byte(fmt.Sprintf("value-%02d", i))
+			val, err := db.Get(key)
+			require.NoError(t, err)
+			require.Equal(t, want, val)
+			require.NoError(t, db.View(key, func(value []byte) error {
+				require.Equal(t, want, value)
+				return nil
+			}))
+		}
+	}
+	check(db)
+	// Get返回的value是拷贝出来的，merge删除旧文件之后仍然可以访问
+	held, err := db.Get([]byte("key-00"))
+	require.NoError(t, err)
+	require.NoError(t, db.Merge())
+	require.Equal(t, []byte("value-30"), held)
+	check(db)
+
+	errStop := errors.New("stop")
+	require.ErrorIs(t, db.View([]byte("key-00"), func([]byte) error { return errStop }), errStop)
+	require.NoError(t, db.View([]byte("missing"), func(value []byte) error {
+		require.Nil(t, value)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+	require.ErrorIs(t, db.View([]byte("key-00"), func([]byte) error { return nil }), ErrDBClosed)
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+
+	opts.ReadOnly = true
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_MemStorage(t *testing.T) {
+	storage := disk.NewMemStorage()
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+		StorageOption(storage),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 60; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i%30)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	check := func(db *DB) {
+		for i := 30; i < 60; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%02d", i%30)))
+			require.NoError(t, err)
+			require.Equal(t, []byte(fmt.Sprintf("value-%02d", i)), val)
+		}
+	}
+	check(db)
+	require.NoError(t, db.Merge())
+	check(db)
+	require.NoError(t, db.Close())
+
+	// 数据文件不在Dir中
+	ids, err := disk.ListDataFileIDs(disk.FileStorage{}, opts.Dir)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	ids, err = disk.ListDataFileIDs(storage, opts.Dir)
+	require.NoError(t, err)
+	require.NotEmpty(t, ids)
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_FaultStorage(t *testing.T) {
+	storage := disk.NewFaultStorage(disk.FileStorage{})
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+		SyncPolicyOption(disk.SyncAlways),
+		StorageOption(storage),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte("synced")))
+	}
+	// 写入失败时返回错误，之前写入的数据不受影响
+	storage.FailWritesAfter(10)
+	require.ErrorIs(t, db.Put([]byte("torn"), []byte("value")), disk.ErrInjectedFault)
+	storage.FailWritesAfter(-1)
+	storage.FailSync(true)
+	require.ErrorIs(t, db.Put([]byte("unsynced"), []byte("value")), disk.ErrInjectedFault)
+	require.NoError(t, storage.Crash())
+	_ = db.Close()
+
+	// 掉电之后同步过的数据都还在，没有同步的和写了一半的都被丢弃
+	opts.Storage = nil
+	db, err = Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		val, err := db.Get([]byte(fmt.Sprintf("key-%02d", i)))
+		require.NoError(t, err)
+		require.Equal(t, []byte("synced"), val)
+	}
+	for _, key := range []string{"torn", "unsynced"} {
+		val, err := db.Get([]byte(key))
+		require.NoError(t, err)
+		require.Nil(t, val)
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestDB_WriteFaultRollback(t *testing.T) {
+	storage := disk.NewFaultStorage(disk.FileStorage{})
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(defaultDataFileSize),
+		SyncPolicyOption(disk.SyncAlways),
+		StorageOption(storage),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("before"), []byte("value")))
+	storage.FailWritesAfter(10)
+	require.ErrorIs(t, db.Put([]byte("torn"), []byte("value")), disk.ErrInjectedFault)
+	// 写了一半的数据被截断，之后的写入不会跟在它后面
+	storage.FailWritesAfter(-1)
+	require.NoError(t, db.Put([]byte("after"), []byte("value")))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Zero(t, db.DiscardedBytes())
+	for key, want := range map[string][]byte{"before": []byte("value"), "torn": nil, "after": []byte("value")} {
+		val, err := db.Get([]byte(key))
+		require.NoError(t, err)
+		require.Equal(t, want, val, key)
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestDB_CrashAfterRotation(t *testing.T) {
+	storage := disk.NewFaultStorage(disk.FileStorage{})
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(128),
+		SyncPolicyOption(disk.SyncAlways),
+		StorageOption(storage),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	require.Greater(t, len(db.oldFiles), 5)
+	require.NoError(t, storage.Crash())
+	_ = db.Close()
+
+	// 切换活跃文件时新建的文件都同步了目录，掉电之后仍然存在
+	opts.Storage = nil
+	db, err = Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		val, err := db.Get([]byte(fmt.Sprintf("key-%02d", i)))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("value-%02d", i)), val)
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Del(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(defaultDataFileSize),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key"), []byte("value")))
+	size := db.activeFile.Size()
+
+	// 不存在的key不写入删除记录
+	existed, err := db.Del([]byte("missing"))
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.Equal(t, size, db.activeFile.Size())
+
+	existed, err = db.Del([]byte("key"))
+	require.NoError(t, err)
+	require.True(t, existed)
+	require.Greater(t, db.activeFile.Size(), size)
+	vMeta, err := db.index.Get([]byte("key"))
+	require.NoError(t, err)
+	require.Nil(t, vMeta)
+	val, err := db.Get([]byte("key"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+
+	// 重复删除时key已经不存在了
+	size = db.activeFile.Size()
+	existed, err = db.Del([]byte("key"))
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.Equal(t, size, db.activeFile.Size())
+
+	// 已经过期的key当作不存在
+	require.NoError(t, db.PutWithTTL([]byte("ttl"), []byte("value"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	existed, err = db.Del([]byte("ttl"))
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	for _, key := range []string{"key", "ttl"} {
+		val, err = db.Get([]byte(key))
+		require.NoError(t, err)
+		require.Nil(t, val)
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestDB_DeleteRange(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(512),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for _, prefix := range []string{"a", "b", "c"} {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, db.Put([]byte(fmt.Sprintf("%s-%d", prefix, i)), []byte("value")))
+		}
+	}
+
+	n, err := db.DeletePrefix([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, 10, n)
+	n, err = db.DeleteRange([]byte("a-5"), []byte("a-8"))
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	n, err = db.DeleteRange([]byte("c-8"), nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	n, err = db.DeletePrefix([]byte("missing"))
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	want := []string{"a-0", "a-1", "a-2", "a-3", "a-4", "a-8", "a-9", "c-0", "c-1", "c-2", "c-3", "c-4", "c-5", "c-6", "c-7"}
+	check := func(db *DB) {
+		keys, _ := collectIterator(t, db.NewIterator(IteratorOptions{}))
+		require.Equal(t, want, keys)
+	}
+	check(db)
+	require.NoError(t, db.Close())
+	_, err = db.DeletePrefix([]byte("a"))
+	require.ErrorIs(t, err, ErrDBClosed)
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_DeletePrefixWithConcurrentDeletes(t *testing.T) {
+	db, err := Open(NewOptions([]OptionsFunc{DirOption(t.TempDir())}))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	const keys = 500
+	for round := 0; round < 5; round++ {
+		for i := 0; i < keys; i++ {
+			require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte("value")))
+		}
+		var (
+			wg      sync.WaitGroup
+			deleted atomic.Int64
+		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := keys - 1; i >= 0; i-- {
+				existed, err := db.Del([]byte(fmt.Sprintf("key-%03d", i)))
+				require.NoError(t, err)
+				if existed {
+					deleted.Add(1)
+				}
+			}
+		}()
+		// 在Del进行的同时删除
+		for deleted.Load() < 10 {
+			runtime.Gosched()
+		}
+		n, err := db.DeletePrefix([]byte("key-"))
+		require.NoError(t, err)
+		wg.Wait()
+		// 每个key只会被删除一次
+		require.Equal(t, int64(keys), int64(n)+deleted.Load(), "round %d", round)
+	}
+}
+
+func TestDB_Stats(t *testing.T) {
+	db, err := Open(NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(128),
+	}))
+	require.NoError(t, err)
+	stats, err := db.Stats()
+	require.NoError(t, err)
+	require.Equal(t, Stats{}, stats)
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i%10)), []byte("value")))
+	}
+	require.NoError(t, db.PutWithTTL([]byte("expired"), []byte("value"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	stats, err = db.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 10, stats.KeyNum)
+	require.Equal(t, len(db.oldFiles)+1, stats.DataFileNum)
+	require.Equal(t, dataFilesSize(t, db.Opts.Dir), stats.DiskSize)
+	require.NoError(t, db.Close())
+	_, err = db.Stats()
+	require.ErrorIs(t, err, ErrDBClosed)
+}
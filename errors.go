@@ -0,0 +1,22 @@
+package bitcast_go
+
+import "errors"
+
+var (
+	// ErrDataFileNotFound 索引中记录的数据文件不存在
+	ErrDataFileNotFound = errors.New("data file not found")
+	// ErrMergeInProgress 已经有一个merge在进行
+	ErrMergeInProgress = errors.New("merge is in progress")
+	// ErrDBClosed DB已经关闭
+	ErrDBClosed = errors.New("db is closed")
+	// ErrDatabaseInUse 数据目录已经被其他进程或者其他DB实例打开
+	ErrDatabaseInUse = errors.New("database is in use by another process")
+	// ErrReadOnly 只读模式下不允许写入
+	ErrReadOnly = errors.New("db is opened in read-only mode")
+	// ErrInvalidTTL ttl必须大于0
+	ErrInvalidTTL = errors.New("ttl must be positive")
+	// ErrDirNotEmpty 备份和恢复的目标目录必须不存在或者是空目录
+	ErrDirNotEmpty = errors.New("directory is not empty")
+	// ErrInvalidBackup 备份没有清单或者和清单不一致
+	ErrInvalidBackup = errors.New("invalid backup")
+)
@@ -0,0 +1,110 @@
+package bitcast_go
+
+import (
+	"bytes"
+	"time"
+
+	"bitcask-go/pkg/index"
+)
+
+// IteratorOptions DB迭代器的配置，Prefix和[Start, End)同时设置时取交集
+type IteratorOptions struct {
+	// Prefix 只遍历带有该前缀的key
+	Prefix []byte
+	// Start 不为nil时只遍历>=Start的key
+	Start []byte
+	// End 不为nil时只遍历<End的key
+	End []byte
+	// Reverse 是否按key从大到小遍历
+	Reverse bool
+}
+
+// Iterator 按key的顺序遍历DB
+// key来自创建迭代器时索引的快照，Value读取的是调用时key的最新值，快照中已经过期的key会被跳过
+type Iterator struct {
+	db *DB
+	it index.Iterator
+}
+
+// NewIterator 创建迭代器，创建后位于遍历顺序的第一个key
+func (d *DB) NewIterator(opts IteratorOptions) *Iterator {
+	start, end := opts.Start, opts.End
+	if opts.Prefix != nil {
+		if start == nil || bytes.Compare(opts.Prefix, start) > 0 {
+			start = opts.Prefix
+		}
+		if prefixEnd := prefixUpperBound(opts.Prefix); prefixEnd != nil && (end == nil || bytes.Compare(prefixEnd, end) < 0) {
+			end = prefixEnd
+		}
+	}
+	it := &Iterator{
+		db: d,
+		it: d.index.Iterator(index.IteratorOptions{Start: start, End: end, Reverse: opts.Reverse}),
+	}
+	it.skipExpired(it.it.Next)
+	return it
+}
+
+// skipExpired 沿着move的方向跳过已经过期的key
+func (it *Iterator) skipExpired(move func()) {
+	now := time.Now().UnixNano()
+	for it.it.Valid() && it.it.Value().IsExpired(now) {
+		move()
+	}
+}
+
+// prefixUpperBound 返回大于所有以prefix开头的key的最小key，prefix全是0xff时没有上界，返回nil
+func prefixUpperBound(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Rewind 回到遍历顺序的第一个key
+func (it *Iterator) Rewind() {
+	it.it.Rewind()
+	it.skipExpired(it.it.Next)
+}
+
+// Seek 正序时定位到第一个>=key的位置，逆序时定位到第一个<=key的位置
+func (it *Iterator) Seek(key []byte) {
+	it.it.Seek(key)
+	it.skipExpired(it.it.Next)
+}
+
+// Next 按遍历顺序移动到下一个key
+func (it *Iterator) Next() {
+	it.it.Next()
+	it.skipExpired(it.it.Next)
+}
+
+// Prev 按遍历顺序移动到上一个key
+func (it *Iterator) Prev() {
+	it.it.Prev()
+	it.skipExpired(it.it.Prev)
+}
+
+// Valid 当前位置是否有效
+func (it *Iterator) Valid() bool {
+	return it.it.Valid()
+}
+
+// Key 返回当前位置的key
+func (it *Iterator) Key() []byte {
+	return it.it.Key()
+}
+
+// Value 返回当前key的最新值，key在创建迭代器之后被删除时返回nil
+func (it *Iterator) Value() ([]byte, error) {
+	return it.db.Get(it.it.Key())
+}
+
+// Close 释放迭代器
+func (it *Iterator) Close() {
+	it.it.Close()
+}
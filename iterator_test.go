@@ -0,0 +1,80 @@
+package bitcast_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func collectIterator(t *testing.T, it *Iterator) (keys, values []string) {
+	for ; it.Valid(); it.Next() {
+		val, err := it.Value()
+		require.NoError(t, err)
+		keys = append(keys, string(it.Key()))
+		values = append(values, string(val))
+	}
+	return
+}
+
+func TestDB_Iterator(t *testing.T) {
+	db, err := Open(NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(64),
+	}))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	for _, k := range []string{"user:2", "user:1", "order:1", "user:3", "zz", "user", "user;"} {
+		require.NoError(t, db.Put([]byte(k), []byte("v-"+k)))
+	}
+
+	it := db.NewIterator(IteratorOptions{})
+	keys, values := collectIterator(t, it)
+	require.Equal(t, []string{"order:1", "user", "user:1", "user:2", "user:3", "user;", "zz"}, keys)
+	require.Equal(t, "v-user:1", values[2])
+	it.Close()
+
+	// 前缀
+	it = db.NewIterator(IteratorOptions{Prefix: []byte("user:")})
+	keys, values = collectIterator(t, it)
+	require.Equal(t, []string{"user:1", "user:2", "user:3"}, keys)
+	require.Equal(t, []string{"v-user:1", "v-user:2", "v-user:3"}, values)
+	it.Close()
+
+	it = db.NewIterator(IteratorOptions{Prefix: []byte("user:"), Reverse: true})
+	keys, _ = collectIterator(t, it)
+	require.Equal(t, []string{"user:3", "user:2", "user:1"}, keys)
+	it.Seek([]byte("user:2"))
+	require.Equal(t, []byte("user:2"), it.Key())
+	it.Prev()
+	require.Equal(t, []byte("user:3"), it.Key())
+	it.Close()
+
+	// 范围和前缀取交集
+	it = db.NewIterator(IteratorOptions{Prefix: []byte("user"), Start: []byte("user:2"), End: []byte("zz")})
+	keys, _ = collectIterator(t, it)
+	require.Equal(t, []string{"user:2", "user:3", "user;"}, keys)
+	it.Close()
+
+	it = db.NewIterator(IteratorOptions{Start: []byte("user:1"), End: []byte("user:3")})
+	keys, _ = collectIterator(t, it)
+	require.Equal(t, []string{"user:1", "user:2"}, keys)
+	it.Close()
+
+	// 迭代期间更新，Value返回最新值
+	it = db.NewIterator(IteratorOptions{Prefix: []byte("order")})
+	require.NoError(t, db.Put([]byte("order:1"), []byte("updated")))
+	require.True(t, it.Valid())
+	val, err := it.Value()
+	require.NoError(t, err)
+	require.Equal(t, []byte("updated"), val)
+	it.Close()
+}
+
+func TestPrefixUpperBound(t *testing.T) {
+	require.Equal(t, []byte("b"), prefixUpperBound([]byte("a")))
+	require.Equal(t, []byte("ab"), prefixUpperBound([]byte("aa\xff")))
+	require.Nil(t, prefixUpperBound([]byte("\xff\xff")))
+	require.Nil(t, prefixUpperBound([]byte("")))
+}
@@ -0,0 +1,42 @@
+package bitcast_go
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockFileName 数据目录下用来加文件锁的文件
+const lockFileName = "LOCK"
+
+// dirLock 数据目录上的文件锁，防止多个进程(或同一个进程多次Open)同时写同一个目录
+type dirLock struct {
+	file *os.File
+}
+
+// lockDir 获取dir上的文件锁，已经被其他人持有时立即返回ErrDatabaseInUse
+// shared为true时获取共享锁，多个只读的DB可以同时持有，但和排他锁互斥
+func lockDir(dir string, shared bool) (*dirLock, error) {
+	name := filepath.Join(dir, lockFileName)
+	flag := os.O_CREATE | os.O_RDWR
+	if shared {
+		// 只读模式下尽量不修改目录，锁文件已经存在时只读打开
+		flag = os.O_RDONLY
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			flag |= os.O_CREATE
+		}
+	}
+	f, err := os.OpenFile(name, flag, os.FileMode(0644))
+	if err != nil {
+		return nil, err
+	}
+	if err = flock(f, shared); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &dirLock{file: f}, nil
+}
+
+// release 释放文件锁，关闭文件即可释放
+func (l *dirLock) release() error {
+	return l.file.Close()
+}
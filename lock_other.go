@@ -0,0 +1,10 @@
+//go:build !unix
+
+package bitcast_go
+
+import "os"
+
+// flock 非unix平台暂时不支持文件锁，不做任何检查
+func flock(f *os.File, shared bool) error {
+	return nil
+}
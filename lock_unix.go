@@ -0,0 +1,22 @@
+//go:build unix
+
+package bitcast_go
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// flock 对f加非阻塞的排他锁，shared为true时加共享锁
+func flock(f *os.File, shared bool) error {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrDatabaseInUse
+	}
+	return err
+}
@@ -0,0 +1,423 @@
+package bitcast_go
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"bitcask-go/pkg/disk"
+	"bitcask-go/pkg/index"
+)
+
+const (
+	// mergeDirName merge过程中生成的文件先写到这个子目录，全部完成后再替换
+	mergeDirName = "merge"
+	// mergeFinishedFileName merge文件全部落盘后写入的标记文件，有它才说明merge目录下的文件是完整的
+	mergeFinishedFileName = "merge-finished"
+)
+
+// mergeFinished 标记文件的内容
+type mergeFinished struct {
+	// Inputs 被merge的older file的ID
+	Inputs []uint64 `json:"inputs"`
+	// Outputs merge生成的文件的ID，是Inputs的子集
+	Outputs []uint64 `json:"outputs"`
+}
+
+// mergeEntry merge时保留下来的一条LogRecord
+type mergeEntry struct {
+	key []byte
+	// old merge开始时索引中记录的位置
+	old *index.ValueMetadata
+	// new 在merge文件中的位置，nil表示索引指向的LogRecord已经过期，替换时从索引中删除
+	new *index.ValueMetadata
+}
+
+// Merge 压缩所有的older file，只保留索引中仍然引用的LogRecord
+// 重写的过程不持有锁，读写可以正常进行，只有最后替换文件和更新索引时会短暂阻塞读写
+func (d *DB) Merge() error {
+	if d.Opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if !d.merging.CompareAndSwap(false, true) {
+		return ErrMergeInProgress
+	}
+	defer d.merging.Store(false)
+
+	// older file是不可变的，并且只有merge会删除它们，所以这里拿到快照后就可以不持有锁了
+	d.mu.RLock()
+	if d.closed {
+		d.mu.RUnlock()
+		return ErrDBClosed
+	}
+	// 在读锁内登记，Close设置closed之后不会再有新的merge开始
+	d.mergeWG.Add(1)
+	defer d.mergeWG.Done()
+	fileIDs := make([]uint64, 0, len(d.oldFiles))
+	inputs := make(map[uint64]disk.DataFile, len(d.oldFiles))
+	for fid, dataFile := range d.oldFiles {
+		fileIDs = append(fileIDs, fid)
+		inputs[fid] = dataFile
+	}
+	d.mu.RUnlock()
+	if len(fileIDs) == 0 {
+		return nil
+	}
+	sort.Slice(fileIDs, func(i, j int) bool { return fileIDs[i] < fileIDs[j] })
+
+	mergeDir := filepath.Join(d.Opts.Dir, mergeDirName)
+	if err := removeMergeDir(d.Opts.storage(), mergeDir); err != nil {
+		return err
+	}
+	w := &mergeWriter{dir: mergeDir, maxSize: d.Opts.MaxSize, fileIDs: fileIDs, storage: d.Opts.storage(), opts: d.Opts.encodingOptions()}
+	entries, err := d.rewrite(w, fileIDs, inputs)
+	if closeErr := w.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = removeMergeDir(d.Opts.storage(), mergeDir)
+		return err
+	}
+	if err = writeMergeFinished(mergeDir, &mergeFinished{Inputs: fileIDs, Outputs: w.outputIDs()}); err != nil {
+		_ = removeMergeDir(d.Opts.storage(), mergeDir)
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// merge期间DB被关闭了，merge目录留给下次启动时完成替换
+	if d.closed {
+		return ErrDBClosed
+	}
+	for _, e := range entries {
+		cur, err := d.index.Get(e.key)
+		if err != nil {
+			return err
+		}
+		// merge期间key被更新或删除了，以新的为准
+		if cur == nil || !sameLocation(cur, e.old) {
+			continue
+		}
+		if e.new == nil {
+			err = d.index.Del(e.key)
+		} else {
+			err = d.index.Set(e.key, e.new)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, fid := range fileIDs {
+		if err = inputs[fid].Close(); err != nil {
+			return err
+		}
+		if err = inputs[fid].Delete(); err != nil {
+			return err
+		}
+		if err = os.Remove(disk.HintFileName(d.Opts.Dir, fid)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(d.oldFiles, fid)
+	}
+	if err = applyMergeFiles(d.Opts.storage(), d.Opts.Dir, &mergeFinished{Inputs: fileIDs, Outputs: w.outputIDs()}); err != nil {
+		return err
+	}
+	for _, fid := range w.outputIDs() {
+		dataFile, err := d.openDataFile(fid, false)
+		if err != nil {
+			return err
+		}
+		d.oldFiles[fid] = dataFile
+	}
+	return nil
+}
+
+// rewrite 把inputs中索引仍然引用的LogRecord写入merge文件
+func (d *DB) rewrite(w *mergeWriter, fileIDs []uint64, inputs map[uint64]disk.DataFile) ([]mergeEntry, error) {
+	var entries []mergeEntry
+	for _, fid := range fileIDs {
+		offset := inputs[fid].Header().DataOffset()
+		for {
+			// DB正在关闭，放弃这次merge，Close在等待merge退出
+			if d.isClosed() {
+				return nil, ErrDBClosed
+			}
+			record, size, err := inputs[fid].ReadLogRecord(offset)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			vMeta := index.NewValueMetadata(fid, size, offset, int64(record.TmStamp()))
+			offset += size
+
+			cur, err := d.index.Get(record.Key())
+			if err != nil {
+				return nil, err
+			}
+			if cur == nil || !sameLocation(cur, vMeta) {
+				continue
+			}
+			entry := mergeEntry{key: record.Key(), old: cur}
+			// 所有更早的文件都参与了merge，删除记录和已经过期的LogRecord不需要再保留
+			if record.Op() == disk.NormalRecord && !record.IsExpired(time.Now()) {
+				// 索引引用的批量写入一定已经提交了，merge文件中不会再有提交标记，作为普通的LogRecord写入
+				record.SetSeq(0)
+				if entry.new, err = w.write(record); err != nil {
+					return nil, err
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// isClosed merge重写时检查DB是否正在关闭
+func (d *DB) isClosed() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.closed
+}
+
+// sameLocation 判断两个ValueMetadata是否指向同一条LogRecord
+func sameLocation(a, b *index.ValueMetadata) bool {
+	return a.FileID == b.FileID && a.ValuePos == b.ValuePos
+}
+
+// mergeWriter 将保留的LogRecord写入merge目录，同时为每个merge文件生成hint文件，加密了key的merge文件不生成hint文件
+// merge文件依次复用被merge的older file的ID，这样merge后的文件ID仍然小于活跃文件，重放顺序不变
+type mergeWriter struct {
+	dir     string
+	maxSize int64
+	fileIDs []uint64
+	storage disk.Storage
+	// opts merge文件的编码配置
+	opts  []disk.ManagerOption
+	files []disk.DataFile
+	hints []*disk.HintWriter
+}
+
+func (w *mergeWriter) write(record *disk.LogRecord) (*index.ValueMetadata, error) {
+	if len(w.files) == 0 {
+		if err := w.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	// 没有可以复用的文件ID时，最后一个merge文件忽略大小限制
+	force := len(w.files) == len(w.fileIDs)
+	vMeta, err := w.files[len(w.files)-1].WriteLogRecord(record, force)
+	if errors.Is(err, disk.ErrFileTooSmall) {
+		if err = w.rotate(); err != nil {
+			return nil, err
+		}
+		vMeta, err = w.files[len(w.files)-1].WriteLogRecord(record, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if hint := w.hints[len(w.hints)-1]; hint != nil {
+		if err = hint.Write(record.Key(), vMeta); err != nil {
+			return nil, err
+		}
+	}
+	return vMeta, nil
+}
+
+func (w *mergeWriter) rotate() error {
+	fid := w.fileIDs[len(w.files)]
+	dataFile, err := disk.NewManager(w.dir, fid, true, w.maxSize, w.opts...)
+	if err != nil {
+		return err
+	}
+	w.files = append(w.files, dataFile)
+	// hint文件中的key是明文的
+	var hint *disk.HintWriter
+	if dataFile.Header().Flags&disk.FlagEncryptedKeys == 0 {
+		if hint, err = disk.NewHintWriter(w.dir, fid); err != nil {
+			return err
+		}
+	}
+	w.hints = append(w.hints, hint)
+	return nil
+}
+
+func (w *mergeWriter) outputIDs() []uint64 {
+	return w.fileIDs[:len(w.files)]
+}
+
+// close 将所有merge文件和hint文件落盘并关闭，返回遇到的第一个错误
+func (w *mergeWriter) close() error {
+	var err error
+	for _, dataFile := range w.files {
+		if syncErr := dataFile.Sync(); err == nil {
+			err = syncErr
+		}
+		if closeErr := dataFile.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	for _, hint := range w.hints {
+		if hint == nil {
+			continue
+		}
+		if syncErr := hint.Sync(); err == nil {
+			err = syncErr
+		}
+		if closeErr := hint.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil && len(w.files) > 0 {
+		err = syncDirs(w.storage, w.dir)
+	}
+	return err
+}
+
+func writeMergeFinished(mergeDir string, mf *mergeFinished) error {
+	if err := os.MkdirAll(mergeDir, os.FileMode(0755)); err != nil {
+		return err
+	}
+	bs, err := json.Marshal(mf)
+	if err != nil {
+		return err
+	}
+	if err = writeFileSync(filepath.Join(mergeDir, mergeFinishedFileName), bs); err != nil {
+		return err
+	}
+	// 标记文件和merge目录本身都要落盘，之后才能开始替换
+	local := disk.FileStorage{}
+	if err = local.SyncDir(mergeDir); err != nil {
+		return err
+	}
+	return local.SyncDir(filepath.Dir(mergeDir))
+}
+
+// writeFileSync 写入path并落盘，不会同步所在目录的目录项
+func writeFileSync(path string, bs []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(bs); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// applyMergeFiles 用merge目录下的数据文件和hint文件替换dir下被merge的文件，然后删除merge目录
+// 这个过程是幂等的，中途崩溃后重新执行可以得到相同的结果
+func applyMergeFiles(s disk.Storage, dir string, mf *mergeFinished) error {
+	mergeDir := filepath.Join(dir, mergeDirName)
+	outputs := make(map[uint64]struct{}, len(mf.Outputs))
+	for _, fid := range mf.Outputs {
+		outputs[fid] = struct{}{}
+	}
+	for _, fid := range mf.Inputs {
+		if _, ok := outputs[fid]; ok {
+			continue
+		}
+		if err := s.Remove(disk.DataFileName(dir, fid)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(disk.HintFileName(dir, fid)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	pending, err := disk.ListDataFileIDs(s, mergeDir)
+	if err != nil {
+		return err
+	}
+	for _, fid := range mf.Outputs {
+		// 还没有替换的merge文件可能没有hint文件，要先删掉被替换文件的hint文件
+		if containsID(pending, fid) {
+			if err = os.Remove(disk.HintFileName(dir, fid)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		err = os.Rename(disk.HintFileName(mergeDir, fid), disk.HintFileName(dir, fid))
+		// 上一次已经替换过了
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		err = s.Rename(disk.DataFileName(mergeDir, fid), disk.DataFileName(dir, fid))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// 替换的结果落盘之后才能删除merge目录，否则掉电后可能既没有merge文件也没有完成标记
+	if err = syncDirs(s, dir); err != nil {
+		return err
+	}
+	return removeMergeDir(s, mergeDir)
+}
+
+// syncDirs 同步dir下数据文件和hint文件的目录项，数据文件不在本地文件系统时分别同步
+func syncDirs(s disk.Storage, dir string) error {
+	if err := s.SyncDir(dir); err != nil {
+		return err
+	}
+	if _, ok := s.(disk.FileStorage); ok {
+		return nil
+	}
+	return disk.FileStorage{}.SyncDir(dir)
+}
+
+func containsID(ids []uint64, id uint64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// removeMergeDir 删除merge目录，包括存储后端中merge目录下的数据文件
+func removeMergeDir(s disk.Storage, mergeDir string) error {
+	if err := disk.RemoveDataFiles(s, mergeDir); err != nil {
+		return err
+	}
+	return os.RemoveAll(mergeDir)
+}
+
+// recoverMerge 启动时处理上一次merge留下的merge目录
+// 有完成标记的继续完成替换，没有的说明merge中途失败了，直接丢弃
+func recoverMerge(s disk.Storage, dir string) error {
+	mergeDir := filepath.Join(dir, mergeDirName)
+	bs, err := os.ReadFile(filepath.Join(mergeDir, mergeFinishedFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return removeMergeDir(s, mergeDir)
+		}
+		return err
+	}
+	mf := new(mergeFinished)
+	if err = json.Unmarshal(bs, mf); err != nil {
+		// 标记文件没有写完整，替换还没有开始
+		return removeMergeDir(s, mergeDir)
+	}
+	return applyMergeFiles(s, dir, mf)
+}
+
+// checkPendingMerge 只读模式下不能完成上一次merge的替换，目录中的文件可能只替换了一部分，直接报错
+func checkPendingMerge(dir string) error {
+	_, err := os.Stat(filepath.Join(dir, mergeDirName, mergeFinishedFileName))
+	if err == nil {
+		return fmt.Errorf("%w: unfinished merge in %s, open it in read-write mode first", ErrReadOnly, dir)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
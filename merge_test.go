@@ -0,0 +1,322 @@
+package bitcast_go
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitcask-go/pkg/disk"
+)
+
+func dataFilesSize(t *testing.T, dir string) int64 {
+	ids, err := disk.ListDataFileIDs(disk.FileStorage{}, dir)
+	require.NoError(t, err)
+	var total int64
+	for _, id := range ids {
+		info, err := os.Stat(disk.DataFileName(dir, id))
+		require.NoError(t, err)
+		total += info.Size()
+	}
+	return total
+}
+
+func TestDB_Merge(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	// 每个key写多次，只有最后一次是有效的
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 20; i++ {
+			key := []byte(fmt.Sprintf("key-%02d", i))
+			require.NoError(t, db.Put(key, []byte(fmt.Sprintf("value-%02d-%d", i, round))))
+		}
+	}
+	for i := 0; i < 20; i += 2 {
+		_, err := db.Del([]byte(fmt.Sprintf("key-%02d", i)))
+		require.NoError(t, err)
+	}
+	check := func() {
+		for i := 0; i < 20; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%02d", i)))
+			require.NoError(t, err)
+			if i%2 == 0 {
+				require.Nil(t, val)
+			} else {
+				require.Equal(t, []byte(fmt.Sprintf("value-%02d-4", i)), val)
+			}
+		}
+	}
+	check()
+
+	before := dataFilesSize(t, opts.Dir)
+	require.NoError(t, db.Merge())
+	require.Less(t, dataFilesSize(t, opts.Dir), before)
+	check()
+	_, err = os.Stat(filepath.Join(opts.Dir, mergeDirName))
+	require.True(t, os.IsNotExist(err))
+
+	// merge后重启，数据不变
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check()
+
+	// 没有older file时merge什么也不做
+	require.NoError(t, db.Merge())
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestDB_MergeWithConcurrentWrites(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(512),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte("old")))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte("new")))
+			_, err := db.Get([]byte(fmt.Sprintf("key-%03d", (i*7)%200)))
+			require.NoError(t, err)
+		}
+	}()
+	require.NoError(t, db.Merge())
+	wg.Wait()
+
+	check := func() {
+		for i := 0; i < 200; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%03d", i)))
+			require.NoError(t, err)
+			require.Equal(t, []byte("new"), val)
+		}
+	}
+	check()
+	require.NoError(t, db.Merge())
+	check()
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestDB_MergeRaceClose(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(4 << 10),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("value-%04d", i))))
+	}
+	require.NoError(t, db.Close())
+
+	mergeDir := filepath.Join(opts.Dir, mergeDirName)
+	for round := 0; round < 10; round++ {
+		db, err = Open(opts)
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("round-%d", round))))
+		}
+		done := make(chan error, 1)
+		go func() { done <- db.Merge() }()
+		for !db.merging.Load() {
+			time.Sleep(time.Microsecond)
+		}
+		time.Sleep(time.Duration(round) * 100 * time.Microsecond)
+		require.NoError(t, db.Close())
+
+		// Close返回时merge已经退出，merge目录要么被清理，要么已经完整
+		if _, err := os.Stat(mergeDir); err == nil {
+			_, err = os.Stat(filepath.Join(mergeDir, mergeFinishedFileName))
+			require.NoError(t, err, "round %d", round)
+		}
+		if err := <-done; err != nil {
+			require.ErrorIs(t, err, ErrDBClosed)
+		}
+	}
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 2000; i++ {
+		val, err := db.Get([]byte(fmt.Sprintf("key-%04d", i)))
+		require.NoError(t, err)
+		if i < 100 {
+			require.Equal(t, []byte("round-9"), val)
+		} else {
+			require.Equal(t, []byte(fmt.Sprintf("value-%04d", i)), val)
+		}
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestRecoverMerge(t *testing.T) {
+	dir := t.TempDir()
+	mergeDir := filepath.Join(dir, mergeDirName)
+
+	// 没有完成标记的merge目录直接丢弃
+	require.NoError(t, os.MkdirAll(mergeDir, 0755))
+	require.NoError(t, os.WriteFile(disk.DataFileName(mergeDir, 1), []byte("garbage"), 0600))
+	require.NoError(t, recoverMerge(disk.FileStorage{}, dir))
+	_, err := os.Stat(mergeDir)
+	require.True(t, os.IsNotExist(err))
+
+	// 有完成标记的继续完成替换
+	require.NoError(t, os.WriteFile(disk.DataFileName(dir, 1), []byte("input-1"), 0600))
+	require.NoError(t, os.WriteFile(disk.DataFileName(dir, 2), []byte("input-2"), 0600))
+	require.NoError(t, os.WriteFile(disk.DataFileName(dir, 3), []byte("active"), 0600))
+	require.NoError(t, os.MkdirAll(mergeDir, 0755))
+	require.NoError(t, os.WriteFile(disk.DataFileName(mergeDir, 1), []byte("output-1"), 0600))
+	require.NoError(t, writeMergeFinished(mergeDir, &mergeFinished{Inputs: []uint64{1, 2}, Outputs: []uint64{1}}))
+	require.NoError(t, recoverMerge(disk.FileStorage{}, dir))
+
+	ids, err := disk.ListDataFileIDs(disk.FileStorage{}, dir)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 3}, ids)
+	bs, err := os.ReadFile(disk.DataFileName(dir, 1))
+	require.NoError(t, err)
+	require.Equal(t, []byte("output-1"), bs)
+	_, err = os.Stat(mergeDir)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestDB_MergeHintFile(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i%10)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	require.NoError(t, db.Merge())
+	hintIDs := make([]uint64, 0)
+	for fid := range db.oldFiles {
+		_, err := os.Stat(disk.HintFileName(opts.Dir, fid))
+		require.NoError(t, err)
+		hintIDs = append(hintIDs, fid)
+	}
+	require.NotEmpty(t, hintIDs)
+	require.NoError(t, db.Close())
+
+	check := func(db *DB) {
+		for i := 40; i < 50; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%02d", i%10)))
+			require.NoError(t, err)
+			require.Equal(t, []byte(fmt.Sprintf("value-%02d", i)), val)
+		}
+	}
+
+	// 启动时使用hint文件
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	loaded, err := db.loadIndexFromHint(db.oldFiles[hintIDs[0]])
+	require.NoError(t, err)
+	require.True(t, loaded)
+	require.NoError(t, db.Close())
+
+	// hint文件损坏时退回到扫描数据文件
+	for _, fid := range hintIDs {
+		require.NoError(t, os.WriteFile(disk.HintFileName(opts.Dir, fid), []byte("broken"), 0600))
+	}
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_MergeDropsExpired(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key-%02d", i))
+		if i%2 == 0 {
+			require.NoError(t, db.PutWithTTL(key, []byte("expiring-value"), 50*time.Millisecond))
+		} else {
+			require.NoError(t, db.PutWithTTL(key, []byte("living-value"), time.Hour))
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	check := func(db *DB) {
+		for i := 0; i < 20; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%02d", i)))
+			require.NoError(t, err)
+			if i%2 == 0 {
+				require.Nil(t, val)
+			} else {
+				require.Equal(t, []byte("living-value"), val)
+			}
+		}
+	}
+	check(db)
+
+	before := dataFilesSize(t, opts.Dir)
+	require.NoError(t, db.Merge())
+	require.Less(t, dataFilesSize(t, opts.Dir), before)
+	check(db)
+	require.NoError(t, db.Close())
+
+	// merge生成的hint文件保留了过期时间
+	db, err = Open(opts)
+	require.NoError(t, err)
+	check(db)
+	vMeta, err := db.index.Get([]byte("key-01"))
+	require.NoError(t, err)
+	require.NotZero(t, vMeta.ExpireAt)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_MergeCrash(t *testing.T) {
+	storage := disk.NewFaultStorage(disk.FileStorage{})
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+		SyncPolicyOption(disk.SyncAlways),
+		StorageOption(storage),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 90; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i%30)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	require.NoError(t, db.Merge())
+	require.NoError(t, storage.Crash())
+	_ = db.Close()
+
+	// merge替换的文件和删除都落盘了，掉电后不需要再恢复
+	_, err = os.Stat(filepath.Join(opts.Dir, mergeDirName))
+	require.True(t, os.IsNotExist(err))
+	opts.Storage = nil
+	db, err = Open(opts)
+	require.NoError(t, err)
+	for i := 60; i < 90; i++ {
+		val, err := db.Get([]byte(fmt.Sprintf("key-%02d", i%30)))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("value-%02d", i)), val)
+	}
+	require.NoError(t, db.Close())
+}
@@ -1,5 +1,11 @@
 package bitcast_go
 
+import (
+	"time"
+
+	"bitcask-go/pkg/disk"
+)
+
 const (
 	defaultDataFileSize = 4 << 20 // 4Mib
 )
@@ -10,8 +16,65 @@ type Options struct {
 	// 单个数据文件最大尺寸
 	// 如果logRecord太大，就算新开了一个数据文件也无法容纳，那么新的数据文件就无视这个限制
 	MaxSize int64
-	// 写文件是否总是Sync
+	// 写文件是否总是Sync，为true时等价于SyncPolicy为disk.SyncAlways
 	AlwaysSync bool
+	// 写文件后同步到磁盘的策略，默认为disk.SyncNever
+	SyncPolicy disk.SyncPolicy
+	// SyncPolicy为disk.SyncEveryBytes时，累计写入多少字节同步一次
+	SyncBytes int64
+	// SyncPolicy为disk.SyncEveryInterval时，多久同步一次
+	SyncInterval time.Duration
+	// 只读模式，除了锁文件不存在时创建空的锁文件之外不会创建和修改任何文件，Put、Del、Merge返回ErrReadOnly
+	// 持有目录的共享锁，不能和以读写方式打开的DB同时打开同一个目录
+	ReadOnly bool
+	// 新建数据文件使用的格式版本，为0时使用disk.DefaultFormatVersion
+	// 已有的数据文件按照各自文件头中的版本读写，merge之后会改写成这里的版本
+	FormatVersion uint16
+	// 写入时value使用的压缩算法，默认不压缩
+	// 每条LogRecord都记录了自己是否压缩，修改之后已有的数据仍然可以读取，merge之后会按照新的配置重新压缩
+	Compression disk.Compression
+	// 数据文件的加密配置，为nil时不加密
+	// 打开加密过的数据文件时必须配置，merge之后所有数据都会用当前的密钥重新加密
+	Encryption *disk.Encryption
+	// older file是否使用mmap读取，读取不需要系统调用，View可以不经过拷贝直接访问value
+	Mmap bool
+	// 数据文件的存储后端，为nil时使用本地文件系统
+	// hint文件、merge完成标记和目录锁始终写在Dir下
+	Storage disk.Storage
+}
+
+// syncOptions 返回实际生效的同步策略
+func (o *Options) syncOptions() disk.SyncOptions {
+	if o.AlwaysSync {
+		return disk.SyncOptions{Policy: disk.SyncAlways}
+	}
+	return disk.SyncOptions{Policy: o.SyncPolicy, Bytes: o.SyncBytes, Interval: o.SyncInterval}
+}
+
+// formatVersion 返回新建数据文件使用的格式版本
+func (o *Options) formatVersion() uint16 {
+	if o.FormatVersion == 0 {
+		return disk.DefaultFormatVersion
+	}
+	return o.FormatVersion
+}
+
+// storage 返回数据文件的存储后端
+func (o *Options) storage() disk.Storage {
+	if o.Storage == nil {
+		return disk.FileStorage{}
+	}
+	return o.Storage
+}
+
+// encodingOptions 返回写入数据文件时的存储后端和LogRecord的编码配置，活跃文件和merge文件共用
+func (o *Options) encodingOptions() []disk.ManagerOption {
+	return []disk.ManagerOption{
+		disk.WithStorage(o.storage()),
+		disk.WithFormatVersion(o.formatVersion()),
+		disk.WithCompression(o.Compression),
+		disk.WithEncryption(o.Encryption),
+	}
 }
 
 // NewDefaultOptions 返回默认的配置项
@@ -51,3 +114,57 @@ func AlwaysSyncOption(alwaysSync bool) OptionsFunc {
 		o.AlwaysSync = alwaysSync
 	}
 }
+
+func SyncPolicyOption(policy disk.SyncPolicy) OptionsFunc {
+	return func(o *Options) {
+		o.SyncPolicy = policy
+	}
+}
+
+func SyncBytesOption(bytes int64) OptionsFunc {
+	return func(o *Options) {
+		o.SyncBytes = bytes
+	}
+}
+
+func SyncIntervalOption(interval time.Duration) OptionsFunc {
+	return func(o *Options) {
+		o.SyncInterval = interval
+	}
+}
+
+func ReadOnlyOption(readOnly bool) OptionsFunc {
+	return func(o *Options) {
+		o.ReadOnly = readOnly
+	}
+}
+
+func FormatVersionOption(version uint16) OptionsFunc {
+	return func(o *Options) {
+		o.FormatVersion = version
+	}
+}
+
+func CompressionOption(c disk.Compression) OptionsFunc {
+	return func(o *Options) {
+		o.Compression = c
+	}
+}
+
+func EncryptionOption(e *disk.Encryption) OptionsFunc {
+	return func(o *Options) {
+		o.Encryption = e
+	}
+}
+
+func MmapOption(mmap bool) OptionsFunc {
+	return func(o *Options) {
+		o.Mmap = mmap
+	}
+}
+
+func StorageOption(s disk.Storage) OptionsFunc {
+	return func(o *Options) {
+		o.Storage = s
+	}
+}
@@ -0,0 +1,67 @@
+// Package compress 提供value压缩使用的压缩算法，都是无依赖的本地实现
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync"
+)
+
+// MaxDecodedLen 解压后数据的长度上限，超过的一定是损坏的数据
+const MaxDecodedLen = 1 << 32
+
+// ErrCorrupt 压缩数据损坏
+var ErrCorrupt = errors.New("corrupt compressed data")
+
+// Codec 压缩算法
+type Codec interface {
+	// Encode 将src压缩后追加到dst
+	Encode(dst, src []byte) []byte
+	// Decode 将src解压后追加到dst，src损坏时返回ErrCorrupt
+	Decode(dst, src []byte) ([]byte, error)
+}
+
+// Flate DEFLATE(LZ77+huffman)最高压缩级别，比LZ慢但是压缩率更高，类似zstd的定位
+type Flate struct{}
+
+var (
+	flateWriters = sync.Pool{
+		New: func() any {
+			// 只有level不合法时才会返回error
+			w, _ := flate.NewWriter(nil, flate.BestCompression)
+			return w
+		},
+	}
+	flateReaders = sync.Pool{
+		New: func() any {
+			return flate.NewReader(nil)
+		},
+	}
+)
+
+func (Flate) Encode(dst, src []byte) []byte {
+	bf := bytes.NewBuffer(dst)
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(bf)
+	// 写入bytes.Buffer不会失败
+	_, _ = w.Write(src)
+	_ = w.Close()
+	return bf.Bytes()
+}
+
+func (Flate) Decode(dst, src []byte) ([]byte, error) {
+	r := flateReaders.Get().(io.ReadCloser)
+	defer flateReaders.Put(r)
+	if err := r.(flate.Resetter).Reset(bytes.NewReader(src), nil); err != nil {
+		return nil, err
+	}
+	bf := bytes.NewBuffer(dst)
+	n, err := bf.ReadFrom(io.LimitReader(r, MaxDecodedLen+1))
+	if err != nil || n > MaxDecodedLen {
+		return nil, ErrCorrupt
+	}
+	return bf.Bytes(), nil
+}
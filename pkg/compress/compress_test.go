@@ -0,0 +1,106 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testInputs() map[string][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 4096)
+	rnd.Read(random)
+	var doc bytes.Buffer
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&doc, `{"id":%d,"name":"user-%d","email":"user-%d@example.com","active":true,"tags":["a","b"]},`, i, i, i)
+	}
+	return map[string][]byte{
+		"empty":  {},
+		"short":  []byte("abc"),
+		"repeat": bytes.Repeat([]byte("a"), 10000),
+		"random": random,
+		"json":   doc.Bytes(),
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []Codec{LZ{}, Flate{}} {
+		for name, src := range testInputs() {
+			encoded := codec.Encode(nil, src)
+			decoded, err := codec.Decode(nil, encoded)
+			require.NoError(t, err, "%T %s", codec, name)
+			require.Equal(t, len(src), len(decoded), "%T %s", codec, name)
+			require.True(t, bytes.Equal(src, decoded), "%T %s", codec, name)
+
+			// 追加到已有的数据之后
+			encoded = codec.Encode([]byte("prefix"), src)
+			require.Equal(t, []byte("prefix"), encoded[:6])
+			decoded, err = codec.Decode([]byte("prefix"), encoded[6:])
+			require.NoError(t, err)
+			require.True(t, bytes.Equal(append([]byte("prefix"), src...), decoded), "%T %s", codec, name)
+		}
+		doc := testInputs()["json"]
+		require.Less(t, len(codec.Encode(nil, doc))*5, len(doc), "%T", codec)
+	}
+}
+
+func TestLZ_Corrupt(t *testing.T) {
+	src := testInputs()["json"]
+	encoded := LZ{}.Encode(nil, src)
+	// 任何位置截断或者篡改都不能panic
+	for cut := 0; cut < len(encoded); cut++ {
+		_, err := LZ{}.Decode(nil, encoded[:cut])
+		require.ErrorIs(t, err, ErrCorrupt, "cut at %d", cut)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		bs := append([]byte(nil), encoded...)
+		bs[rnd.Intn(len(bs))] ^= byte(rnd.Intn(255) + 1)
+		_, _ = LZ{}.Decode(nil, bs)
+	}
+	_, err := Flate{}.Decode(nil, []byte("not deflate"))
+	require.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestLZ_HostileLength(t *testing.T) {
+	// 头部声称的长度很大，实际的数据很短，不能按头部的长度分配内存
+	src := binary.AppendUvarint(nil, MaxDecodedLen)
+	src = binary.AppendUvarint(src, 1<<1)
+	src = append(src, 'x')
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := LZ{}.Decode(nil, src)
+	runtime.ReadMemStats(&after)
+	require.ErrorIs(t, err, ErrCorrupt)
+	require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+
+	_, err = LZ{}.Decode(nil, binary.AppendUvarint(nil, MaxDecodedLen+1))
+	require.ErrorIs(t, err, ErrCorrupt)
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	src := testInputs()["json"]
+	for _, codec := range []Codec{LZ{}, Flate{}} {
+		encoded := codec.Encode(nil, src)
+		b.Run(fmt.Sprintf("%T/encode", codec), func(b *testing.B) {
+			b.SetBytes(int64(len(src)))
+			b.ReportMetric(float64(len(src))/float64(len(encoded)), "ratio")
+			for i := 0; i < b.N; i++ {
+				_ = codec.Encode(nil, src)
+			}
+		})
+		b.Run(fmt.Sprintf("%T/decode", codec), func(b *testing.B) {
+			b.SetBytes(int64(len(src)))
+			for i := 0; i < b.N; i++ {
+				if _, err := codec.Decode(nil, encoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package compress
+
+/*
+LZ是类似snappy的LZ77块压缩，只做重复串匹配不做熵编码，速度快，适合压缩率要求不高的场景
+压缩后的格式为 uvarint(原始长度) | element...
+每个element以一个uvarint开头，最低位为0表示literal，为1表示copy，其余位是长度
+  - literal: uvarint(len<<1) | len字节的原始数据
+  - copy: uvarint(len<<1|1) | uvarint(offset)，从已解压数据末尾往前offset处复制len字节，offset可以小于len
+*/
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+const (
+	lzMinMatch  = 4
+	lzHashBits  = 14
+	lzMaxOffset = 1 << 16
+	// lzPreallocRatio 解压时按输入长度的多少倍预分配输出
+	lzPreallocRatio = 16
+)
+
+// LZ 类似snappy的快速压缩
+type LZ struct{}
+
+func lzHash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - lzHashBits)
+}
+
+// Encode 将src压缩后追加到dst
+func (LZ) Encode(dst, src []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+	if len(src) < lzMinMatch {
+		return appendLiteral(dst, src)
+	}
+	var table [1 << lzHashBits]int32
+	lit := 0 // 还没有输出的literal的起点
+	for i := 0; i+lzMinMatch <= len(src); {
+		cur := binary.LittleEndian.Uint32(src[i:])
+		h := lzHash(cur)
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || i-cand > lzMaxOffset || binary.LittleEndian.Uint32(src[cand:]) != cur {
+			i++
+			continue
+		}
+		n := lzMinMatch + matchLen(src[cand+lzMinMatch:], src[i+lzMinMatch:])
+		dst = appendLiteral(dst, src[lit:i])
+		dst = binary.AppendUvarint(dst, uint64(n)<<1|1)
+		dst = binary.AppendUvarint(dst, uint64(i-cand))
+		i += n
+		lit = i
+	}
+	return appendLiteral(dst, src[lit:])
+}
+
+// matchLen 返回a和b的公共前缀长度
+func matchLen(a, b []byte) int {
+	n := 0
+	for len(a) >= 8 && len(b) >= 8 {
+		x := binary.LittleEndian.Uint64(a) ^ binary.LittleEndian.Uint64(b)
+		if x != 0 {
+			return n + bits.TrailingZeros64(x)/8
+		}
+		a, b, n = a[8:], b[8:], n+8
+	}
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		a, b, n = a[1:], b[1:], n+1
+	}
+	return n
+}
+
+func appendLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(lit))<<1)
+	return append(dst, lit...)
+}
+
+// Decode 将src解压后追加到dst，src损坏时返回ErrCorrupt
+func (LZ) Decode(dst, src []byte) ([]byte, error) {
+	total, n := binary.Uvarint(src)
+	if n <= 0 || total > uint64(MaxDecodedLen) || total > uint64(math.MaxInt-len(dst)) {
+		return nil, ErrCorrupt
+	}
+	src = src[n:]
+	base := len(dst)
+	// 原始长度来自输入，不可信，预分配的空间不超过输入长度的lzPreallocRatio倍，更长的输出由append按需扩容
+	prealloc := int(total)
+	if limit := lzPreallocRatio * (len(src) + 1); prealloc > limit {
+		prealloc = limit
+	}
+	if cap(dst)-base < prealloc {
+		grown := make([]byte, base, base+prealloc)
+		copy(grown, dst)
+		dst = grown
+	}
+	end := base + int(total)
+	for len(src) > 0 {
+		tag, n := binary.Uvarint(src)
+		if n <= 0 {
+			return nil, ErrCorrupt
+		}
+		src = src[n:]
+		length := tag >> 1
+		if length > uint64(end-len(dst)) {
+			return nil, ErrCorrupt
+		}
+		if tag&1 == 0 {
+			if length > uint64(len(src)) {
+				return nil, ErrCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		}
+		offset, n := binary.Uvarint(src)
+		if n <= 0 || offset == 0 || offset > uint64(len(dst)-base) {
+			return nil, ErrCorrupt
+		}
+		src = src[n:]
+		from := len(dst) - int(offset)
+		if offset >= length {
+			dst = append(dst, dst[from:from+int(length)]...)
+			continue
+		}
+		// offset小于length时复制的内容会和正在写入的部分重叠，只能逐字节复制
+		for i := 0; i < int(length); i++ {
+			dst = append(dst, dst[from+i])
+		}
+	}
+	if len(dst) != end {
+		return nil, ErrCorrupt
+	}
+	return dst, nil
+}
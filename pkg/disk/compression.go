@@ -0,0 +1,84 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+
+	"bitcask-go/pkg/compress"
+)
+
+// Compression 写入时value使用的压缩算法
+// 压缩后的LogRecord在type字节中设置compressFlag，value的第一个字节是压缩算法，之后是压缩后的数据
+type Compression uint8
+
+const (
+	// CompressionNone 不压缩
+	CompressionNone Compression = iota
+	// CompressionFast 类似snappy的LZ77压缩，速度快
+	CompressionFast
+	// CompressionHigh DEFLATE最高压缩级别，压缩率高
+	CompressionHigh
+
+	// minCompressSz 太短的value压缩不划算
+	minCompressSz = 64
+)
+
+// ErrUnknownCompression value使用了无法识别的压缩算法
+var ErrUnknownCompression = errors.New("unknown compression")
+
+func (c Compression) codec() (compress.Codec, error) {
+	switch c {
+	case CompressionFast:
+		return compress.LZ{}, nil
+	case CompressionHigh:
+		return compress.Flate{}, nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnknownCompression, c)
+}
+
+// Valid 判断是否是可以使用的压缩算法
+func (c Compression) Valid() bool {
+	return c <= CompressionHigh
+}
+
+// compressRecord 压缩NormalRecord的value，压缩后没有变小时原样返回record
+func compressRecord(record *LogRecord, c Compression) (*LogRecord, error) {
+	if c == CompressionNone || record.typ != NormalRecord || record.compressed || len(record.value) < minCompressSz {
+		return record, nil
+	}
+	codec, err := c.codec()
+	if err != nil {
+		return nil, err
+	}
+	stored := codec.Encode([]byte{byte(c)}, record.value)
+	if len(stored) >= len(record.value) {
+		return record, nil
+	}
+	res := *record
+	res.value = stored
+	res.valueSz = uint64(len(stored))
+	res.compressed = true
+	return &res, nil
+}
+
+// decompressRecord 将从磁盘读出的LogRecord的value解压
+func decompressRecord(record *LogRecord) error {
+	if !record.compressed {
+		return nil
+	}
+	if len(record.value) == 0 {
+		return fmt.Errorf("%w: empty compressed value", compress.ErrCorrupt)
+	}
+	codec, err := Compression(record.value[0]).codec()
+	if err != nil {
+		return err
+	}
+	value, err := codec.Decode(nil, record.value[1:])
+	if err != nil {
+		return err
+	}
+	record.value = value
+	record.valueSz = uint64(len(value))
+	record.compressed = false
+	return nil
+}
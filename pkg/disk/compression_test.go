@@ -0,0 +1,96 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitcask-go/pkg/index"
+)
+
+func jsonValue(i int) []byte {
+	var doc bytes.Buffer
+	for j := 0; j < 20; j++ {
+		fmt.Fprintf(&doc, `{"id":%d,"name":"user-%d","active":true},`, i, j)
+	}
+	return doc.Bytes()
+}
+
+func TestDataFileImpl_Compression(t *testing.T) {
+	for _, version := range []uint16{FormatV1, FormatV2} {
+		dir := t.TempDir()
+		// 先写入没有压缩的数据
+		m, err := NewManager(dir, 1, true, 4<<20, WithFormatVersion(version))
+		require.NoError(t, err)
+		plain, err := m.Write([]byte("plain"), jsonValue(0), false)
+		require.NoError(t, err)
+		require.NoError(t, m.Close())
+
+		// 同一个文件中混合压缩和没有压缩的LogRecord
+		vms := make(map[Compression]uint64)
+		for _, c := range []Compression{CompressionFast, CompressionHigh} {
+			m, err = NewManager(dir, 1, true, 4<<20, WithCompression(c))
+			require.NoError(t, err)
+			vm, err := m.Write([]byte("compressed"), jsonValue(1), false)
+			require.NoError(t, err)
+			require.Less(t, vm.ValueSz*3, plain.ValueSz, "compression %d", c)
+			vms[c] = vm.ValuePos
+			// 太短的value不压缩
+			short, err := m.Write([]byte("short"), []byte("v"), false)
+			require.NoError(t, err)
+			val, err := m.Read(short)
+			require.NoError(t, err)
+			require.Equal(t, []byte("v"), val)
+			require.NoError(t, m.Close())
+		}
+
+		m, err = NewManager(dir, 1, false, 0)
+		require.NoError(t, err)
+		val, err := m.Read(plain)
+		require.NoError(t, err)
+		require.Equal(t, jsonValue(0), val)
+		for c, pos := range vms {
+			record, size, err := m.ReadLogRecord(pos)
+			require.NoError(t, err, "compression %d", c)
+			require.Equal(t, jsonValue(1), record.Value())
+			val, err = m.Read(&index.ValueMetadata{FileID: 1, ValuePos: pos, ValueSz: size})
+			require.NoError(t, err)
+			require.Equal(t, jsonValue(1), val)
+		}
+		require.NoError(t, m.Close())
+	}
+}
+
+func TestCompressRecord(t *testing.T) {
+	record, err := NewNormalLogRecord([]byte("k"), jsonValue(1))
+	require.NoError(t, err)
+	stored, err := compressRecord(record, CompressionFast)
+	require.NoError(t, err)
+	require.True(t, stored.compressed)
+	require.False(t, record.compressed)
+	bs := stored.encode(fixedCodec{})
+	require.NotZero(t, bs[crcSz]&compressFlag)
+
+	// crc覆盖的是压缩后的数据
+	got, err := decodeRecord(bs, fixedCodec{})
+	require.NoError(t, err)
+	require.Equal(t, stored.value, got.value)
+	require.NoError(t, decompressRecord(got))
+	require.Equal(t, jsonValue(1), got.Value())
+	bs[len(bs)-1] ^= 0xff
+	_, err = decodeRecord(bs, fixedCodec{})
+	require.ErrorIs(t, err, ErrCrcCheckFailed)
+
+	// 无法识别的压缩算法
+	stored.value[0] = 0xff
+	require.ErrorIs(t, decompressRecord(stored), ErrUnknownCompression)
+
+	// 删除记录不压缩
+	del, err := NewDeleteLogRecord([]byte("k"))
+	require.NoError(t, err)
+	stored, err = compressRecord(del, CompressionHigh)
+	require.NoError(t, err)
+	require.Same(t, del, stored)
+}
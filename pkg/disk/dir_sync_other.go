@@ -0,0 +1,8 @@
+//go:build !unix
+
+package disk
+
+// syncDir 其他平台上无法打开目录做fsync，目录项的持久化交给文件系统
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package disk
+
+import "os"
+
+// syncDir fsync目录本身，目录项的修改才会落盘
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -1,8 +1,15 @@
 package disk
 
 import (
+	"crypto/cipher"
 	"errors"
 	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"bitcask-go/pkg/index"
 )
@@ -16,30 +23,214 @@ type DataFileImpl struct {
 	maxSize    int64             // 当前文件的最大大小
 	name       string
 	suffix     uint64
+	header     FileHeader
+	codec      recordCodec
+	// formatVersion 新建文件时使用的格式版本
+	formatVersion uint16
+	compression   Compression
+	encryption    *Encryption
+	// mmap older file是否使用mmap读取
+	mmap bool
+	// storage 数据文件的存储后端
+	storage Storage
+	// aead 文件头中设置了FlagEncrypted时用来加解密LogRecord
+	aead     cipher.AEAD
+	syncOpts SyncOptions
+	syncer   *groupSyncer
+	// writeMu 保证检查文件大小和追加写是原子的，也保护failed和validSize
+	writeMu sync.Mutex
+	// failed 写入失败后没能截断掉写了一半的数据，不再接受写入，validSize是之前的有效长度
+	failed    bool
+	validSize int64
 }
 
-func NewManager(dir string, suffix uint64, isActiveFile bool, maxSize int64) (DataFile, error) {
+// ManagerOption 自定义DataFileImpl的配置
+type ManagerOption func(*DataFileImpl)
+
+// WithSyncOptions 设置写入后同步到磁盘的策略，默认为SyncNever
+func WithSyncOptions(opts SyncOptions) ManagerOption {
+	return func(m *DataFileImpl) {
+		m.syncOpts = opts
+	}
+}
+
+// WithFormatVersion 设置新建文件时使用的格式版本，默认为DefaultFormatVersion，已有的文件按照文件头中的版本读写
+func WithFormatVersion(version uint16) ManagerOption {
+	return func(m *DataFileImpl) {
+		m.formatVersion = version
+	}
+}
+
+// WithCompression 设置写入时value使用的压缩算法，默认不压缩，读取时按照每条LogRecord的标记解压
+func WithCompression(c Compression) ManagerOption {
+	return func(m *DataFileImpl) {
+		m.compression = c
+	}
+}
+
+// WithEncryption 设置新建文件时使用的加密配置，默认不加密，已有的文件按照文件头中的KeyID从KeyProvider中获取密钥
+func WithEncryption(e *Encryption) ManagerOption {
+	return func(m *DataFileImpl) {
+		m.encryption = e
+	}
+}
+
+// WithMmap 设置older file是否使用MmapPersistentImpl读取，只对FileStorage生效，不支持mmap的平台上会退回到普通的文件读取
+// 使用mmap时Read返回的value可能直接引用映射的内存，文件关闭后不能再访问
+func WithMmap(mmap bool) ManagerOption {
+	return func(m *DataFileImpl) {
+		m.mmap = mmap
+	}
+}
+
+// WithStorage 设置数据文件的存储后端，默认为FileStorage
+func WithStorage(s Storage) ManagerOption {
+	return func(m *DataFileImpl) {
+		m.storage = s
+	}
+}
+
+func NewManager(dir string, suffix uint64, isActiveFile bool, maxSize int64, opts ...ManagerOption) (DataFile, error) {
 	var err error
-	res := new(DataFileImpl)
+	res := &DataFileImpl{formatVersion: DefaultFormatVersion, storage: FileStorage{}}
+	for _, opt := range opts {
+		opt(res)
+	}
 	res.suffix = suffix
-	res.name = fmt.Sprintf("%s/%06d%s", dir, suffix, dataFileExt)
-	res.persistent, err = NewFilePersistentImpl(res.name, suffix, isActiveFile)
+	res.name = DataFileName(dir, suffix)
+	res.persistent, err = res.openPersistent(isActiveFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = res.loadHeader(isActiveFile); err != nil {
+		_ = res.persistent.Close()
+		return nil, err
+	}
 	if isActiveFile {
 		// 只有activeFile才会有maxSize
 		res.maxSize = maxSize
 	}
-	return res, err
+	res.syncer = newGroupSyncer(res.syncOpts,
+		func() error { return res.persistent.Sync() },
+		func() int64 { return res.persistent.Offset() },
+	)
+	return res, nil
+}
+
+// openPersistent 打开文件对应的PersistentStorage
+func (m *DataFileImpl) openPersistent(isActiveFile bool) (PersistentStorage, error) {
+	// 只有本地文件可以mmap
+	if _, ok := m.storage.(FileStorage); ok && m.mmap && !isActiveFile {
+		persistent, err := NewMmapPersistentImpl(m.name)
+		if !errors.Is(err, errMmapUnsupported) {
+			return persistent, err
+		}
+	}
+	return m.storage.Open(m.name, m.suffix, isActiveFile)
+}
+
+// loadHeader 读取文件头，活跃文件为空或者文件头没有写完整时重新写入文件头
+func (m *DataFileImpl) loadHeader(isActiveFile bool) error {
+	header, err := readFileHeader(readerAt{m.persistent}, m.persistent.Offset(), m.suffix)
+	if !errors.Is(err, errTornFileHeader) {
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.name, err)
+		}
+		m.header = header
+		m.codec = codecFor(header.Version)
+		return m.loadKey()
+	}
+	if !isActiveFile {
+		// 只读打开时不能修改文件，当作没有文件头的文件，读取时会发现末尾不完整
+		m.header = FileHeader{Version: FormatV0}
+		m.codec = codecFor(FormatV0)
+		return nil
+	}
+	if !ValidFormatVersion(m.formatVersion) {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, m.formatVersion)
+	}
+	if err = m.persistent.Truncate(0); err != nil {
+		return err
+	}
+	m.header = newFileHeader(m.suffix, m.formatVersion)
+	m.codec = codecFor(m.formatVersion)
+	if m.encryption != nil {
+		if m.header.KeyID, _, err = m.encryption.Keys.CurrentKey(); err != nil {
+			return err
+		}
+		m.header.Flags |= m.encryption.flags()
+		if err = m.loadKey(); err != nil {
+			return err
+		}
+	}
+	if _, _, err = m.persistent.WriteToDisk(m.header.encode()); err != nil || !m.durable() {
+		return err
+	}
+	// 新建的文件要连同目录项一起落盘，否则掉电后整个文件可能消失
+	if err = m.persistent.Sync(); err != nil {
+		return err
+	}
+	return m.storage.SyncDir(filepath.Dir(m.name))
+}
+
+// durable 新建和删除文件时是否需要同步目录，SyncNever之外都需要
+func (m *DataFileImpl) durable() bool {
+	return m.syncOpts.Policy != SyncNever
+}
+
+// loadKey 文件是加密的时候，按照文件头中的KeyID准备好加解密使用的AEAD
+func (m *DataFileImpl) loadKey() error {
+	if m.header.Flags&FlagEncrypted == 0 {
+		return nil
+	}
+	if m.encryption == nil || m.encryption.Keys == nil {
+		return fmt.Errorf("%s: %w", m.name, ErrEncryptionRequired)
+	}
+	key, err := m.encryption.Keys.Key(m.header.KeyID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", m.name, err)
+	}
+	m.aead, err = newAEAD(key)
+	return err
+}
+
+// encodeRecord 按照文件的格式版本、压缩和加密配置编码LogRecord
+func (m *DataFileImpl) encodeRecord(record *LogRecord) ([]byte, error) {
+	stored, err := compressRecord(record, m.compression)
+	if err != nil {
+		return nil, err
+	}
+	if m.aead != nil {
+		if stored, err = encryptRecord(stored, m.aead, m.header.Flags&FlagEncryptedKeys != 0); err != nil {
+			return nil, err
+		}
+	}
+	return stored.encode(m.codec), nil
+}
+
+// Header 返回文件头，没有文件头的FormatV0文件返回Version为FormatV0的FileHeader
+func (m *DataFileImpl) Header() FileHeader {
+	return m.header
+}
+
+// readerAt 将PersistentStorage适配成io.ReaderAt
+type readerAt struct {
+	persistent PersistentStorage
+}
+
+func (r readerAt) ReadAt(bs []byte, offset int64) (int, error) {
+	return r.persistent.ReadFromDisk(bs, uint64(offset))
 }
 
 // 判断将LogRecord持久化存储时是否会超过文件大小限制
 // 除了常规情况还有就是就算新开一个文件也无法存储的情况，这种情况下就会暂时忽略文件大小限制，在新的文件中存储logRecord
-func (m *DataFileImpl) checkExceedFileSizeLimit(record *LogRecord) error {
+func (m *DataFileImpl) checkExceedFileSizeLimit(size int64) error {
 	// 常规情况
-	if record.Size()+m.persistent.Offset() > m.maxSize {
+	if size+m.persistent.Offset() > m.maxSize {
 		return ErrFileTooSmall
 	}
 	// 新开一个文件也无法存储的情况
-	if record.Size() > m.maxSize {
+	if size+int64(m.header.DataOffset()) > m.maxSize {
 		return ErrFileTooSmall
 	}
 	return nil
@@ -50,56 +241,99 @@ func (m *DataFileImpl) Write(key, value []byte, force bool) (wv *index.ValueMeta
 	if err != nil {
 		return
 	}
-	if !force {
-		if err = m.checkExceedFileSizeLimit(normalLogRecord); err != nil {
-			return
-		}
-	}
+	return m.WriteLogRecord(normalLogRecord, force)
+}
 
-	bs, err := normalLogRecord.Serialize()
+func (m *DataFileImpl) Del(key []byte, force bool) (dv *index.ValueMetadata, err error) {
+	delLogRecord, err := NewDeleteLogRecord(key)
 	if err != nil {
 		return
 	}
+	return m.WriteLogRecord(delLogRecord, force)
+}
 
-	offset, wn, err := m.persistent.WriteToDisk(bs)
+// WriteLogRecord 将一个已有的LogRecord原样追加写入文件，保留它原来的时间戳
+func (m *DataFileImpl) WriteLogRecord(record *LogRecord, force bool) (v *index.ValueMetadata, err error) {
+	vs, err := m.WriteLogRecords([]*LogRecord{record}, force)
 	if err != nil {
 		return
 	}
-	wv = index.NewValueMetadata(m.ID(), uint64(wn), uint64(offset), int64(normalLogRecord.tmStamp))
-	return
+	return vs[0], nil
 }
 
-func (m *DataFileImpl) Del(key []byte, force bool) (dv *index.ValueMetadata, err error) {
-	delLogRecord, err := NewDeleteLogRecord(key)
-	if err != nil {
-		return
+// WriteLogRecords 将多条LogRecord作为一次追加写连续写入文件，LogRecord按照文件的格式版本、压缩和加密配置编码
+// 文件大小的检查针对所有LogRecord编码后的总大小，写入后按照SyncOptions决定是否同步，可以并发调用，并发的写入者会共享同一次fsync
+func (m *DataFileImpl) WriteLogRecords(records []*LogRecord, force bool) (vs []*index.ValueMetadata, err error) {
+	var buf []byte
+	sizes := make([]int, len(records))
+	for i, record := range records {
+		bs, err := m.encodeRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = len(bs)
+		buf = append(buf, bs...)
+	}
+
+	m.writeMu.Lock()
+	// 返回ErrFileTooSmall让调用方切换到新的文件
+	if m.failed {
+		m.writeMu.Unlock()
+		return nil, ErrFileTooSmall
 	}
 	if !force {
-		if err = m.checkExceedFileSizeLimit(delLogRecord); err != nil {
+		if err = m.checkExceedFileSizeLimit(int64(len(buf))); err != nil {
+			m.writeMu.Unlock()
 			return
 		}
 	}
-
-	bs, err := delLogRecord.Serialize()
+	before := m.persistent.Offset()
+	offset, wn, err := m.persistent.WriteToDisk(buf)
 	if err != nil {
-		return
+		m.rollback(before)
 	}
-	offset, wn, err := m.persistent.WriteToDisk(bs)
+	m.writeMu.Unlock()
 	if err != nil {
 		return
 	}
-	dv = index.NewValueMetadata(m.ID(), uint64(wn), uint64(offset), int64(delLogRecord.tmStamp))
+	if err = m.syncer.afterWrite(offset + int64(wn)); err != nil {
+		return
+	}
+	vs = make([]*index.ValueMetadata, len(records))
+	for i, record := range records {
+		vs[i] = index.NewValueMetadata(m.ID(), uint64(sizes[i]), uint64(offset), int64(record.tmStamp))
+		vs[i].ExpireAt = int64(record.expireAt)
+		offset += int64(sizes[i])
+	}
 	return
+}
 
+// rollback 截断掉写入失败时写了一半的数据，否则之后的写入会跟在这些数据后面，恢复时会从这里截断，把它们一起丢掉
+// 截断也失败时把文件标记为failed，调用方需要持有writeMu
+func (m *DataFileImpl) rollback(size int64) {
+	if m.persistent.Offset() == size {
+		return
+	}
+	if err := m.persistent.Truncate(size); err != nil {
+		m.failed, m.validSize = true, size
+		return
+	}
+	m.syncer.reset()
 }
 
+// Read 读取mv对应的value，使用mmap时返回的value可能直接引用映射的内存
 func (m *DataFileImpl) Read(mv *index.ValueMetadata) (value []byte, err error) {
-	bs := make([]byte, mv.ValueSz)
-	_, err = m.persistent.ReadFromDisk(bs, mv.ValuePos)
+	var bs []byte
+	if zc, ok := m.persistent.(ZeroCopyReader); ok {
+		bs, err = zc.Slice(mv.ValuePos, int(mv.ValueSz))
+	} else {
+		bs = make([]byte, mv.ValueSz)
+		_, err = m.persistent.ReadFromDisk(bs, mv.ValuePos)
+	}
 	if err != nil {
 		return
 	}
-	item, err := new(LogRecord).Deserialize(bs)
+	item, err := m.decodeRecord(bs)
 	if err != nil {
 		return
 	}
@@ -107,25 +341,162 @@ func (m *DataFileImpl) Read(mv *index.ValueMetadata) (value []byte, err error) {
 	return
 }
 
+// ReadLogRecord 读取offset处的LogRecord，返回LogRecord和它在文件中占用的字节数
+// offset到达文件末尾时返回io.EOF，文件末尾的LogRecord不完整时返回io.ErrUnexpectedEOF
+// LogRecord损坏时返回ErrCrcCheckFailed或ErrUnknownRecordType，可以用IsCorruptRecord判断
+// 返回的LogRecord不引用底层存储，key可以直接放进索引
+func (m *DataFileImpl) ReadLogRecord(offset uint64) (record *LogRecord, size uint64, err error) {
+	bs, err := m.readRaw(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	record, err = m.decodeRecord(bs)
+	if err != nil {
+		return nil, 0, err
+	}
+	return record, uint64(len(bs)), nil
+}
+
+// readRaw 读取offset处一条完整LogRecord的原始数据，读到文件末尾返回io.EOF
+func (m *DataFileImpl) readRaw(offset uint64) ([]byte, error) {
+	fileSz := uint64(m.persistent.Offset())
+	if offset >= fileSz {
+		return nil, io.EOF
+	}
+	// 先读头部得到整条LogRecord的长度，文件末尾的头部可能不足maxHeaderSize
+	headerSz := uint64(m.codec.maxHeaderSize())
+	if offset+headerSz > fileSz {
+		headerSz = fileSz - offset
+	}
+	header := make([]byte, headerSz)
+	if _, err := m.persistent.ReadFromDisk(header, offset); err != nil {
+		return nil, err
+	}
+	size, err := recordSize(header, m.codec)
+	if err != nil {
+		return nil, err
+	}
+	if size > fileSz-offset {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	bs := make([]byte, size)
+	if _, err = m.persistent.ReadFromDisk(bs, offset); err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
+
+// decodeRecord 解码从文件中读出的LogRecord，加密的数据会被解密，压缩过的value会被解压
+func (m *DataFileImpl) decodeRecord(bs []byte) (*LogRecord, error) {
+	record, err := decodeRecord(bs, m.codec)
+	if err != nil {
+		return nil, err
+	}
+	if m.aead != nil {
+		if err = decryptRecord(record, m.aead, m.header.Flags&FlagEncryptedKeys != 0); err != nil {
+			return nil, err
+		}
+	}
+	if err = decompressRecord(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Size 返回文件当前的大小
+func (m *DataFileImpl) Size() int64 {
+	return m.persistent.Offset()
+}
+
+func (m *DataFileImpl) Truncate(size int64) error {
+	if err := m.persistent.Truncate(size); err != nil {
+		return err
+	}
+	m.syncer.reset()
+	return nil
+}
+
 func (m *DataFileImpl) ID() uint64 {
 	return m.suffix
 }
 
+// Sync 将已经写入的数据同步到磁盘，和正在进行的fsync共享
+func (m *DataFileImpl) Sync() error {
+	return m.syncer.syncTo(m.persistent.Offset())
+}
+
 func (m *DataFileImpl) Close() error {
 	return m.persistent.Close()
 }
 
 func (m *DataFileImpl) Delete() error {
-	return m.persistent.Delete()
+	if err := m.persistent.Delete(); err != nil || !m.durable() {
+		return err
+	}
+	return m.storage.SyncDir(filepath.Dir(m.name))
 }
 
 func (m *DataFileImpl) ToOlderFile() (DataFile, error) {
 	var err error
+	// 再尝试一次截断，仍然失败时末尾不完整的数据留给Verify和Repair处理
+	m.writeMu.Lock()
+	if m.failed && m.persistent.Truncate(m.validSize) == nil {
+		m.failed = false
+		m.syncer.reset()
+	}
+	m.writeMu.Unlock()
+	// older file不会再写入，不管同步策略是什么都在这里把剩下的数据同步掉
+	// 恢复时只有ID最大的文件末尾可以不完整，切换之后掉电不能在older file末尾留下不完整的LogRecord
+	if err = m.Sync(); err != nil {
+		return nil, err
+	}
 	if err = m.Close(); err != nil {
 		return nil, err
 	}
 
 	newDataFile := m
-	newDataFile.persistent, err = NewFilePersistentImpl(m.name, m.suffix, false)
+	newDataFile.persistent, err = m.openPersistent(false)
 	return newDataFile, err
 }
+
+// IsCorruptRecord 判断ReadLogRecord返回的错误是否表示LogRecord不完整或者已经损坏
+func IsCorruptRecord(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrCrcCheckFailed) || errors.Is(err, ErrUnknownRecordType)
+}
+
+// DataFileName 返回dir下文件ID为suffix的数据文件路径
+func DataFileName(dir string, suffix uint64) string {
+	return fmt.Sprintf("%s/%06d%s", dir, suffix, dataFileExt)
+}
+
+// ParseDataFileName 从数据文件的路径中解析出所在目录和文件ID，不是数据文件时ok为false
+func ParseDataFileName(path string) (dir string, fileID uint64, ok bool) {
+	name := filepath.Base(path)
+	if !strings.HasSuffix(name, dataFileExt) {
+		return "", 0, false
+	}
+	fileID, err := strconv.ParseUint(strings.TrimSuffix(name, dataFileExt), 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return filepath.Dir(path), fileID, true
+}
+
+// ListDataFileIDs 返回s中dir下所有数据文件的ID，按从小到大排序
+// dir不存在时返回空
+func ListDataFileIDs(s Storage, dir string) ([]uint64, error) {
+	names, err := s.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, name := range names {
+		// 不是我们生成的数据文件，跳过
+		if _, id, ok := ParseDataFileName(name); ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
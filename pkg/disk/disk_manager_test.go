@@ -1,6 +1,7 @@
 package disk
 
 import (
+	"io"
 	"os"
 	"path"
 	"testing"
@@ -28,8 +29,9 @@ func Test_Manager(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, keyDir1)
 	assert.Equal(t, uint64(0), keyDir1.FileID)
-	// 新的文件第一次写入，偏移肯定是0
-	assert.Equal(t, uint64(0), keyDir1.ValuePos)
+	// 新的文件第一次写入，偏移紧跟在文件头之后
+	assert.Equal(t, m.Header().DataOffset(), keyDir1.ValuePos)
+	assert.Equal(t, DefaultFormatVersion, m.Header().Version)
 	assert.NotZero(t, keyDir1.TsTamp)
 
 	gotV1, err := m.Read(keyDir1)
@@ -64,3 +66,51 @@ func Test_Manager(t *testing.T) {
 	}()
 
 }
+
+func TestDataFileImpl_ReadLogRecord(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir, 1, true, 4<<20)
+	require.NoError(t, err)
+	vm1, err := m.Write([]byte("k1"), []byte("v1"), false)
+	require.NoError(t, err)
+	vm2, err := m.Del([]byte("k1"), false)
+	require.NoError(t, err)
+	require.NoError(t, m.Close())
+
+	// 重新打开已有文件，顺序读出所有LogRecord
+	m, err = NewManager(dir, 1, false, 0)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, m.Close())
+	}()
+	offset := m.Header().DataOffset()
+	record, size, err := m.ReadLogRecord(offset)
+	require.NoError(t, err)
+	require.Equal(t, vm1.ValueSz, size)
+	require.Equal(t, NormalRecord, record.Op())
+	require.Equal(t, []byte("k1"), record.Key())
+	require.Equal(t, []byte("v1"), record.Value())
+
+	record, size, err = m.ReadLogRecord(offset + size)
+	require.NoError(t, err)
+	require.Equal(t, vm2.ValueSz, size)
+	require.Equal(t, DeleteRecord, record.Op())
+	require.Equal(t, []byte("k1"), record.Key())
+
+	_, _, err = m.ReadLogRecord(vm2.ValuePos + vm2.ValueSz)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestListDataFileIDs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"000010.db", "000002.db", "000001.db", "lock", "abc.db"} {
+		require.NoError(t, os.WriteFile(path.Join(dir, name), nil, 0600))
+	}
+	ids, err := ListDataFileIDs(FileStorage{}, dir)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2, 10}, ids)
+
+	ids, err = ListDataFileIDs(FileStorage{}, path.Join(dir, "not-exist"))
+	require.NoError(t, err)
+	require.Empty(t, ids)
+}
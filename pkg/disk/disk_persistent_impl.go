@@ -27,12 +27,6 @@ func (f *FilePersistentImpl) Offset() int64 {
 	return res
 }
 
-func (f *FilePersistentImpl) setOffset(offset int64) {
-	f.Lock()
-	f.writeOffset = offset
-	f.Unlock()
-}
-
 func NewFilePersistentImpl(absPath string, suffix uint64, isActiveFile bool) (PersistentStorage, error) {
 	var err error
 	res := new(FilePersistentImpl)
@@ -48,6 +42,13 @@ func NewFilePersistentImpl(absPath string, suffix uint64, isActiveFile bool) (Pe
 	if err != nil {
 		return nil, err
 	}
+	// 重新打开已有文件时，写入位置从文件末尾开始
+	info, err := res.file.Stat()
+	if err != nil {
+		_ = res.file.Close()
+		return nil, err
+	}
+	res.writeOffset = info.Size()
 	res.suffix = suffix
 	return res, nil
 }
@@ -60,20 +61,31 @@ func (f *FilePersistentImpl) ReadFromDisk(bs []byte, offset uint64) (int, error)
 }
 
 func (f *FilePersistentImpl) WriteToDisk(bs []byte) (offset int64, wn int, err error) {
+	// 写入和更新写入位置要在同一把锁内完成，否则并发写入时拿到的offset会错乱
 	f.Lock()
+	defer f.Unlock()
+	offset = f.writeOffset
 	wn, err = f.file.Write(bs)
-	f.Unlock()
-	offset = f.Offset()
-	f.setOffset(offset + int64(wn))
+	f.writeOffset += int64(wn)
 	return
 }
 
+// Sync fsync时不持有锁，不会阻塞同时进行的写入和读取
+// 调用方在Sync之前通过Offset拿到的位置之前的数据都会落盘，file在创建之后不会被替换，可以不加锁访问
 func (f *FilePersistentImpl) Sync() error {
-	f.Lock()
-	defer f.Lock()
 	return f.file.Sync()
 }
 
+func (f *FilePersistentImpl) Truncate(size int64) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.file.Truncate(size); err != nil {
+		return err
+	}
+	f.writeOffset = size
+	return nil
+}
+
 func (f *FilePersistentImpl) Close() error {
 	f.Lock()
 	defer f.Unlock()
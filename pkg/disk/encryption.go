@@ -0,0 +1,170 @@
+package disk
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+const (
+	// FlagEncrypted 文件头flags中表示文件中的value是加密的，加密使用的密钥是文件头中的KeyID
+	FlagEncrypted uint16 = 1 << iota
+	// FlagEncryptedKeys 文件头flags中表示文件中的key也是加密的
+	FlagEncryptedKeys
+)
+
+var (
+	// ErrEncryptionRequired 数据文件是加密的，但是没有配置Encryption
+	ErrEncryptionRequired = errors.New("data file is encrypted but no encryption is configured")
+	// ErrKeyNotFound KeyProvider中没有对应ID的密钥
+	ErrKeyNotFound = errors.New("encryption key not found")
+	// ErrDecryptFailed 解密失败，密钥不对或者密文被篡改
+	ErrDecryptFailed = errors.New("decrypt failed")
+)
+
+// KeyProvider 提供加密使用的密钥，密钥长度必须是16、24或32字节，分别对应AES-128、AES-192和AES-256
+type KeyProvider interface {
+	// CurrentKey 返回新建数据文件使用的密钥和它的ID
+	CurrentKey() (id uint32, key []byte, err error)
+	// Key 返回ID为id的密钥，读取旧的数据文件时使用，找不到时返回ErrKeyNotFound
+	Key(id uint32) ([]byte, error)
+}
+
+// Encryption 数据文件的加密配置
+// 每条LogRecord的value(EncryptKeys为true时还有key)使用AES-GCM单独加密，格式为 nonce | 密文 | tag，nonce每次随机生成
+// 每个数据文件只使用一个密钥，密钥的ID记录在文件头中，轮换密钥之后新建的文件使用新的密钥，旧文件在merge时用新的密钥重新加密
+type Encryption struct {
+	Keys KeyProvider
+	// EncryptKeys 是否同时加密key，加密key的数据文件不会生成hint文件
+	EncryptKeys bool
+}
+
+// Validate 检查配置是否可用，当前的密钥必须是合法的AES密钥
+func (e *Encryption) Validate() error {
+	if e.Keys == nil {
+		return fmt.Errorf("%w: no key provider", ErrKeyNotFound)
+	}
+	_, key, err := e.Keys.CurrentKey()
+	if err != nil {
+		return err
+	}
+	_, err = newAEAD(key)
+	return err
+}
+
+// flags 返回使用这个配置新建的数据文件的文件头flags
+func (e *Encryption) flags() uint16 {
+	if e == nil {
+		return 0
+	}
+	if e.EncryptKeys {
+		return FlagEncrypted | FlagEncryptedKeys
+	}
+	return FlagEncrypted
+}
+
+// Matches 判断文件头为h的数据文件是否按照当前的配置和当前的密钥加密
+func (e *Encryption) Matches(h FileHeader) (bool, error) {
+	const mask = FlagEncrypted | FlagEncryptedKeys
+	if h.Flags&mask != e.flags() {
+		return false, nil
+	}
+	if e == nil {
+		return true, nil
+	}
+	id, _, err := e.Keys.CurrentKey()
+	if err != nil {
+		return false, err
+	}
+	return h.KeyID == id, nil
+}
+
+// StaticKeyProvider 使用固定的一组密钥，Current是新数据使用的密钥ID
+type StaticKeyProvider struct {
+	Current uint32
+	Keys    map[uint32][]byte
+}
+
+func (p *StaticKeyProvider) CurrentKey() (uint32, []byte, error) {
+	key, err := p.Key(p.Current)
+	return p.Current, key, err
+}
+
+func (p *StaticKeyProvider) Key(id uint32) ([]byte, error) {
+	key, ok := p.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal 加密plaintext，返回 nonce | 密文 | tag
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	out := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrDecryptFailed
+	}
+	nonce := sealed[:aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, sealed[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plaintext, nil
+}
+
+// encryptRecord 加密LogRecord，key作为value的附加数据，value不能被挪到别的key下
+func encryptRecord(record *LogRecord, aead cipher.AEAD, encryptKeys bool) (*LogRecord, error) {
+	res := *record
+	var err error
+	if hasValue(res.typ) {
+		if res.value, err = seal(aead, record.value, record.key); err != nil {
+			return nil, err
+		}
+		res.valueSz = uint64(len(res.value))
+	}
+	if encryptKeys {
+		if res.key, err = seal(aead, record.key, nil); err != nil {
+			return nil, err
+		}
+		res.ksz = uint64(len(res.key))
+	}
+	return &res, nil
+}
+
+// decryptRecord 解密从磁盘读出的LogRecord
+func decryptRecord(record *LogRecord, aead cipher.AEAD, encryptKeys bool) error {
+	if encryptKeys {
+		key, err := open(aead, record.key, nil)
+		if err != nil {
+			return err
+		}
+		record.key = key
+		record.ksz = uint64(len(key))
+	}
+	if hasValue(record.typ) {
+		value, err := open(aead, record.value, record.key)
+		if err != nil {
+			return err
+		}
+		record.value = value
+		record.valueSz = uint64(len(value))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testKeys() *StaticKeyProvider {
+	return &StaticKeyProvider{
+		Current: 1,
+		Keys: map[uint32][]byte{
+			1: bytes.Repeat([]byte{1}, 32),
+			2: bytes.Repeat([]byte{2}, 16),
+		},
+	}
+}
+
+func TestDataFileImpl_Encryption(t *testing.T) {
+	for _, encryptKeys := range []bool{false, true} {
+		dir := t.TempDir()
+		keys := testKeys()
+		enc := &Encryption{Keys: keys, EncryptKeys: encryptKeys}
+		m, err := NewManager(dir, 1, true, 4<<20, WithEncryption(enc), WithCompression(CompressionFast))
+		require.NoError(t, err)
+		require.Equal(t, uint32(1), m.Header().KeyID)
+		require.NotZero(t, m.Header().Flags&FlagEncrypted)
+		require.Equal(t, encryptKeys, m.Header().Flags&FlagEncryptedKeys != 0)
+		vm, err := m.Write([]byte("secret-key"), jsonValue(1), false)
+		require.NoError(t, err)
+		_, err = m.Del([]byte("secret-key"), false)
+		require.NoError(t, err)
+		require.NoError(t, m.Close())
+
+		content, err := os.ReadFile(DataFileName(dir, 1))
+		require.NoError(t, err)
+		require.NotContains(t, string(content), `"active":true`)
+		require.Equal(t, !encryptKeys, bytes.Contains(content, []byte("secret-key")))
+
+		// 轮换密钥之后旧文件仍然使用文件头中的密钥
+		keys.Current = 2
+		m, err = NewManager(dir, 1, false, 0, WithEncryption(enc))
+		require.NoError(t, err)
+		val, err := m.Read(vm)
+		require.NoError(t, err)
+		require.Equal(t, jsonValue(1), val)
+		offset := m.Header().DataOffset()
+		for _, op := range []LogRecordType{NormalRecord, DeleteRecord} {
+			record, size, err := m.ReadLogRecord(offset)
+			require.NoError(t, err)
+			require.Equal(t, op, record.Op())
+			require.Equal(t, []byte("secret-key"), record.Key())
+			offset += size
+		}
+		require.NoError(t, m.Close())
+
+		// 没有配置加密或者找不到密钥
+		_, err = NewManager(dir, 1, false, 0)
+		require.ErrorIs(t, err, ErrEncryptionRequired)
+		delete(keys.Keys, 1)
+		_, err = NewManager(dir, 1, false, 0, WithEncryption(enc))
+		require.ErrorIs(t, err, ErrKeyNotFound)
+
+		// 密钥不对
+		keys.Keys[1] = bytes.Repeat([]byte{3}, 32)
+		m, err = NewManager(dir, 1, false, 0, WithEncryption(enc))
+		require.NoError(t, err)
+		_, err = m.Read(vm)
+		require.ErrorIs(t, err, ErrDecryptFailed)
+		require.NoError(t, m.Close())
+	}
+}
+
+func TestEncryptRecord(t *testing.T) {
+	aead, err := newAEAD(testKeys().Keys[1])
+	require.NoError(t, err)
+	record, err := NewNormalLogRecord([]byte("k1"), []byte("value"))
+	require.NoError(t, err)
+	sealed, err := encryptRecord(record, aead, false)
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), record.Value())
+	require.Len(t, sealed.value, len("value")+aead.NonceSize()+aead.Overhead())
+
+	// 同样的value每次加密的结果都不同
+	again, err := encryptRecord(record, aead, false)
+	require.NoError(t, err)
+	require.NotEqual(t, sealed.value, again.value)
+
+	got, err := decodeRecord(sealed.encode(fixedCodec{}), fixedCodec{})
+	require.NoError(t, err)
+	require.NoError(t, decryptRecord(got, aead, false))
+	require.Equal(t, []byte("value"), got.Value())
+
+	// value不能被挪到别的key下
+	got, err = decodeRecord(sealed.encode(fixedCodec{}), fixedCodec{})
+	require.NoError(t, err)
+	got.key = []byte("k2")
+	require.ErrorIs(t, decryptRecord(got, aead, false), ErrDecryptFailed)
+}
@@ -0,0 +1,278 @@
+package disk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrInjectedFault FaultStorage注入的错误
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultStorage 包装另一个Storage，可以注入写入失败、Sync失败和掉电，用于测试崩溃恢复
+// 掉电时每个文件只保留最后一次Sync之前的内容，没有Sync过的写入全部丢失
+// 新建和重命名文件之后没有SyncDir的，掉电后新建的文件消失，重命名的文件回到原来的路径
+type FaultStorage struct {
+	Storage
+	mu sync.Mutex
+	// writeBudget 还允许写入的字节数，小于0表示不限制
+	writeBudget int64
+	failSync    bool
+	crashed     bool
+	// syncs 成功Sync文件的次数
+	syncs int64
+	// files 通过FaultStorage打开过的文件的状态，key是文件路径
+	files map[string]*faultFileState
+}
+
+type faultFileState struct {
+	suffix uint64
+	// synced 掉电后能保留下来的长度，小于0表示不知道，掉电时不截断
+	synced int64
+	// durable 目录项是否已经同步，掉电后文件仍然在当前路径
+	durable bool
+	// renamedFrom 目录项没有同步时，掉电后文件回到的路径，为空表示文件会消失
+	renamedFrom string
+}
+
+func NewFaultStorage(s Storage) *FaultStorage {
+	return &FaultStorage{Storage: s, writeBudget: -1, files: make(map[string]*faultFileState)}
+}
+
+// FailWritesAfter 之后累计写入n字节后写入失败，跨过边界的那次写入只写入前一部分，n小于0时取消限制
+func (f *FaultStorage) FailWritesAfter(n int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeBudget = n
+}
+
+// FailSync 设置之后的Sync是否失败
+func (f *FaultStorage) FailSync(fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failSync = fail
+}
+
+// Syncs 返回到目前为止成功Sync文件的次数，不包括SyncDir
+func (f *FaultStorage) Syncs() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.syncs
+}
+
+// Crash 模拟掉电，所有文件截断到最后一次Sync时的长度，之后通过FaultStorage的所有操作都返回ErrInjectedFault
+// 崩溃之后直接使用被包装的Storage重新打开
+func (f *FaultStorage) Crash() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.crashed = true
+	for path, state := range f.files {
+		p, err := f.Storage.Open(path, state.suffix, true)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if state.synced >= 0 && p.Offset() > state.synced {
+			err = p.Truncate(state.synced)
+		}
+		if closeErr := p.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for path, state := range f.files {
+		if state.durable {
+			continue
+		}
+		var err error
+		if state.renamedFrom != "" {
+			err = f.Storage.Rename(path, state.renamedFrom)
+		} else {
+			err = f.Storage.Remove(path)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FaultStorage) Open(path string, suffix uint64, isActiveFile bool) (PersistentStorage, error) {
+	path = filepath.Clean(path)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed {
+		return nil, ErrInjectedFault
+	}
+	_, tracked := f.files[path]
+	existed := tracked || f.exists(path, suffix)
+	p, err := f.Storage.Open(path, suffix, isActiveFile)
+	if err != nil {
+		return nil, err
+	}
+	if !tracked {
+		// 打开之前已经存在的文件当作已经落盘
+		f.files[path] = &faultFileState{suffix: suffix, synced: p.Offset(), durable: existed}
+	}
+	return &faultPersistent{PersistentStorage: p, storage: f, path: path}, nil
+}
+
+// exists 判断path在被包装的Storage中是否存在
+func (f *FaultStorage) exists(path string, suffix uint64) bool {
+	p, err := f.Storage.Open(path, suffix, false)
+	if err != nil {
+		return false
+	}
+	_ = p.Close()
+	return true
+}
+
+func (f *FaultStorage) List(dir string) ([]string, error) {
+	if f.isCrashed() {
+		return nil, ErrInjectedFault
+	}
+	return f.Storage.List(dir)
+}
+
+func (f *FaultStorage) Rename(oldPath, newPath string) error {
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed {
+		return ErrInjectedFault
+	}
+	if err := f.Storage.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	state, ok := f.files[oldPath]
+	if !ok {
+		state = &faultFileState{synced: -1, durable: true}
+	}
+	delete(f.files, oldPath)
+	if state.durable {
+		state.durable, state.renamedFrom = false, oldPath
+	}
+	f.files[newPath] = state
+	return nil
+}
+
+func (f *FaultStorage) Remove(path string) error {
+	path = filepath.Clean(path)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed {
+		return ErrInjectedFault
+	}
+	if err := f.Storage.Remove(path); err != nil {
+		return err
+	}
+	delete(f.files, path)
+	return nil
+}
+
+func (f *FaultStorage) SyncDir(dir string) error {
+	dir = filepath.Clean(dir)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed || f.failSync {
+		return ErrInjectedFault
+	}
+	if err := f.Storage.SyncDir(dir); err != nil {
+		return err
+	}
+	for path, state := range f.files {
+		if filepath.Dir(path) == dir {
+			state.durable, state.renamedFrom = true, ""
+		}
+	}
+	return nil
+}
+
+func (f *FaultStorage) isCrashed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.crashed
+}
+
+// faultPersistent FaultStorage打开的文件，所有写入和同步都经过FaultStorage检查
+type faultPersistent struct {
+	PersistentStorage
+	storage *FaultStorage
+	path    string
+}
+
+func (p *faultPersistent) ReadFromDisk(bs []byte, offset uint64) (int, error) {
+	if p.storage.isCrashed() {
+		return 0, ErrInjectedFault
+	}
+	return p.PersistentStorage.ReadFromDisk(bs, offset)
+}
+
+func (p *faultPersistent) WriteToDisk(bs []byte) (int64, int, error) {
+	f := p.storage
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed {
+		return 0, 0, ErrInjectedFault
+	}
+	if f.writeBudget < 0 || int64(len(bs)) <= f.writeBudget {
+		if f.writeBudget >= 0 {
+			f.writeBudget -= int64(len(bs))
+		}
+		return p.PersistentStorage.WriteToDisk(bs)
+	}
+	// 只写入一部分，模拟写到一半失败
+	offset, wn, err := p.PersistentStorage.WriteToDisk(bs[:f.writeBudget])
+	f.writeBudget -= int64(wn)
+	if err == nil {
+		err = ErrInjectedFault
+	}
+	return offset, wn, err
+}
+
+func (p *faultPersistent) Sync() error {
+	f := p.storage
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed || f.failSync {
+		return ErrInjectedFault
+	}
+	if err := p.PersistentStorage.Sync(); err != nil {
+		return err
+	}
+	f.syncs++
+	if state, ok := f.files[p.path]; ok {
+		state.synced = p.PersistentStorage.Offset()
+	}
+	return nil
+}
+
+func (p *faultPersistent) Truncate(size int64) error {
+	f := p.storage
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed {
+		return ErrInjectedFault
+	}
+	if err := p.PersistentStorage.Truncate(size); err != nil {
+		return err
+	}
+	if state, ok := f.files[p.path]; ok && state.synced > size {
+		state.synced = size
+	}
+	return nil
+}
+
+// Close 崩溃之后仍然关闭底层的文件，避免泄露
+func (p *faultPersistent) Close() error {
+	return p.PersistentStorage.Close()
+}
+
+func (p *faultPersistent) Delete() error {
+	return p.storage.Remove(p.path)
+}
@@ -0,0 +1,215 @@
+package disk
+
+/*
+数据文件的文件头，位于文件的最开始，之后才是LogRecord
+文件头的格式为 magic | version | flags | createdAt | fileID | keyID | crc，共fileHeaderSz字节，crc覆盖crc之前的所有字段
+最初的数据文件没有文件头，LogRecord从offset 0开始，这种文件的版本记为FormatV0
+*/
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// FormatV0 没有文件头的数据文件
+	FormatV0 uint16 = 0
+	// FormatV1 带有文件头的数据文件，LogRecord的格式和FormatV0一致
+	FormatV1 uint16 = 1
+	// FormatV2 带有文件头的数据文件，LogRecord头部的时间戳和长度使用uvarint编码
+	FormatV2 uint16 = 2
+	// CurrentFormatVersion 当前程序支持的最新格式版本
+	CurrentFormatVersion = FormatV2
+	// DefaultFormatVersion 没有指定时新建数据文件使用的格式版本
+	DefaultFormatVersion = FormatV1
+
+	magicSz      = 4
+	versionSz    = 2
+	flagsSz      = 2
+	createdAtSz  = 8
+	keyIDSz      = 4
+	fileHeaderSz = magicSz + versionSz + flagsSz + createdAtSz + fileIDSz + keyIDSz + crcSz
+
+	// migrateBufferSize 迁移数据文件时每次复制的大小
+	migrateBufferSize = 1 << 20
+)
+
+// fileMagic 数据文件的magic number，FormatV0文件开头是LogRecord的crc，和它撞上的概率可以忽略
+var fileMagic = []byte("BCDB")
+
+var (
+	// ErrInvalidFileHeader 文件头损坏或者和数据文件不匹配
+	ErrInvalidFileHeader = errors.New("invalid data file header")
+	// ErrUnsupportedVersion 数据文件的格式版本比当前程序支持的更新
+	ErrUnsupportedVersion = errors.New("unsupported data file format version")
+	// errTornFileHeader 文件为空或者只写入了一部分文件头
+	errTornFileHeader = errors.New("torn data file header")
+)
+
+// FileHeader 数据文件的文件头
+type FileHeader struct {
+	Version   uint16
+	Flags     uint16 // FlagEncrypted等标记位
+	CreatedAt int64  // unix纳秒
+	FileID    uint64
+	KeyID     uint32 // 设置了FlagEncrypted时，加密使用的密钥ID
+}
+
+// DataOffset 返回第一条LogRecord在文件中的位置
+func (h FileHeader) DataOffset() uint64 {
+	if h.Version == FormatV0 {
+		return 0
+	}
+	return fileHeaderSz
+}
+
+// ValidFormatVersion 判断version是否可以用来新建数据文件
+func ValidFormatVersion(version uint16) bool {
+	return version != FormatV0 && version <= CurrentFormatVersion
+}
+
+func newFileHeader(fileID uint64, version uint16) FileHeader {
+	return FileHeader{
+		Version:   version,
+		CreatedAt: time.Now().UnixNano(),
+		FileID:    fileID,
+	}
+}
+
+func (h FileHeader) encode() []byte {
+	bs := make([]byte, fileHeaderSz)
+	pos := copy(bs, fileMagic)
+	defaultEndianness.PutUint16(bs[pos:], h.Version)
+	pos += versionSz
+	defaultEndianness.PutUint16(bs[pos:], h.Flags)
+	pos += flagsSz
+	defaultEndianness.PutUint64(bs[pos:], uint64(h.CreatedAt))
+	pos += createdAtSz
+	defaultEndianness.PutUint64(bs[pos:], h.FileID)
+	pos += fileIDSz
+	defaultEndianness.PutUint32(bs[pos:], h.KeyID)
+	pos += keyIDSz
+	defaultEndianness.PutUint32(bs[pos:], crc32.ChecksumIEEE(bs[:pos]))
+	return bs
+}
+
+// readFileHeader 读取ID为fileID、大小为size的数据文件的文件头
+// 文件开头不是magic number时认为是FormatV0的文件，文件为空或者文件头不完整时返回errTornFileHeader
+func readFileHeader(r io.ReaderAt, size int64, fileID uint64) (FileHeader, error) {
+	bs := make([]byte, fileHeaderSz)
+	if size < fileHeaderSz {
+		bs = bs[:size]
+	}
+	if len(bs) > 0 {
+		if _, err := r.ReadAt(bs, 0); err != nil {
+			return FileHeader{}, err
+		}
+	}
+	if len(bs) < magicSz {
+		if bytes.HasPrefix(fileMagic, bs) {
+			return FileHeader{}, errTornFileHeader
+		}
+		return FileHeader{Version: FormatV0}, nil
+	}
+	if !bytes.Equal(bs[:magicSz], fileMagic) {
+		return FileHeader{Version: FormatV0}, nil
+	}
+	if len(bs) < fileHeaderSz {
+		return FileHeader{}, errTornFileHeader
+	}
+
+	crcPos := fileHeaderSz - crcSz
+	if defaultEndianness.Uint32(bs[crcPos:]) != crc32.ChecksumIEEE(bs[:crcPos]) {
+		return FileHeader{}, fmt.Errorf("%w: %w", ErrInvalidFileHeader, ErrCrcCheckFailed)
+	}
+	pos := magicSz
+	h := FileHeader{}
+	h.Version = defaultEndianness.Uint16(bs[pos:])
+	pos += versionSz
+	h.Flags = defaultEndianness.Uint16(bs[pos:])
+	pos += flagsSz
+	h.CreatedAt = int64(defaultEndianness.Uint64(bs[pos:]))
+	pos += createdAtSz
+	h.FileID = defaultEndianness.Uint64(bs[pos:])
+	pos += fileIDSz
+	h.KeyID = defaultEndianness.Uint32(bs[pos:])
+	if h.Version == FormatV0 || h.Version > CurrentFormatVersion {
+		return FileHeader{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.Version)
+	}
+	if h.FileID != fileID {
+		return FileHeader{}, fmt.Errorf("%w: file id %d in header, want %d", ErrInvalidFileHeader, h.FileID, fileID)
+	}
+	return h, nil
+}
+
+// MigrateDataFile 将s中dir下ID为fileID的FormatV0数据文件改写成FormatV1，文件已经带有文件头时什么都不做
+// 改写后LogRecord的位置都变了，对应的hint文件会被删除
+// 调用方需要保证迁移期间没有其他人打开这个文件
+func MigrateDataFile(s Storage, dir string, fileID uint64) (migrated bool, err error) {
+	name := DataFileName(dir, fileID)
+	src, err := s.Open(name, fileID, false)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+	size := src.Offset()
+	header, err := readFileHeader(readerAt{src}, size, fileID)
+	if errors.Is(err, errTornFileHeader) {
+		// 新建文件时写文件头失败了，打开活跃文件时会重新写入文件头
+		return false, nil
+	}
+	if err != nil || header.Version != FormatV0 {
+		return false, err
+	}
+
+	// 先写到临时文件再替换，迁移过程中崩溃不会丢数据
+	tmp := name + ".migrate"
+	if err = s.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	f, err := s.Open(tmp, fileID, true)
+	if err != nil {
+		return false, err
+	}
+	// FormatV1和FormatV0的LogRecord格式一致，只需要加上文件头，文件内容分块复制，不会整个读到内存中
+	if _, _, err = f.WriteToDisk(newFileHeader(fileID, FormatV1).encode()); err == nil {
+		_, err = io.CopyBuffer(writer{f}, io.NewSectionReader(readerAt{src}, 0, size), make([]byte, migrateBufferSize))
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// 旧的hint文件要先删除并落盘，不能在替换之后还指向旧的位置
+		if err = s.Remove(HintFileName(dir, fileID)); err == nil {
+			err = s.SyncDir(dir)
+		} else if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err == nil {
+		err = s.Rename(tmp, name)
+	}
+	if err != nil {
+		_ = s.Remove(tmp)
+		return false, err
+	}
+	return true, s.SyncDir(dir)
+}
+
+// writer 将PersistentStorage适配成io.Writer
+type writer struct {
+	persistent PersistentStorage
+}
+
+func (w writer) Write(bs []byte) (int, error) {
+	_, n, err := w.persistent.WriteToDisk(bs)
+	return n, err
+}
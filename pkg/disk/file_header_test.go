@@ -0,0 +1,155 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileHeader(t *testing.T) {
+	h := newFileHeader(7, DefaultFormatVersion)
+	bs := h.encode()
+	require.Len(t, bs, fileHeaderSz)
+	got, err := readFileHeader(bytes.NewReader(bs), int64(len(bs)), 7)
+	require.NoError(t, err)
+	require.Equal(t, h, got)
+	require.Equal(t, uint64(fileHeaderSz), got.DataOffset())
+
+	// 文件ID对不上
+	_, err = readFileHeader(bytes.NewReader(bs), int64(len(bs)), 8)
+	require.ErrorIs(t, err, ErrInvalidFileHeader)
+
+	// 不认识的版本
+	h.Version = CurrentFormatVersion + 1
+	bs = h.encode()
+	_, err = readFileHeader(bytes.NewReader(bs), int64(len(bs)), 7)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	// 文件头损坏
+	bs[len(bs)-1] ^= 0xff
+	_, err = readFileHeader(bytes.NewReader(bs), int64(len(bs)), 7)
+	require.ErrorIs(t, err, ErrInvalidFileHeader)
+
+	// 文件头不完整
+	for cut := 0; cut < fileHeaderSz; cut++ {
+		_, err = readFileHeader(bytes.NewReader(bs[:cut]), int64(cut), 7)
+		require.ErrorIs(t, err, errTornFileHeader, "cut at %d", cut)
+	}
+
+	// 没有文件头的旧文件
+	record, err := NewNormalLogRecord([]byte("k"), []byte("v"))
+	require.NoError(t, err)
+	bs, err = record.Serialize()
+	require.NoError(t, err)
+	got, err = readFileHeader(bytes.NewReader(bs), int64(len(bs)), 7)
+	require.NoError(t, err)
+	require.Equal(t, FormatV0, got.Version)
+	require.Zero(t, got.DataOffset())
+}
+
+func TestDataFileImpl_UnsupportedVersion(t *testing.T) {
+	dir := t.TempDir()
+	h := newFileHeader(1, DefaultFormatVersion)
+	h.Version = CurrentFormatVersion + 1
+	require.NoError(t, os.WriteFile(DataFileName(dir, 1), h.encode(), 0600))
+	_, err := NewManager(dir, 1, false, 0)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+	_, err = NewManager(dir, 1, true, 4<<20)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestDataFileImpl_TornHeader(t *testing.T) {
+	dir := t.TempDir()
+	bs := newFileHeader(1, DefaultFormatVersion).encode()
+	require.NoError(t, os.WriteFile(DataFileName(dir, 1), bs[:fileHeaderSz/2], 0600))
+	// 活跃文件重新写入文件头后可以继续写入
+	m, err := NewManager(dir, 1, true, 4<<20)
+	require.NoError(t, err)
+	require.Equal(t, DefaultFormatVersion, m.Header().Version)
+	require.Equal(t, int64(fileHeaderSz), m.Size())
+	vm, err := m.Write([]byte("k"), []byte("v"), false)
+	require.NoError(t, err)
+	require.Equal(t, uint64(fileHeaderSz), vm.ValuePos)
+	require.NoError(t, m.Close())
+}
+
+func TestMigrateDataFile(t *testing.T) {
+	dir := t.TempDir()
+	// 文件比一次复制的大小更大
+	value := func(k string) []byte {
+		return bytes.Repeat([]byte("value-"+k), migrateBufferSize/8)
+	}
+	var content []byte
+	for _, k := range []string{"k1", "k2"} {
+		record, err := NewNormalLogRecord([]byte(k), value(k))
+		require.NoError(t, err)
+		bs, err := record.Serialize()
+		require.NoError(t, err)
+		content = append(content, bs...)
+	}
+	require.NoError(t, os.WriteFile(DataFileName(dir, 1), content, 0600))
+	require.NoError(t, os.WriteFile(HintFileName(dir, 1), []byte("stale"), 0600))
+
+	// 旧格式的文件仍然可以读取
+	m, err := NewManager(dir, 1, false, 0)
+	require.NoError(t, err)
+	require.Equal(t, FormatV0, m.Header().Version)
+	record, _, err := m.ReadLogRecord(m.Header().DataOffset())
+	require.NoError(t, err)
+	require.Equal(t, []byte("k1"), record.Key())
+	require.NoError(t, m.Close())
+
+	migrated, err := MigrateDataFile(FileStorage{}, dir, 1)
+	require.NoError(t, err)
+	require.True(t, migrated)
+	_, err = os.Stat(HintFileName(dir, 1))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	migrated, err = MigrateDataFile(FileStorage{}, dir, 1)
+	require.NoError(t, err)
+	require.False(t, migrated)
+
+	m, err = NewManager(dir, 1, false, 0)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, m.Close())
+	}()
+	require.Equal(t, FormatV1, m.Header().Version)
+	require.Equal(t, int64(fileHeaderSz+len(content)), m.Size())
+	offset := m.Header().DataOffset()
+	for _, k := range []string{"k1", "k2"} {
+		record, size, err := m.ReadLogRecord(offset)
+		require.NoError(t, err)
+		require.Equal(t, []byte(k), record.Key())
+		require.Equal(t, value(k), record.Value())
+		offset += size
+	}
+}
+
+func TestMigrateDataFile_Storage(t *testing.T) {
+	s := NewMemStorage()
+	dir := "/db"
+	record, err := NewNormalLogRecord([]byte("key"), []byte("value"))
+	require.NoError(t, err)
+	bs, err := record.Serialize()
+	require.NoError(t, err)
+	for name, content := range map[string][]byte{DataFileName(dir, 1): bs, HintFileName(dir, 1): []byte("stale")} {
+		p, err := s.Open(name, 1, true)
+		require.NoError(t, err)
+		_, _, err = p.WriteToDisk(content)
+		require.NoError(t, err)
+		require.NoError(t, p.Close())
+	}
+
+	// 所有文件操作都通过s完成，hint文件也从s中删除
+	migrated, err := MigrateDataFile(s, dir, 1)
+	require.NoError(t, err)
+	require.True(t, migrated)
+	_, err = s.Open(HintFileName(dir, 1), 1, false)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	p, err := s.Open(DataFileName(dir, 1), 1, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(fileHeaderSz+len(bs)), p.Offset())
+	require.NoError(t, p.Close())
+}
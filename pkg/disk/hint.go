@@ -0,0 +1,135 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"bitcask-go/pkg/index"
+)
+
+/*
+hint文件和merge生成的数据文件一一对应，记录数据文件中每个key在索引中的位置，启动时读hint文件就不用重放整个数据文件
+每条记录的格式为 crc | tmStamp | expireAt | ksz | valueSz | valuePos | fileID | key，crc覆盖crc之后的所有字段
+*/
+
+const (
+	hintFileExt = ".hint"
+
+	valuePosSz   = 8 // uint64
+	fileIDSz     = 8 // uint64
+	hintHeaderSz = crcSz + tmStampSz + expireSz + kszSz + vszSz + valuePosSz + fileIDSz
+)
+
+// ErrInvalidHint hint文件损坏或者和数据文件对不上
+var ErrInvalidHint = errors.New("invalid hint file")
+
+// HintEntry hint文件中的一条记录
+type HintEntry struct {
+	Key   []byte
+	VMeta *index.ValueMetadata
+}
+
+// HintFileName 返回dir下文件ID为suffix的数据文件对应的hint文件路径
+func HintFileName(dir string, suffix uint64) string {
+	return fmt.Sprintf("%s/%06d%s", dir, suffix, hintFileExt)
+}
+
+// HintWriter 追加写hint文件
+type HintWriter struct {
+	persistent PersistentStorage
+}
+
+func NewHintWriter(dir string, suffix uint64) (*HintWriter, error) {
+	persistent, err := NewFilePersistentImpl(HintFileName(dir, suffix), suffix, true)
+	if err != nil {
+		return nil, err
+	}
+	return &HintWriter{persistent: persistent}, nil
+}
+
+// Write 写入key在索引中的位置
+func (h *HintWriter) Write(key []byte, v *index.ValueMetadata) error {
+	bs := make([]byte, hintHeaderSz+len(key))
+	pos := crcSz
+	defaultEndianness.PutUint64(bs[pos:], uint64(v.TsTamp))
+	pos += tmStampSz
+	defaultEndianness.PutUint64(bs[pos:], uint64(v.ExpireAt))
+	pos += expireSz
+	defaultEndianness.PutUint64(bs[pos:], uint64(len(key)))
+	pos += kszSz
+	defaultEndianness.PutUint64(bs[pos:], v.ValueSz)
+	pos += vszSz
+	defaultEndianness.PutUint64(bs[pos:], v.ValuePos)
+	pos += valuePosSz
+	defaultEndianness.PutUint64(bs[pos:], v.FileID)
+	copy(bs[hintHeaderSz:], key)
+	defaultEndianness.PutUint32(bs[:crcSz], crc32.ChecksumIEEE(bs[crcSz:]))
+	_, _, err := h.persistent.WriteToDisk(bs)
+	return err
+}
+
+func (h *HintWriter) Sync() error {
+	return h.persistent.Sync()
+}
+
+func (h *HintWriter) Close() error {
+	return h.persistent.Close()
+}
+
+// ReadHintFile 读取数据文件dataFile对应的hint文件
+// hint文件不存在时返回os.ErrNotExist，hint文件损坏或者记录的位置超出了数据文件时返回ErrInvalidHint
+func ReadHintFile(dir string, dataFile DataFile) ([]HintEntry, error) {
+	bs, err := os.ReadFile(HintFileName(dir, dataFile.ID()))
+	if err != nil {
+		return nil, err
+	}
+	var (
+		entries  []HintEntry
+		offset   uint64
+		dataSize = uint64(dataFile.Size())
+	)
+	for offset < uint64(len(bs)) {
+		entry, size, err := decodeHint(bs[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s at offset %d: %v", ErrInvalidHint, HintFileName(dir, dataFile.ID()), offset, err)
+		}
+		v := entry.VMeta
+		if v.FileID != dataFile.ID() || v.ValuePos < dataFile.Header().DataOffset() || v.ValuePos+v.ValueSz > dataSize || v.ValuePos+v.ValueSz < v.ValuePos {
+			return nil, fmt.Errorf("%w: %s at offset %d: position out of data file", ErrInvalidHint, HintFileName(dir, dataFile.ID()), offset)
+		}
+		entries = append(entries, entry)
+		offset += size
+	}
+	return entries, nil
+}
+
+func decodeHint(b []byte) (HintEntry, uint64, error) {
+	if len(b) < hintHeaderSz {
+		return HintEntry{}, 0, io.ErrUnexpectedEOF
+	}
+	pos := crcSz
+	tmStamp := defaultEndianness.Uint64(b[pos:])
+	pos += tmStampSz
+	expireAt := defaultEndianness.Uint64(b[pos:])
+	pos += expireSz
+	ksz := defaultEndianness.Uint64(b[pos:])
+	pos += kszSz
+	valueSz := defaultEndianness.Uint64(b[pos:])
+	pos += vszSz
+	valuePos := defaultEndianness.Uint64(b[pos:])
+	pos += valuePosSz
+	fileID := defaultEndianness.Uint64(b[pos:])
+	if ksz > uint64(len(b)-hintHeaderSz) {
+		return HintEntry{}, 0, io.ErrUnexpectedEOF
+	}
+	size := hintHeaderSz + ksz
+	if defaultEndianness.Uint32(b[:crcSz]) != crc32.ChecksumIEEE(b[crcSz:size]) {
+		return HintEntry{}, 0, ErrCrcCheckFailed
+	}
+	v := index.NewValueMetadata(fileID, valueSz, valuePos, int64(tmStamp))
+	v.ExpireAt = int64(expireAt)
+	return HintEntry{Key: b[hintHeaderSz:size], VMeta: v}, size, nil
+}
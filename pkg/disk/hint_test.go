@@ -0,0 +1,63 @@
+package disk
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitcask-go/pkg/index"
+)
+
+func TestHintFile(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir, 1, true, 4<<20)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, m.Close())
+	}()
+	hw, err := NewHintWriter(dir, 1)
+	require.NoError(t, err)
+	want := make([]HintEntry, 0, 3)
+	for _, k := range []string{"k1", "k2", ""} {
+		vm, err := m.Write([]byte(k), []byte("value"), false)
+		require.NoError(t, err)
+		if k == "k2" {
+			// 过期时间也要保存在hint文件中
+			vm.ExpireAt = 12345
+		}
+		require.NoError(t, hw.Write([]byte(k), vm))
+		want = append(want, HintEntry{Key: []byte(k), VMeta: vm})
+	}
+	require.NoError(t, hw.Sync())
+	require.NoError(t, hw.Close())
+
+	got, err := ReadHintFile(dir, m)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	// hint文件不存在
+	other, err := NewManager(dir, 2, true, 4<<20)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, other.Close())
+	}()
+	_, err = ReadHintFile(dir, other)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// hint文件被截断
+	bs, err := os.ReadFile(HintFileName(dir, 1))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(HintFileName(dir, 1), bs[:len(bs)-1], 0600))
+	_, err = ReadHintFile(dir, m)
+	require.ErrorIs(t, err, ErrInvalidHint)
+
+	// hint中记录的位置超出了数据文件
+	require.NoError(t, os.Remove(HintFileName(dir, 1)))
+	hw, err = NewHintWriter(dir, 1)
+	require.NoError(t, err)
+	require.NoError(t, hw.Write([]byte("k1"), index.NewValueMetadata(1, 10, uint64(m.Size()), 0)))
+	require.NoError(t, hw.Close())
+	_, err = ReadHintFile(dir, m)
+	require.ErrorIs(t, err, ErrInvalidHint)
+}
@@ -0,0 +1,77 @@
+package disk
+
+import (
+	"fmt"
+	"hash/crc32"
+)
+
+func (t LogRecordType) String() string {
+	switch t {
+	case NormalRecord:
+		return "normal"
+	case DeleteRecord:
+		return "delete"
+	case BatchCommitRecord:
+		return "batch-commit"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+// RecordInfo 数据文件中一条LogRecord的原始信息，用于检查和导出数据文件
+type RecordInfo struct {
+	Offset uint64
+	Size   uint64
+	Type   LogRecordType
+	// TmStamp 写入时的时间戳，unix秒
+	TmStamp uint64
+	// ExpireAt 过期时间，unix纳秒，0表示永不过期
+	ExpireAt   uint64
+	Seq        uint64
+	Compressed bool
+	// Key LogRecord的key，加密的key只有解密成功时才有，crc校验失败时可能是错的
+	Key []byte
+	// ValueSz 磁盘上value的长度，是压缩和加密之后的长度
+	ValueSz uint64
+	// CrcOK crc校验是否通过，没有通过时其余字段可能都是错的
+	CrcOK bool
+	// Err crc校验通过但是解密或者解压失败的原因
+	Err error
+}
+
+// InspectLogRecord 解析offset处的LogRecord，和ReadLogRecord不同的是crc校验失败时仍然返回解析出的信息
+// 读到文件末尾返回io.EOF，头部不完整或者类型无法识别时无法确定LogRecord的长度，返回对应的错误
+func (m *DataFileImpl) InspectLogRecord(offset uint64) (*RecordInfo, error) {
+	bs, err := m.readRaw(offset)
+	if err != nil {
+		return nil, err
+	}
+	raw := new(LogRecord)
+	sz, err := m.codec.decodeHeader(bs, raw)
+	if err != nil {
+		return nil, err
+	}
+	info := &RecordInfo{
+		Offset:     offset,
+		Size:       uint64(len(bs)),
+		Type:       raw.typ,
+		TmStamp:    raw.tmStamp,
+		ExpireAt:   raw.expireAt,
+		Seq:        raw.seq,
+		Compressed: raw.compressed,
+		ValueSz:    raw.valueSz,
+		CrcOK:      raw.crc == crc32.ChecksumIEEE(bs[crcSz:]),
+	}
+	if info.CrcOK {
+		record, err := m.decodeRecord(bs)
+		if err == nil {
+			info.Key = record.key
+			return info, nil
+		}
+		info.Err = err
+	}
+	if m.header.Flags&FlagEncryptedKeys == 0 {
+		info.Key = bs[sz : uint64(sz)+raw.ksz]
+	}
+	return info, nil
+}
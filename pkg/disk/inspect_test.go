@@ -0,0 +1,65 @@
+package disk
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataFileImpl_InspectLogRecord(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir, 1, true, 4<<20, WithFormatVersion(FormatV2))
+	require.NoError(t, err)
+	vm1, err := m.Write([]byte("k1"), []byte("v1"), false)
+	require.NoError(t, err)
+	vm2, err := m.Write([]byte("k2"), []byte("v2"), false)
+	require.NoError(t, err)
+	_, err = m.Del([]byte("k1"), false)
+	require.NoError(t, err)
+	require.NoError(t, m.Close())
+
+	// 篡改第二条LogRecord的value
+	content, err := os.ReadFile(DataFileName(dir, 1))
+	require.NoError(t, err)
+	content[vm2.ValuePos+vm2.ValueSz-1] ^= 0xff
+	require.NoError(t, os.WriteFile(DataFileName(dir, 1), content, 0600))
+
+	m, err = NewManager(dir, 1, false, 0)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, m.Close())
+	}()
+	info, err := m.InspectLogRecord(vm1.ValuePos)
+	require.NoError(t, err)
+	require.True(t, info.CrcOK)
+	require.Equal(t, NormalRecord, info.Type)
+	require.Equal(t, []byte("k1"), info.Key)
+	require.Equal(t, uint64(2), info.ValueSz)
+	require.Equal(t, vm1.ValueSz, info.Size)
+
+	// crc校验失败时仍然可以继续解析后面的LogRecord
+	info, err = m.InspectLogRecord(vm2.ValuePos)
+	require.NoError(t, err)
+	require.False(t, info.CrcOK)
+	_, _, err = m.ReadLogRecord(vm2.ValuePos)
+	require.ErrorIs(t, err, ErrCrcCheckFailed)
+
+	info, err = m.InspectLogRecord(vm2.ValuePos + info.Size)
+	require.NoError(t, err)
+	require.True(t, info.CrcOK)
+	require.Equal(t, DeleteRecord, info.Type)
+	require.Equal(t, "delete", info.Type.String())
+	require.Equal(t, []byte("k1"), info.Key)
+}
+
+func TestParseDataFileName(t *testing.T) {
+	dir, fid, ok := ParseDataFileName(DataFileName("/data/db", 42))
+	require.True(t, ok)
+	require.Equal(t, "/data/db", dir)
+	require.Equal(t, uint64(42), fid)
+	for _, name := range []string{"/data/db/lock", "/data/db/abc.db", "/data/db/000001.hint"} {
+		_, _, ok = ParseDataFileName(name)
+		require.False(t, ok, name)
+	}
+}
@@ -33,6 +33,8 @@ type PersistentStorage interface {
 	Delete() error
 	// Offset 返回当前文件的的写入位置
 	Offset() int64
+	// Truncate 将文件截断到size，之后从size处继续追加写
+	Truncate(size int64) error
 }
 
 // DataFile 磁盘文件的表示, Write和Del操作都是追加写，不会覆盖，只作用于当前活跃文件(active data file). Read则是什么类型的DataFile都支持
@@ -41,10 +43,26 @@ type DataFile interface {
 	Write(key, value []byte, force bool) (v *index.ValueMetadata, err error)
 	// Read 从磁盘读取key对应的value
 	Read(mv *index.ValueMetadata) (value []byte, err error)
+	// WriteLogRecord 将已有的LogRecord原样追加写入磁盘，merge时使用
+	WriteLogRecord(record *LogRecord, force bool) (v *index.ValueMetadata, err error)
+	// WriteLogRecords 将多条LogRecord作为一次追加写连续写入磁盘，批量写入时使用
+	WriteLogRecords(records []*LogRecord, force bool) (vs []*index.ValueMetadata, err error)
 	// Del 删除key对应的value
 	Del(key []byte, force bool) (v *index.ValueMetadata, err error)
+	// ReadLogRecord 读取offset处的完整LogRecord，返回LogRecord和它占用的字节数，读到文件末尾返回io.EOF
+	ReadLogRecord(offset uint64) (record *LogRecord, size uint64, err error)
+	// InspectLogRecord 解析offset处的LogRecord，crc校验失败时也返回解析出的信息，用于检查数据文件
+	InspectLogRecord(offset uint64) (*RecordInfo, error)
+	// Header 返回文件头，第一条LogRecord位于Header().DataOffset()
+	Header() FileHeader
+	// Size 返回文件当前的大小
+	Size() int64
+	// Truncate 将文件截断到size，用于丢弃末尾不完整的LogRecord
+	Truncate(size int64) error
 	// ID 返回文件ID，对应index中的file_id
 	ID() uint64 // 文件ID，对应index中的file_id
+	// Sync 同步数据到磁盘
+	Sync() error
 	// Close 关闭文件
 	Close() error
 	// Delete 删除文件
@@ -1,16 +1,21 @@
 package disk
 
 /*
+LogRecord在磁盘上的格式为 crc | type | tmStamp | [expireAt] | [seq] | ksz | [valueSz] | key | [value]
 如果是LogRecord类型是NormalRecord，那么磁盘存储会包含LogRecord的所有字段
-如果是LogRecord类型是DeleteRecord，那么磁盘存储只包含LogRecord的crc、typ、tmStamp、ksz、key字段
+如果是LogRecord类型是DeleteRecord或者BatchCommitRecord，那么磁盘存储不包含valueSz、value字段
+type的高位是标记位，没有设置标记位的LogRecord和最初的格式完全一致
+  - batchFlag 表示LogRecord属于一个批量写入，会多出seq字段
+  - expiryFlag 表示LogRecord带有过期时间，会多出expireAt字段
+  - compressFlag 表示value是压缩过的，参见Compression
+crc和type之后的字段在FormatV2的数据文件中使用uvarint编码，其余版本中都是固定8字节，参见recordCodec
 */
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"hash/crc32"
-	"sync"
+	"io"
 	"time"
 )
 
@@ -21,23 +26,36 @@ const (
 	NormalRecord LogRecordType = iota
 	// DeleteRecord 表示删除记录
 	DeleteRecord
+	// BatchCommitRecord 批量写入的提交标记，只有读到了提交标记，同一个seq的LogRecord才会生效
+	BatchCommitRecord
+
+	// batchFlag type字节中表示带有seq字段的标记位
+	batchFlag = 0x80
+	// expiryFlag type字节中表示带有expireAt字段的标记位
+	expiryFlag = 0x40
+	// compressFlag type字节中表示value经过压缩的标记位
+	compressFlag = 0x20
+	// typeMask type字节中表示LogRecordType的部分
+	typeMask = 0x1f
 
 	crcSz     = 4 // uint32
 	tmStampSz = 8 // uint64
+	expireSz  = 8 // uint64
+	seqSz     = 8 // uint64
 	kszSz     = 8 // uint64
 	vszSz     = 8 // uint64
 	typeSz    = 1 // uint8
+
+	// maxFieldSz ksz和valueSz的上限，超过的一定是损坏的数据，同时保证计算长度时不会溢出
+	maxFieldSz = 1 << 62
 )
 
 var (
 	// 默认的字节序，小端序
 	defaultEndianness = binary.LittleEndian
 	ErrCrcCheckFailed = errors.New("crc check failed")
-	bfPool            = sync.Pool{
-		New: func() any {
-			return new(bytes.Buffer)
-		},
-	}
+	// ErrUnknownRecordType 磁盘上的LogRecord类型无法识别
+	ErrUnknownRecordType = errors.New("unknown log record type")
 )
 
 // LogRecord 表示bitcask磁盘文件中的一个条目
@@ -49,6 +67,12 @@ type LogRecord struct {
 	typ LogRecordType
 	// 时间戳
 	tmStamp uint64
+	// 过期时间，unix纳秒，0表示永不过期
+	expireAt uint64
+	// 批量写入的序号，0表示不属于任何批量写入
+	seq uint64
+	// value是否经过压缩，从磁盘读出时DataFileImpl会解压，内存中的LogRecord都是没有压缩的
+	compressed bool
 	// key的长度
 	ksz uint64
 	// value的长度
@@ -63,14 +87,46 @@ func (d *LogRecord) Op() LogRecordType {
 	return d.typ
 }
 
+// Key 返回LogRecord的key
+func (d *LogRecord) Key() []byte {
+	return d.key
+}
+
+// TmStamp 返回LogRecord写入时的时间戳
+func (d *LogRecord) TmStamp() uint64 {
+	return d.tmStamp
+}
+
+// ExpireAt 返回LogRecord的过期时间(unix纳秒)，0表示永不过期
+func (d *LogRecord) ExpireAt() uint64 {
+	return d.expireAt
+}
+
+// SetExpireAt 设置LogRecord的过期时间(unix纳秒)，0表示永不过期
+func (d *LogRecord) SetExpireAt(expireAt uint64) {
+	d.expireAt = expireAt
+}
+
+// IsExpired 判断LogRecord在now时是否已经过期
+func (d *LogRecord) IsExpired(now time.Time) bool {
+	return d.expireAt != 0 && d.expireAt <= uint64(now.UnixNano())
+}
+
+// Seq 返回LogRecord所属批量写入的序号，0表示不属于批量写入
+func (d *LogRecord) Seq() uint64 {
+	return d.seq
+}
+
+// SetSeq 设置LogRecord所属批量写入的序号，设置为0则变成普通的LogRecord
+func (d *LogRecord) SetSeq(seq uint64) {
+	d.seq = seq
+}
+
 func (d *LogRecord) Value() []byte {
 	switch d.typ {
 	case NormalRecord:
 		return d.value
-	case DeleteRecord:
-		return nil
 	default:
-		//dead code
 		return nil
 	}
 }
@@ -85,11 +141,7 @@ func NewNormalLogRecord(k, v []byte) (*LogRecord, error) {
 	res.ksz = uint64(len(k))
 	res.valueSz = uint64(len(v))
 	res.typ = NormalRecord
-	crcInput, err := res.crcData()
-	if err != nil {
-		return nil, err
-	}
-	res.calCrc(crcInput)
+	res.calCrc(res.crcData(fixedCodec{}))
 	return res, nil
 }
 
@@ -101,130 +153,118 @@ func NewDeleteLogRecord(k []byte) (*LogRecord, error) {
 	res.tmStamp = uint64(time.Now().Unix())
 	res.ksz = uint64(len(k))
 	res.typ = DeleteRecord
-	crcInput, err := res.crcData()
-	if err != nil {
-		return nil, err
-	}
-	res.calCrc(crcInput)
+	res.calCrc(res.crcData(fixedCodec{}))
 	return res, nil
 }
 
-// Size 返回LogRecord的大小
+// NewBatchCommitLogRecord 创建序号为seq的批量写入的提交标记
+func NewBatchCommitLogRecord(seq uint64) (*LogRecord, error) {
+	res := new(LogRecord)
+	res.key = []byte{}
+	res.tmStamp = uint64(time.Now().Unix())
+	res.seq = seq
+	res.typ = BatchCommitRecord
+	res.calCrc(res.crcData(fixedCodec{}))
+	return res, nil
+}
+
+// hasValue 只有NormalRecord在磁盘上存储valueSz和value
+func hasValue(typ LogRecordType) bool {
+	return typ == NormalRecord
+}
+
+// typeByte 磁盘上type字节的值
+func (d *LogRecord) typeByte() byte {
+	b := byte(d.typ)
+	if d.seq != 0 {
+		b |= batchFlag
+	}
+	if d.expireAt != 0 {
+		b |= expiryFlag
+	}
+	if d.compressed {
+		b |= compressFlag
+	}
+	return b
+}
+
+// Size 返回LogRecord按照固定长度格式编码后的大小
 func (d *LogRecord) Size() int64 {
-	switch d.typ {
-	case NormalRecord:
-		return int64(crcSz + typeSz + tmStampSz + kszSz + vszSz + len(d.key) + len(d.value))
-	case DeleteRecord:
-		return int64(crcSz + typeSz + tmStampSz + kszSz + len(d.key))
-	default:
-		// dead code
-		return 0
+	return d.encodedSize(fixedCodec{})
+}
+
+// encodedSize 返回LogRecord按照codec编码后的大小
+func (d *LogRecord) encodedSize(codec recordCodec) int64 {
+	sz := int64(codec.headerSize(d) + len(d.key))
+	if hasValue(d.typ) {
+		sz += int64(len(d.value))
 	}
+	return sz
 }
 
-// crcData 得到计算crc的输入数据，不同类型的LogRecord的crc的数据数据的所需字段不同，请参见最上的注释。
-func (d *LogRecord) crcData() ([]byte, error) {
-	var err error
-	bf := bfPool.Get().(*bytes.Buffer)
-	defer func() {
-		bf.Reset()
-		bfPool.Put(bf)
-	}()
-	switch d.typ {
-	case NormalRecord:
-		bf.Grow(typeSz + tmStampSz + kszSz + vszSz + len(d.key) + len(d.value))
-		// NOTE: binary.Write会使用反射有一定的开销，也是直接使用PutXXX需要的是[]byte而不是io.Writer
-		if err = binary.Write(bf, defaultEndianness, d.typ); err != nil {
-			return nil, err
-		}
-		if err = binary.Write(bf, defaultEndianness, d.tmStamp); err != nil {
-			return nil, err
-		}
-		if err = binary.Write(bf, defaultEndianness, d.ksz); err != nil {
-			return nil, err
-		}
-		if err = binary.Write(bf, defaultEndianness, d.valueSz); err != nil {
-			return nil, err
-		}
-		if _, err = bf.Write(d.key); err != nil {
-			return nil, err
-		}
-		if _, err = bf.Write(d.value); err != nil {
-			return nil, err
-		}
-	case DeleteRecord:
-		bf.Grow(typeSz + tmStampSz + kszSz + len(d.key))
-		if err = binary.Write(bf, defaultEndianness, d.typ); err != nil {
-			return nil, err
-		}
-		if err = binary.Write(bf, defaultEndianness, d.tmStamp); err != nil {
-			return nil, err
-		}
-		if err = binary.Write(bf, defaultEndianness, d.ksz); err != nil {
-			return nil, err
-		}
-		if _, err = bf.Write(d.key); err != nil {
-			return nil, err
-		}
+// recordSize 根据磁盘上LogRecord的头部计算整条LogRecord的长度
+// header不足以解析出长度时返回io.ErrUnexpectedEOF
+func recordSize(header []byte, codec recordCodec) (uint64, error) {
+	res := new(LogRecord)
+	sz, err := codec.decodeHeader(header, res)
+	if err != nil {
+		return 0, err
 	}
+	return uint64(sz) + res.ksz + res.valueSz, nil
+}
 
-	return bf.Bytes(), nil
+// crcData 得到计算crc的输入数据，即crc之后的所有字段，不同类型的LogRecord所需字段不同，请参见最上的注释。
+func (d *LogRecord) crcData(codec recordCodec) []byte {
+	bs := make([]byte, 0, d.encodedSize(codec)-crcSz)
+	bs = codec.appendHeader(bs, d)
+	bs = append(bs, d.key...)
+	if hasValue(d.typ) {
+		bs = append(bs, d.value...)
+	}
+	return bs
 }
 
 func (d *LogRecord) calCrc(crcInput []byte) {
 	d.crc = crc32.ChecksumIEEE(crcInput)
 }
 
+// Serialize 按照固定长度格式编码LogRecord
 func (d *LogRecord) Serialize() ([]byte, error) {
-	bf := bfPool.Get().(*bytes.Buffer)
-	defer func() {
-		bf.Reset()
-		bfPool.Put(bf)
-	}()
-	// 先计算crc
-	crcData, err := d.crcData()
-	if err != nil {
-		return nil, err
-	}
-	//
+	return d.encode(fixedCodec{}), nil
+}
+
+// encode 按照codec编码LogRecord
+func (d *LogRecord) encode(codec recordCodec) []byte {
+	crcData := d.crcData(codec)
 	d.calCrc(crcData)
-	bf.Grow(crcSz + len(crcData))
-	if err := binary.Write(bf, defaultEndianness, d.crc); err != nil {
-		return nil, err
-	}
-	if _, err := bf.Write(crcData); err != nil {
-		return nil, err
-	}
-	return bf.Bytes(), nil
+	bs := make([]byte, crcSz, crcSz+len(crcData))
+	defaultEndianness.PutUint32(bs, d.crc)
+	return append(bs, crcData...)
 }
 
+// Deserialize 按照固定长度格式解码LogRecord
+// b不足一条完整的LogRecord时返回io.ErrUnexpectedEOF，类型无法识别时返回ErrUnknownRecordType
 func (d *LogRecord) Deserialize(b []byte) (DataSerializer, error) {
-	res := new(LogRecord)
-	res.crc = defaultEndianness.Uint32(b[:crcSz])
-	res.typ = LogRecordType(b[crcSz : crcSz+typeSz][0])
-
-	switch res.typ {
-	case NormalRecord:
-		res.tmStamp = defaultEndianness.Uint64(b[crcSz+typeSz : crcSz+typeSz+tmStampSz])
-		res.ksz = defaultEndianness.Uint64(b[crcSz+typeSz+tmStampSz : crcSz+typeSz+tmStampSz+kszSz])
-		res.valueSz = defaultEndianness.Uint64(b[crcSz+typeSz+tmStampSz+kszSz : crcSz+typeSz+tmStampSz+kszSz+vszSz])
-		res.key = b[crcSz+typeSz+tmStampSz+kszSz+vszSz : crcSz+typeSz+tmStampSz+kszSz+vszSz+res.ksz]
-		res.value = b[crcSz+typeSz+tmStampSz+kszSz+vszSz+res.ksz : crcSz+typeSz+tmStampSz+kszSz+vszSz+res.ksz+res.valueSz]
-	case DeleteRecord:
-		res.tmStamp = defaultEndianness.Uint64(b[crcSz+typeSz : crcSz+typeSz+tmStampSz])
-		res.ksz = defaultEndianness.Uint64(b[crcSz+typeSz+tmStampSz : crcSz+typeSz+tmStampSz+kszSz])
-		res.key = b[crcSz+typeSz+tmStampSz+kszSz : crcSz+typeSz+tmStampSz+kszSz+res.ksz]
-	}
+	return decodeRecord(b, fixedCodec{})
+}
 
-	// 校验crc
-	crcData, err := res.crcData()
+// decodeRecord 按照codec解码LogRecord，返回的LogRecord引用b中的数据
+func decodeRecord(b []byte, codec recordCodec) (*LogRecord, error) {
+	res := new(LogRecord)
+	sz, err := codec.decodeHeader(b, res)
 	if err != nil {
 		return nil, err
 	}
-	beforeCrc := res.crc
-	res.calCrc(crcData)
-	afterCrc := res.crc
-	if beforeCrc != afterCrc {
+	if uint64(len(b)-sz) < res.ksz+res.valueSz {
+		return nil, io.ErrUnexpectedEOF
+	}
+	res.key = b[uint64(sz) : uint64(sz)+res.ksz]
+	if hasValue(res.typ) {
+		res.value = b[uint64(sz)+res.ksz : uint64(sz)+res.ksz+res.valueSz]
+	}
+
+	// 校验crc
+	if res.crc != crc32.ChecksumIEEE(b[crcSz:uint64(sz)+res.ksz+res.valueSz]) {
 		return nil, ErrCrcCheckFailed
 	}
 	return res, nil
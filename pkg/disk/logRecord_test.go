@@ -1,7 +1,9 @@
 package disk
 
 import (
+	"io"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -14,8 +16,7 @@ func Test_All(t *testing.T) {
 	)
 	normalLogRecord, err := NewNormalLogRecord(k, v)
 	require.NoError(t, err)
-	crcInput, err := normalLogRecord.crcData()
-	require.NoError(t, err)
+	crcInput := normalLogRecord.crcData(fixedCodec{})
 	require.NotNil(t, crcInput)
 	normalLogRecord.calCrc(crcInput)
 
@@ -34,8 +35,7 @@ func Test_All(t *testing.T) {
 	// delete
 	deleteLogRecord, err := NewDeleteLogRecord(k)
 	require.NoError(t, err)
-	crcInput, err = deleteLogRecord.crcData()
-	require.NoError(t, err)
+	crcInput = deleteLogRecord.crcData(fixedCodec{})
 	require.NotNil(t, crcInput)
 	deleteLogRecord.calCrc(crcInput)
 
@@ -51,3 +51,71 @@ func Test_All(t *testing.T) {
 	require.Equal(t, deleteLogRecord, gotDeleteLogRecord)
 
 }
+
+func TestLogRecord_DeserializeTruncated(t *testing.T) {
+	normalLogRecord, err := NewNormalLogRecord([]byte("hello"), []byte("world"))
+	require.NoError(t, err)
+	bs, err := normalLogRecord.Serialize()
+	require.NoError(t, err)
+	// 任何位置截断都不能panic
+	for cut := 0; cut < len(bs); cut++ {
+		_, err = new(LogRecord).Deserialize(bs[:cut])
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF, "cut at %d", cut)
+	}
+
+	bs[len(bs)-1] ^= 0xff
+	_, err = new(LogRecord).Deserialize(bs)
+	require.ErrorIs(t, err, ErrCrcCheckFailed)
+
+	bs[crcSz] = 0xff
+	_, err = new(LogRecord).Deserialize(bs)
+	require.ErrorIs(t, err, ErrUnknownRecordType)
+}
+
+func TestLogRecord_Batch(t *testing.T) {
+	normalLogRecord, err := NewNormalLogRecord([]byte("hello"), []byte("world"))
+	require.NoError(t, err)
+	plain, err := normalLogRecord.Serialize()
+	require.NoError(t, err)
+
+	normalLogRecord.SetSeq(42)
+	bs, err := normalLogRecord.Serialize()
+	require.NoError(t, err)
+	require.Len(t, bs, len(plain)+seqSz)
+	require.Equal(t, normalLogRecord.Size(), int64(len(bs)))
+	got, err := new(LogRecord).Deserialize(bs)
+	require.NoError(t, err)
+	require.Equal(t, normalLogRecord, got)
+	require.Equal(t, uint64(42), got.(*LogRecord).Seq())
+
+	commit, err := NewBatchCommitLogRecord(42)
+	require.NoError(t, err)
+	cs, err := commit.Serialize()
+	require.NoError(t, err)
+	got, err = new(LogRecord).Deserialize(cs)
+	require.NoError(t, err)
+	require.Equal(t, commit, got)
+	require.Equal(t, BatchCommitRecord, got.Op())
+	require.Nil(t, got.Value())
+}
+
+func TestLogRecord_Expiry(t *testing.T) {
+	normalLogRecord, err := NewNormalLogRecord([]byte("hello"), []byte("world"))
+	require.NoError(t, err)
+	plain, err := normalLogRecord.Serialize()
+	require.NoError(t, err)
+	require.False(t, normalLogRecord.IsExpired(time.Now()))
+
+	expireAt := time.Now().Add(time.Minute)
+	normalLogRecord.SetExpireAt(uint64(expireAt.UnixNano()))
+	normalLogRecord.SetSeq(7)
+	bs, err := normalLogRecord.Serialize()
+	require.NoError(t, err)
+	require.Len(t, bs, len(plain)+expireSz+seqSz)
+	got, err := new(LogRecord).Deserialize(bs)
+	require.NoError(t, err)
+	require.Equal(t, normalLogRecord, got)
+	require.False(t, got.(*LogRecord).IsExpired(time.Now()))
+	require.True(t, got.(*LogRecord).IsExpired(expireAt))
+	require.Equal(t, uint64(7), got.(*LogRecord).Seq())
+}
@@ -0,0 +1,177 @@
+package disk
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// MemStorage 数据文件全部保存在内存中的Storage，主要用于测试
+// 同一个MemStorage中的文件在Close之后仍然存在，可以重新打开
+type MemStorage struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	data []byte
+	sync.RWMutex
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{files: make(map[string]*memFile)}
+}
+
+func (s *MemStorage) Open(path string, suffix uint64, isActiveFile bool) (PersistentStorage, error) {
+	path = filepath.Clean(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.files[path]
+	if !ok {
+		if !isActiveFile {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+		file = new(memFile)
+		s.files[path] = file
+	}
+	return &memPersistent{storage: s, path: path, file: file, writable: isActiveFile}, nil
+}
+
+func (s *MemStorage) List(dir string) ([]string, error) {
+	dir = filepath.Clean(dir)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for path := range s.files {
+		if filepath.Dir(path) == dir {
+			names = append(names, filepath.Base(path))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *MemStorage) Rename(oldPath, newPath string) error {
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.files[oldPath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrNotExist}
+	}
+	delete(s.files, oldPath)
+	s.files[newPath] = file
+	return nil
+}
+
+func (s *MemStorage) Remove(path string) error {
+	path = filepath.Clean(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[path]; !ok {
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrNotExist}
+	}
+	delete(s.files, path)
+	return nil
+}
+
+// SyncDir 内存中的文件没有目录项需要同步
+func (s *MemStorage) SyncDir(dir string) error {
+	return nil
+}
+
+// memPersistent MemStorage中一个打开的文件
+type memPersistent struct {
+	storage  *MemStorage
+	path     string
+	file     *memFile
+	writable bool
+	closed   bool
+	sync.RWMutex
+}
+
+func (m *memPersistent) isClosed() bool {
+	m.RLock()
+	defer m.RUnlock()
+	return m.closed
+}
+
+func (m *memPersistent) ReadFromDisk(bs []byte, offset uint64) (int, error) {
+	if m.isClosed() {
+		return 0, os.ErrClosed
+	}
+	m.file.RLock()
+	defer m.file.RUnlock()
+	if offset >= uint64(len(m.file.data)) {
+		if len(bs) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n := copy(bs, m.file.data[offset:])
+	if n < len(bs) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memPersistent) WriteToDisk(bs []byte) (int64, int, error) {
+	if m.isClosed() {
+		return 0, 0, os.ErrClosed
+	}
+	if !m.writable {
+		return 0, 0, ErrReadOnlyStorage
+	}
+	m.file.Lock()
+	defer m.file.Unlock()
+	offset := int64(len(m.file.data))
+	m.file.data = append(m.file.data, bs...)
+	return offset, len(bs), nil
+}
+
+func (m *memPersistent) Sync() error {
+	if m.isClosed() {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+func (m *memPersistent) Offset() int64 {
+	m.file.RLock()
+	defer m.file.RUnlock()
+	return int64(len(m.file.data))
+}
+
+func (m *memPersistent) Truncate(size int64) error {
+	if m.isClosed() {
+		return os.ErrClosed
+	}
+	if !m.writable {
+		return ErrReadOnlyStorage
+	}
+	m.file.Lock()
+	defer m.file.Unlock()
+	if size < int64(len(m.file.data)) {
+		// 截断后追加写不能覆盖之前读出去的数据
+		m.file.data = append([]byte(nil), m.file.data[:size]...)
+	} else {
+		m.file.data = append(m.file.data, make([]byte, size-int64(len(m.file.data)))...)
+	}
+	return nil
+}
+
+func (m *memPersistent) Close() error {
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return os.ErrClosed
+	}
+	m.closed = true
+	return nil
+}
+
+func (m *memPersistent) Delete() error {
+	return m.storage.Remove(m.path)
+}
@@ -0,0 +1,118 @@
+package disk
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	// ErrReadOnlyStorage 只读的PersistentStorage不支持写入
+	ErrReadOnlyStorage = errors.New("storage is read-only")
+	errMmapUnsupported = errors.New("mmap is not supported on this platform")
+)
+
+// ZeroCopyReader 可以不经过拷贝直接返回底层数据的PersistentStorage
+type ZeroCopyReader interface {
+	// Slice 返回[offset, offset+n)的数据，返回的slice直接引用底层存储，存储关闭后不能再访问
+	Slice(offset uint64, n int) ([]byte, error)
+}
+
+// MmapPersistentImpl 使用mmap只读访问不再写入的older file
+// 读取不需要系统调用，Slice可以返回不经过拷贝的数据
+type MmapPersistentImpl struct {
+	file *os.File
+	data []byte
+	// closed之后data已经被unmap，保护并发的读取和Close
+	closed bool
+	sync.RWMutex
+}
+
+// NewMmapPersistentImpl 以只读方式映射absPath
+func NewMmapPersistentImpl(absPath string) (PersistentStorage, error) {
+	file, err := os.Open(absPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	res := &MmapPersistentImpl{file: file}
+	// 长度为0的文件无法映射
+	if info.Size() > 0 {
+		if res.data, err = mmapFile(file, int(info.Size())); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (m *MmapPersistentImpl) Slice(offset uint64, n int) ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if m.closed {
+		return nil, os.ErrClosed
+	}
+	if offset > uint64(len(m.data)) || uint64(n) > uint64(len(m.data))-offset {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return m.data[offset : offset+uint64(n) : offset+uint64(n)], nil
+}
+
+func (m *MmapPersistentImpl) ReadFromDisk(bs []byte, offset uint64) (int, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if m.closed {
+		return 0, os.ErrClosed
+	}
+	if offset >= uint64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(bs, m.data[offset:])
+	if n < len(bs) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *MmapPersistentImpl) WriteToDisk(bs []byte) (int64, int, error) {
+	return 0, 0, ErrReadOnlyStorage
+}
+
+func (m *MmapPersistentImpl) Truncate(size int64) error {
+	return ErrReadOnlyStorage
+}
+
+// Sync 映射是只读的，没有需要同步的数据
+func (m *MmapPersistentImpl) Sync() error {
+	return nil
+}
+
+func (m *MmapPersistentImpl) Offset() int64 {
+	return int64(len(m.data))
+}
+
+func (m *MmapPersistentImpl) Close() error {
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	var err error
+	if m.data != nil {
+		err = munmap(m.data)
+	}
+	if closeErr := m.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (m *MmapPersistentImpl) Delete() error {
+	return os.Remove(m.file.Name())
+}
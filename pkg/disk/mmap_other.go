@@ -0,0 +1,14 @@
+//go:build !unix
+
+package disk
+
+import "os"
+
+// 非unix平台暂时不支持mmap，NewManager会退回到FilePersistentImpl
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmap(data []byte) error {
+	return nil
+}
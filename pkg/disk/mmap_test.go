@@ -0,0 +1,127 @@
+package disk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitcask-go/pkg/index"
+)
+
+func TestMmapPersistentImpl(t *testing.T) {
+	absPath := filepath.Join(t.TempDir(), "mmap.data")
+	require.NoError(t, os.WriteFile(absPath, []byte("hello mmap"), 0600))
+
+	p, err := NewMmapPersistentImpl(absPath)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), p.Offset())
+
+	bs, err := p.(ZeroCopyReader).Slice(6, 4)
+	require.NoError(t, err)
+	require.Equal(t, []byte("mmap"), bs)
+	// 返回的slice不能append到后面的数据上
+	require.Equal(t, 4, cap(bs))
+	_, err = p.(ZeroCopyReader).Slice(6, 5)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	buf := make([]byte, 5)
+	n, err := p.ReadFromDisk(buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	require.Equal(t, []byte("hello"), buf)
+	n, err = p.ReadFromDisk(buf, 8)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, 2, n)
+
+	_, _, err = p.WriteToDisk([]byte("x"))
+	require.ErrorIs(t, err, ErrReadOnlyStorage)
+	require.ErrorIs(t, p.Truncate(0), ErrReadOnlyStorage)
+	require.NoError(t, p.Sync())
+
+	require.NoError(t, p.Close())
+	require.NoError(t, p.Close())
+	_, err = p.(ZeroCopyReader).Slice(0, 1)
+	require.ErrorIs(t, err, os.ErrClosed)
+	require.NoError(t, p.Delete())
+	_, err = os.Stat(absPath)
+	require.True(t, os.IsNotExist(err))
+
+	// 空文件不做映射
+	require.NoError(t, os.WriteFile(absPath, nil, 0600))
+	p, err = NewMmapPersistentImpl(absPath)
+	require.NoError(t, err)
+	require.Zero(t, p.Offset())
+	require.NoError(t, p.Close())
+}
+
+func TestDataFileImpl_Mmap(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir, 1, true, 4<<20, WithMmap(true), WithFormatVersion(FormatV2))
+	require.NoError(t, err)
+	var metas []*index.ValueMetadata
+	for i := 0; i < 100; i++ {
+		vm, err := m.Write([]byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprintf("v%d", i)), false)
+		require.NoError(t, err)
+		metas = append(metas, vm)
+	}
+	older, err := m.ToOlderFile()
+	require.NoError(t, err)
+	_, ok := older.(*DataFileImpl).persistent.(*MmapPersistentImpl)
+	require.True(t, ok)
+
+	for i, vm := range metas {
+		value, err := older.Read(vm)
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("v%d", i)), value)
+	}
+	record, _, err := older.ReadLogRecord(older.Header().DataOffset())
+	require.NoError(t, err)
+	require.Equal(t, []byte("k0"), record.Key())
+	_, err = older.Write([]byte("k"), []byte("v"), true)
+	require.ErrorIs(t, err, ErrReadOnlyStorage)
+	require.NoError(t, older.Close())
+
+	// 重新打开的older file同样使用mmap
+	older, err = NewManager(dir, 1, false, 4<<20, WithMmap(true))
+	require.NoError(t, err)
+	value, err := older.Read(metas[42])
+	require.NoError(t, err)
+	require.Equal(t, []byte("v42"), value)
+	require.NoError(t, older.Close())
+}
+
+func BenchmarkDataFileImpl_Read(b *testing.B) {
+	dir := b.TempDir()
+	m, err := NewManager(dir, 1, true, 1<<40)
+	require.NoError(b, err)
+	const n = 10000
+	value := make([]byte, 256)
+	metas := make([]*index.ValueMetadata, 0, n)
+	for i := 0; i < n; i++ {
+		vm, err := m.Write([]byte(fmt.Sprintf("user:%06d", i)), value, false)
+		require.NoError(b, err)
+		metas = append(metas, vm)
+	}
+	require.NoError(b, m.Close())
+
+	for _, mmap := range []bool{false, true} {
+		b.Run(fmt.Sprintf("mmap=%v", mmap), func(b *testing.B) {
+			older, err := NewManager(dir, 1, false, 1<<40, WithMmap(mmap))
+			require.NoError(b, err)
+			defer func() {
+				require.NoError(b, older.Close())
+			}()
+			b.SetBytes(int64(len(value)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := older.Read(metas[i%n]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
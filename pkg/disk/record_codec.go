@@ -0,0 +1,198 @@
+package disk
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// recordCodec LogRecord头部中crc和type之后字段的编码方式，由数据文件的格式版本决定
+type recordCodec interface {
+	// maxHeaderSize 所有类型LogRecord中最长的头部长度
+	maxHeaderSize() int
+	// headerSize 返回LogRecord编码后的头部长度
+	headerSize(d *LogRecord) int
+	// appendHeader 将头部中crc之后的部分追加到b
+	appendHeader(b []byte, d *LogRecord) []byte
+	// decodeHeader 解析头部，解析出的字段直接写入res，返回头部长度，header不完整时返回io.ErrUnexpectedEOF
+	decodeHeader(header []byte, res *LogRecord) (int, error)
+}
+
+// codecFor 返回格式版本为version的数据文件使用的recordCodec
+func codecFor(version uint16) recordCodec {
+	if version == FormatV2 {
+		return varintCodec{}
+	}
+	return fixedCodec{}
+}
+
+// decodeType 解析头部中的crc和type字节
+func decodeType(header []byte, res *LogRecord) (byte, error) {
+	if len(header) < crcSz+typeSz {
+		return 0, io.ErrUnexpectedEOF
+	}
+	typeByte := header[crcSz]
+	res.typ = LogRecordType(typeByte & typeMask)
+	if res.typ > BatchCommitRecord {
+		return 0, ErrUnknownRecordType
+	}
+	res.crc = defaultEndianness.Uint32(header[:crcSz])
+	res.compressed = typeByte&compressFlag != 0
+	return typeByte, nil
+}
+
+// fixedCodec 所有字段都是固定8字节，FormatV0和FormatV1使用
+type fixedCodec struct{}
+
+func (fixedCodec) maxHeaderSize() int {
+	return crcSz + typeSz + tmStampSz + expireSz + seqSz + kszSz + vszSz
+}
+
+func (fixedCodec) headerSize(d *LogRecord) int {
+	return fixedHeaderSize(d.typeByte())
+}
+
+// fixedHeaderSize 返回type字节为typeByte的LogRecord的头部长度
+func fixedHeaderSize(typeByte byte) int {
+	sz := crcSz + typeSz + tmStampSz + kszSz
+	if typeByte&expiryFlag != 0 {
+		sz += expireSz
+	}
+	if typeByte&batchFlag != 0 {
+		sz += seqSz
+	}
+	if hasValue(LogRecordType(typeByte & typeMask)) {
+		sz += vszSz
+	}
+	return sz
+}
+
+func (fixedCodec) appendHeader(b []byte, d *LogRecord) []byte {
+	b = append(b, d.typeByte())
+	b = defaultEndianness.AppendUint64(b, d.tmStamp)
+	if d.expireAt != 0 {
+		b = defaultEndianness.AppendUint64(b, d.expireAt)
+	}
+	if d.seq != 0 {
+		b = defaultEndianness.AppendUint64(b, d.seq)
+	}
+	b = defaultEndianness.AppendUint64(b, d.ksz)
+	if hasValue(d.typ) {
+		b = defaultEndianness.AppendUint64(b, d.valueSz)
+	}
+	return b
+}
+
+func (fixedCodec) decodeHeader(header []byte, res *LogRecord) (int, error) {
+	typeByte, err := decodeType(header, res)
+	if err != nil {
+		return 0, err
+	}
+	sz := fixedHeaderSize(typeByte)
+	if len(header) < sz {
+		return 0, io.ErrUnexpectedEOF
+	}
+	pos := crcSz + typeSz
+	res.tmStamp = defaultEndianness.Uint64(header[pos : pos+tmStampSz])
+	pos += tmStampSz
+	if typeByte&expiryFlag != 0 {
+		res.expireAt = defaultEndianness.Uint64(header[pos : pos+expireSz])
+		pos += expireSz
+	}
+	if typeByte&batchFlag != 0 {
+		res.seq = defaultEndianness.Uint64(header[pos : pos+seqSz])
+		pos += seqSz
+	}
+	res.ksz = defaultEndianness.Uint64(header[pos : pos+kszSz])
+	pos += kszSz
+	if hasValue(res.typ) {
+		res.valueSz = defaultEndianness.Uint64(header[pos : pos+vszSz])
+	}
+	// 长度明显不合理的一定是损坏的数据，当作不完整处理
+	if res.ksz > maxFieldSz || res.valueSz > maxFieldSz {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return sz, nil
+}
+
+// varintCodec 时间戳和长度都使用uvarint编码，FormatV2使用，小的key和value可以省下大部分头部开销
+type varintCodec struct{}
+
+func (varintCodec) maxHeaderSize() int {
+	// tmStamp、expireAt、seq、ksz、valueSz最多5个字段
+	return crcSz + typeSz + 5*binary.MaxVarintLen64
+}
+
+func (varintCodec) headerSize(d *LogRecord) int {
+	sz := crcSz + typeSz + uvarintSize(d.tmStamp) + uvarintSize(d.ksz)
+	if d.expireAt != 0 {
+		sz += uvarintSize(d.expireAt)
+	}
+	if d.seq != 0 {
+		sz += uvarintSize(d.seq)
+	}
+	if hasValue(d.typ) {
+		sz += uvarintSize(d.valueSz)
+	}
+	return sz
+}
+
+func uvarintSize(x uint64) int {
+	sz := 1
+	for ; x >= 0x80; x >>= 7 {
+		sz++
+	}
+	return sz
+}
+
+func (varintCodec) appendHeader(b []byte, d *LogRecord) []byte {
+	b = append(b, d.typeByte())
+	b = binary.AppendUvarint(b, d.tmStamp)
+	if d.expireAt != 0 {
+		b = binary.AppendUvarint(b, d.expireAt)
+	}
+	if d.seq != 0 {
+		b = binary.AppendUvarint(b, d.seq)
+	}
+	b = binary.AppendUvarint(b, d.ksz)
+	if hasValue(d.typ) {
+		b = binary.AppendUvarint(b, d.valueSz)
+	}
+	return b
+}
+
+func (varintCodec) decodeHeader(header []byte, res *LogRecord) (int, error) {
+	typeByte, err := decodeType(header, res)
+	if err != nil {
+		return 0, err
+	}
+	pos := crcSz + typeSz
+	// 依次解析各个字段，数据不完整或者溢出时都当作不完整处理
+	next := func(field *uint64) bool {
+		v, n := binary.Uvarint(header[pos:])
+		if n <= 0 {
+			return false
+		}
+		*field = v
+		pos += n
+		return true
+	}
+	if !next(&res.tmStamp) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if typeByte&expiryFlag != 0 && !next(&res.expireAt) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if typeByte&batchFlag != 0 && !next(&res.seq) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if !next(&res.ksz) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if hasValue(res.typ) && !next(&res.valueSz) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if res.ksz > maxFieldSz || res.valueSz > maxFieldSz {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return pos, nil
+}
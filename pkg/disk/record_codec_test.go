@@ -0,0 +1,157 @@
+package disk
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testRecords(t testing.TB) []*LogRecord {
+	normal, err := NewNormalLogRecord([]byte("hello"), []byte("world"))
+	require.NoError(t, err)
+	del, err := NewDeleteLogRecord([]byte("hello"))
+	require.NoError(t, err)
+	commit, err := NewBatchCommitLogRecord(1 << 40)
+	require.NoError(t, err)
+	expiring, err := NewNormalLogRecord([]byte("session"), make([]byte, 300))
+	require.NoError(t, err)
+	expiring.SetExpireAt(uint64(time.Now().Add(time.Hour).UnixNano()))
+	expiring.SetSeq(3)
+	empty, err := NewNormalLogRecord([]byte{}, []byte{})
+	require.NoError(t, err)
+	return []*LogRecord{normal, del, commit, expiring, empty}
+}
+
+func TestRecordCodec(t *testing.T) {
+	for _, codec := range []recordCodec{fixedCodec{}, varintCodec{}} {
+		for i, record := range testRecords(t) {
+			bs := record.encode(codec)
+			require.Equal(t, record.encodedSize(codec), int64(len(bs)), "%T record %d", codec, i)
+			size, err := recordSize(bs, codec)
+			require.NoError(t, err)
+			require.Equal(t, uint64(len(bs)), size)
+			got, err := decodeRecord(bs, codec)
+			require.NoError(t, err, "%T record %d", codec, i)
+			require.Equal(t, record.Op(), got.Op())
+			require.Equal(t, record.Key(), got.Key())
+			require.Equal(t, record.Value(), got.Value())
+			require.Equal(t, record.TmStamp(), got.TmStamp())
+			require.Equal(t, record.ExpireAt(), got.ExpireAt())
+			require.Equal(t, record.Seq(), got.Seq())
+
+			// 任何位置截断都不能panic
+			for cut := 0; cut < len(bs); cut++ {
+				_, err = decodeRecord(bs[:cut], codec)
+				require.ErrorIs(t, err, io.ErrUnexpectedEOF, "%T record %d cut at %d", codec, i, cut)
+			}
+			bs[len(bs)-1] ^= 0xff
+			_, err = decodeRecord(bs, codec)
+			require.Error(t, err)
+		}
+	}
+
+	// 固定长度格式和Serialize一致
+	record := testRecords(t)[0]
+	bs, err := record.Serialize()
+	require.NoError(t, err)
+	require.Equal(t, bs, record.encode(fixedCodec{}))
+	// 小的key和value使用uvarint编码可以省下大部分头部
+	require.Less(t, len(record.encode(varintCodec{})), len(bs)-16)
+}
+
+func TestDataFileImpl_FormatV2(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir, 1, true, 4<<20, WithFormatVersion(FormatV2))
+	require.NoError(t, err)
+	require.Equal(t, FormatV2, m.Header().Version)
+	records := testRecords(t)
+	vs, err := m.WriteLogRecords(records, false)
+	require.NoError(t, err)
+	val, err := m.Read(vs[0])
+	require.NoError(t, err)
+	require.Equal(t, []byte("world"), val)
+	require.NoError(t, m.Close())
+
+	// 重新打开时按照文件头中的版本读取，忽略WithFormatVersion
+	m, err = NewManager(dir, 1, false, 0, WithFormatVersion(FormatV1))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, m.Close())
+	}()
+	require.Equal(t, FormatV2, m.Header().Version)
+	offset := m.Header().DataOffset()
+	for i, record := range records {
+		got, size, err := m.ReadLogRecord(offset)
+		require.NoError(t, err)
+		require.Equal(t, vs[i].ValuePos, offset)
+		require.Equal(t, vs[i].ValueSz, size)
+		require.Equal(t, record.Key(), got.Key())
+		offset += size
+	}
+	_, _, err = m.ReadLogRecord(offset)
+	require.ErrorIs(t, err, io.EOF)
+
+	_, err = NewManager(t.TempDir(), 1, true, 4<<20, WithFormatVersion(CurrentFormatVersion+1))
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func benchmarkRecord(b *testing.B, valueSz int) *LogRecord {
+	record, err := NewNormalLogRecord([]byte("user:000042"), make([]byte, valueSz))
+	require.NoError(b, err)
+	return record
+}
+
+func BenchmarkRecordCodec_Encode(b *testing.B) {
+	for _, valueSz := range []int{8, 128, 4096} {
+		for _, codec := range []recordCodec{fixedCodec{}, varintCodec{}} {
+			b.Run(fmt.Sprintf("%T/value=%d", codec, valueSz), func(b *testing.B) {
+				record := benchmarkRecord(b, valueSz)
+				b.ReportAllocs()
+				b.ReportMetric(float64(record.encodedSize(codec)), "bytes/record")
+				for i := 0; i < b.N; i++ {
+					_ = record.encode(codec)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkRecordCodec_Decode(b *testing.B) {
+	for _, valueSz := range []int{8, 128, 4096} {
+		for _, codec := range []recordCodec{fixedCodec{}, varintCodec{}} {
+			b.Run(fmt.Sprintf("%T/value=%d", codec, valueSz), func(b *testing.B) {
+				bs := benchmarkRecord(b, valueSz).encode(codec)
+				b.ReportAllocs()
+				b.SetBytes(int64(len(bs)))
+				for i := 0; i < b.N; i++ {
+					if _, err := decodeRecord(bs, codec); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkDataFileImpl_Write(b *testing.B) {
+	for _, version := range []uint16{FormatV1, FormatV2} {
+		b.Run(fmt.Sprintf("v%d", version), func(b *testing.B) {
+			m, err := NewManager(b.TempDir(), 1, true, 1<<40, WithFormatVersion(version))
+			require.NoError(b, err)
+			defer func() {
+				require.NoError(b, m.Close())
+			}()
+			key, value := []byte("user:000042"), make([]byte, 16)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err = m.Write(key, value, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(m.Size()-int64(m.Header().DataOffset()))/float64(b.N), "bytes/record")
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Storage 数据文件的存储后端，默认是本地文件系统FileStorage
+// DataFileImpl通过Open拿到PersistentStorage读写数据文件，恢复和merge时通过List、Rename、Remove管理目录下的数据文件
+// hint文件、merge完成标记和目录锁始终在本地文件系统上
+type Storage interface {
+	// Open 打开path处的数据文件，isActiveFile为true时以追加写方式打开，文件不存在时创建
+	// isActiveFile为false时文件不存在返回os.ErrNotExist
+	Open(path string, suffix uint64, isActiveFile bool) (PersistentStorage, error)
+	// List 返回dir下所有文件的文件名，dir不存在时返回空
+	List(dir string) ([]string, error)
+	// Rename 将oldPath重命名为newPath，newPath已经存在时覆盖，oldPath不存在时返回os.ErrNotExist
+	Rename(oldPath, newPath string) error
+	// Remove 删除path，不存在时返回os.ErrNotExist
+	Remove(path string) error
+	// SyncDir 将dir的目录项同步到磁盘，保证之前在dir下新建、重命名和删除的文件掉电后仍然有效
+	SyncDir(dir string) error
+}
+
+// FileStorage 使用本地文件系统存储数据文件
+type FileStorage struct{}
+
+func (FileStorage) Open(path string, suffix uint64, isActiveFile bool) (PersistentStorage, error) {
+	return NewFilePersistentImpl(path, suffix, isActiveFile)
+}
+
+func (FileStorage) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (FileStorage) Rename(oldPath, newPath string) error {
+	if err := os.MkdirAll(filepath.Dir(newPath), os.FileMode(0755)); err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func (FileStorage) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (FileStorage) SyncDir(dir string) error {
+	return syncDir(dir)
+}
+
+// RemoveDataFiles 删除s中dir下所有的数据文件
+func RemoveDataFiles(s Storage, dir string) error {
+	fileIDs, err := ListDataFileIDs(s, dir)
+	if err != nil {
+		return err
+	}
+	for _, fid := range fileIDs {
+		if err = s.Remove(DataFileName(dir, fid)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
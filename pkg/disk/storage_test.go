@@ -0,0 +1,209 @@
+package disk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitcask-go/pkg/index"
+)
+
+func TestStorage(t *testing.T) {
+	for name, newStorage := range map[string]func() Storage{
+		"file":  func() Storage { return FileStorage{} },
+		"mem":   func() Storage { return NewMemStorage() },
+		"fault": func() Storage { return NewFaultStorage(NewMemStorage()) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, dir := newStorage(), t.TempDir()
+			a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+
+			_, err := s.Open(a, 1, false)
+			require.ErrorIs(t, err, os.ErrNotExist)
+			p, err := s.Open(a, 1, true)
+			require.NoError(t, err)
+			offset, wn, err := p.WriteToDisk([]byte("hello"))
+			require.NoError(t, err)
+			require.Equal(t, int64(0), offset)
+			require.Equal(t, 5, wn)
+			offset, _, err = p.WriteToDisk([]byte(" storage"))
+			require.NoError(t, err)
+			require.Equal(t, int64(5), offset)
+			require.NoError(t, p.Sync())
+			require.NoError(t, p.Truncate(9))
+			require.Equal(t, int64(9), p.Offset())
+			require.NoError(t, p.Close())
+
+			names, err := s.List(dir)
+			require.NoError(t, err)
+			require.Equal(t, []string{"a"}, names)
+			require.NoError(t, s.Rename(a, b))
+			require.ErrorIs(t, s.Rename(a, b), os.ErrNotExist)
+
+			p, err = s.Open(b, 1, false)
+			require.NoError(t, err)
+			require.Equal(t, int64(9), p.Offset())
+			bs := make([]byte, 10)
+			n, err := p.ReadFromDisk(bs, 0)
+			require.ErrorIs(t, err, io.EOF)
+			require.Equal(t, []byte("hello sto"), bs[:n])
+			require.NoError(t, p.Close())
+			require.NoError(t, p.Delete())
+			require.ErrorIs(t, s.Remove(b), os.ErrNotExist)
+
+			names, err = s.List(filepath.Join(dir, "not-exist"))
+			require.NoError(t, err)
+			require.Empty(t, names)
+		})
+	}
+}
+
+func TestDataFileImpl_MemStorage(t *testing.T) {
+	s := NewMemStorage()
+	m, err := NewManager("/mem", 1, true, 4<<20, WithStorage(s), WithFormatVersion(FormatV2))
+	require.NoError(t, err)
+	var metas []*index.ValueMetadata
+	for i := 0; i < 10; i++ {
+		vm, err := m.Write([]byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprintf("v%d", i)), false)
+		require.NoError(t, err)
+		metas = append(metas, vm)
+	}
+	older, err := m.ToOlderFile()
+	require.NoError(t, err)
+	for i, vm := range metas {
+		value, err := older.Read(vm)
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("v%d", i)), value)
+	}
+	require.NoError(t, older.Close())
+
+	// 数据只在内存中
+	_, err = os.Stat(DataFileName("/mem", 1))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	ids, err := ListDataFileIDs(s, "/mem")
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1}, ids)
+
+	// mmap只对本地文件生效
+	older, err = NewManager("/mem", 1, false, 0, WithStorage(s), WithMmap(true))
+	require.NoError(t, err)
+	require.Equal(t, FormatV2, older.Header().Version)
+	value, err := older.Read(metas[3])
+	require.NoError(t, err)
+	require.Equal(t, []byte("v3"), value)
+	require.NoError(t, older.Close())
+	require.NoError(t, older.Delete())
+	_, err = NewManager("/mem", 1, false, 0, WithStorage(s))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFaultStorage(t *testing.T) {
+	s := NewFaultStorage(NewMemStorage())
+	m, err := NewManager("/fault", 1, true, 4<<20, WithStorage(s), WithSyncOptions(SyncOptions{Policy: SyncEveryBytes, Bytes: 1 << 20}))
+	require.NoError(t, err)
+	synced, err := m.Write([]byte("synced"), []byte("value"), false)
+	require.NoError(t, err)
+	require.NoError(t, m.Sync())
+	unsynced, err := m.Write([]byte("unsynced"), []byte("value"), false)
+	require.NoError(t, err)
+
+	// Sync失败
+	s.FailSync(true)
+	require.ErrorIs(t, m.Sync(), ErrInjectedFault)
+	s.FailSync(false)
+
+	// 写入到一半失败，写了一半的数据被截断
+	s.FailWritesAfter(3)
+	_, err = m.Write([]byte("torn"), []byte("value"), false)
+	require.ErrorIs(t, err, ErrInjectedFault)
+	require.Equal(t, int64(unsynced.ValuePos+unsynced.ValueSz), m.Size())
+	s.FailWritesAfter(-1)
+	_, _, err = m.ReadLogRecord(unsynced.ValuePos + unsynced.ValueSz)
+	require.ErrorIs(t, err, io.EOF)
+
+	// 掉电后只剩下Sync过的数据
+	require.NoError(t, s.Crash())
+	_, err = m.Write([]byte("after"), []byte("crash"), false)
+	require.ErrorIs(t, err, ErrInjectedFault)
+	_, err = m.Read(synced)
+	require.ErrorIs(t, err, ErrInjectedFault)
+	require.NoError(t, m.Close())
+	_, err = s.Open(DataFileName("/fault", 1), 1, false)
+	require.ErrorIs(t, err, ErrInjectedFault)
+
+	m, err = NewManager("/fault", 1, false, 0, WithStorage(s.Storage))
+	require.NoError(t, err)
+	require.Equal(t, int64(synced.ValuePos+synced.ValueSz), m.Size())
+	value, err := m.Read(synced)
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), value)
+	require.NoError(t, m.Close())
+}
+
+func TestDataFileImpl_ToOlderFileSyncs(t *testing.T) {
+	s := NewFaultStorage(NewMemStorage())
+	// 目录项已经落盘的空文件
+	p, err := s.Open(DataFileName("/older", 1), 1, true)
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+	require.NoError(t, s.SyncDir("/older"))
+
+	m, err := NewManager("/older", 1, true, 4<<20, WithStorage(s), WithSyncOptions(SyncOptions{Policy: SyncNever}))
+	require.NoError(t, err)
+	vm, err := m.Write([]byte("key"), []byte("value"), false)
+	require.NoError(t, err)
+	// SyncNever时切换成older file也会落盘，掉电之后older file是完整的
+	older, err := m.ToOlderFile()
+	require.NoError(t, err)
+	require.NoError(t, s.Crash())
+	require.NoError(t, older.Close())
+
+	older, err = NewManager("/older", 1, false, 0, WithStorage(s.Storage))
+	require.NoError(t, err)
+	require.Equal(t, int64(vm.ValuePos+vm.ValueSz), older.Size())
+	value, err := older.Read(vm)
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), value)
+	require.NoError(t, older.Close())
+}
+
+func TestDataFileImpl_SyncDir(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncNever, SyncAlways} {
+		s := NewFaultStorage(NewMemStorage())
+		m, err := NewManager("/dir", 1, true, 4<<20, WithStorage(s), WithSyncOptions(SyncOptions{Policy: policy}))
+		require.NoError(t, err)
+		_, err = m.Write([]byte("k"), []byte("v"), false)
+		require.NoError(t, err)
+		require.NoError(t, m.Sync())
+		require.NoError(t, s.Crash())
+		require.NoError(t, m.Close())
+
+		// 没有同步目录的新文件掉电后连同写入的数据一起消失
+		ids, err := ListDataFileIDs(s.Storage, "/dir")
+		require.NoError(t, err)
+		if policy == SyncNever {
+			require.Empty(t, ids)
+		} else {
+			require.Equal(t, []uint64{1}, ids)
+		}
+	}
+
+	// 重命名之后没有同步目录，掉电后回到原来的路径
+	s := NewFaultStorage(NewMemStorage())
+	p, err := s.Open("/a/1", 1, true)
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+	require.NoError(t, s.SyncDir("/a"))
+	require.NoError(t, s.Rename("/a/1", "/b/1"))
+	require.NoError(t, s.Crash())
+	names, err := s.Storage.List("/a")
+	require.NoError(t, err)
+	require.Equal(t, []string{"1"}, names)
+	names, err = s.Storage.List("/b")
+	require.NoError(t, err)
+	require.Empty(t, names)
+}
@@ -0,0 +1,120 @@
+package disk
+
+import (
+	"sync"
+	"time"
+)
+
+// SyncPolicy 决定追加写之后何时把数据同步到磁盘
+type SyncPolicy uint8
+
+const (
+	// SyncNever 不主动同步，交给操作系统
+	SyncNever SyncPolicy = iota
+	// SyncAlways 每次写入后都同步
+	SyncAlways
+	// SyncEveryBytes 累计写入超过SyncOptions.Bytes字节后同步
+	SyncEveryBytes
+	// SyncEveryInterval 距离上一次同步超过SyncOptions.Interval后，下一次写入时同步
+	SyncEveryInterval
+)
+
+// SyncOptions 同步策略及其参数
+type SyncOptions struct {
+	Policy SyncPolicy
+	// Bytes SyncEveryBytes时使用
+	Bytes int64
+	// Interval SyncEveryInterval时使用
+	Interval time.Duration
+}
+
+// groupSyncer 按照SyncOptions同步数据，并实现group commit:
+// fsync进行期间到达的写入者不再各自fsync，而是等待它结束后由其中一个发起下一次fsync，覆盖所有已经写入的数据
+type groupSyncer struct {
+	opts SyncOptions
+	// syncFn 执行fsync
+	syncFn func() error
+	// offsetFn 返回当前已经写入的位置
+	offsetFn func() int64
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	syncing bool
+	// synced 已经同步到磁盘的位置
+	synced   int64
+	lastSync time.Time
+}
+
+func newGroupSyncer(opts SyncOptions, syncFn func() error, offsetFn func() int64) *groupSyncer {
+	g := &groupSyncer{
+		opts:     opts,
+		syncFn:   syncFn,
+		offsetFn: offsetFn,
+		synced:   offsetFn(),
+		lastSync: time.Now(),
+	}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// afterWrite 写入了结束位置为end的数据后调用，根据策略决定是否需要同步
+func (g *groupSyncer) afterWrite(end int64) error {
+	switch g.opts.Policy {
+	case SyncAlways:
+		return g.syncTo(end)
+	case SyncEveryBytes:
+		g.mu.Lock()
+		due := end-g.synced >= g.opts.Bytes
+		g.mu.Unlock()
+		if due {
+			return g.syncTo(end)
+		}
+	case SyncEveryInterval:
+		g.mu.Lock()
+		due := time.Since(g.lastSync) >= g.opts.Interval
+		g.mu.Unlock()
+		if due {
+			return g.syncTo(end)
+		}
+	}
+	return nil
+}
+
+// syncTo 保证end之前写入的数据都已经同步到磁盘
+func (g *groupSyncer) syncTo(end int64) error {
+	g.mu.Lock()
+	for g.syncing && g.synced < end {
+		g.cond.Wait()
+	}
+	if g.synced >= end {
+		g.mu.Unlock()
+		return nil
+	}
+	g.syncing = true
+	g.mu.Unlock()
+
+	// 这一次fsync覆盖到目前为止写入的所有数据，等待中的写入者都可以直接返回
+	target := g.offsetFn()
+	err := g.syncFn()
+
+	g.mu.Lock()
+	g.syncing = false
+	if err == nil {
+		if target > g.synced {
+			g.synced = target
+		}
+		g.lastSync = time.Now()
+	}
+	g.cond.Broadcast()
+	g.mu.Unlock()
+	return err
+}
+
+// reset 文件被截断后，已同步的位置也要回退
+func (g *groupSyncer) reset() {
+	g.mu.Lock()
+	if off := g.offsetFn(); g.synced > off {
+		g.synced = off
+	}
+	g.mu.Unlock()
+}
@@ -0,0 +1,96 @@
+package disk
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSyncTarget struct {
+	offset atomic.Int64
+	syncs  atomic.Int64
+	delay  time.Duration
+}
+
+func (f *fakeSyncTarget) write(n int64) int64 {
+	return f.offset.Add(n)
+}
+
+func (f *fakeSyncTarget) sync() error {
+	time.Sleep(f.delay)
+	f.syncs.Add(1)
+	return nil
+}
+
+func (f *fakeSyncTarget) syncer(opts SyncOptions) *groupSyncer {
+	return newGroupSyncer(opts, f.sync, f.offset.Load)
+}
+
+func TestGroupSyncer_Always(t *testing.T) {
+	f := &fakeSyncTarget{delay: 5 * time.Millisecond}
+	g := f.syncer(SyncOptions{Policy: SyncAlways})
+
+	const writers = 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			end := f.write(10)
+			require.NoError(t, g.afterWrite(end))
+			// 返回时自己写入的数据一定已经同步了
+			g.mu.Lock()
+			require.GreaterOrEqual(t, g.synced, end)
+			g.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	// 并发的写入者共享fsync
+	require.Less(t, f.syncs.Load(), int64(writers))
+	require.Equal(t, f.offset.Load(), g.synced)
+
+	// 已经同步过的位置不会再次fsync
+	before := f.syncs.Load()
+	require.NoError(t, g.syncTo(f.offset.Load()))
+	require.Equal(t, before, f.syncs.Load())
+}
+
+func TestGroupSyncer_EveryBytes(t *testing.T) {
+	f := new(fakeSyncTarget)
+	g := f.syncer(SyncOptions{Policy: SyncEveryBytes, Bytes: 100})
+	for i := 0; i < 9; i++ {
+		require.NoError(t, g.afterWrite(f.write(10)))
+	}
+	require.Zero(t, f.syncs.Load())
+	require.NoError(t, g.afterWrite(f.write(10)))
+	require.Equal(t, int64(1), f.syncs.Load())
+	require.NoError(t, g.afterWrite(f.write(10)))
+	require.Equal(t, int64(1), f.syncs.Load())
+}
+
+func TestGroupSyncer_EveryInterval(t *testing.T) {
+	f := new(fakeSyncTarget)
+	g := f.syncer(SyncOptions{Policy: SyncEveryInterval, Interval: 20 * time.Millisecond})
+	require.NoError(t, g.afterWrite(f.write(10)))
+	require.Zero(t, f.syncs.Load())
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, g.afterWrite(f.write(10)))
+	require.Equal(t, int64(1), f.syncs.Load())
+	require.NoError(t, g.afterWrite(f.write(10)))
+	require.Equal(t, int64(1), f.syncs.Load())
+}
+
+func TestGroupSyncer_Never(t *testing.T) {
+	f := new(fakeSyncTarget)
+	g := f.syncer(SyncOptions{Policy: SyncNever})
+	for i := 0; i < 10; i++ {
+		require.NoError(t, g.afterWrite(f.write(1<<20)))
+	}
+	require.Zero(t, f.syncs.Load())
+	// 显式同步不受策略影响
+	require.NoError(t, g.syncTo(f.offset.Load()))
+	require.Equal(t, int64(1), f.syncs.Load())
+}
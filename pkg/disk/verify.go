@@ -0,0 +1,193 @@
+package disk
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// ProblemKind 数据文件中损坏数据的类型
+type ProblemKind uint8
+
+const (
+	// ProblemCrcMismatch LogRecord的crc校验失败
+	ProblemCrcMismatch ProblemKind = iota + 1
+	// ProblemTruncated 文件末尾的LogRecord不完整
+	ProblemTruncated
+	// ProblemUnknownType LogRecord的类型无法识别，无法确定它的长度
+	ProblemUnknownType
+	// ProblemOverlap 损坏的LogRecord头部中的长度越过了后面一条完整的LogRecord
+	ProblemOverlap
+	// ProblemUndecodable crc校验通过但是解密或者解压失败
+	ProblemUndecodable
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemCrcMismatch:
+		return "crc-mismatch"
+	case ProblemTruncated:
+		return "truncated"
+	case ProblemUnknownType:
+		return "unknown-type"
+	case ProblemOverlap:
+		return "overlap"
+	case ProblemUndecodable:
+		return "undecodable"
+	default:
+		return "unknown"
+	}
+}
+
+// Problem 数据文件中一段连续的损坏数据
+type Problem struct {
+	Kind ProblemKind
+	// Offset 损坏的数据在文件中的位置
+	Offset uint64
+	// Size 损坏的数据的长度，到后面第一条完整的LogRecord或者文件末尾为止
+	Size uint64
+	// Key 能够解析出来时是损坏的LogRecord的key，crc校验失败时可能是错的
+	Key []byte
+	// Err ReadLogRecord返回的错误
+	Err error
+}
+
+// ScanDataFile 逐条检查数据文件中的LogRecord，对每条完整的LogRecord调用fn，返回所有损坏的数据
+// 遇到长度不可信的损坏数据时，从下一个字节开始逐个位置寻找后面第一条完整的LogRecord继续检查
+// fn返回错误或者读文件失败时停止检查并返回错误
+func ScanDataFile(dataFile DataFile, fn func(record *LogRecord, offset, size uint64) error) ([]Problem, error) {
+	var (
+		problems []Problem
+		// resynced 上一次寻找到的下一条完整的LogRecord的位置
+		resynced uint64
+	)
+	offset := dataFile.Header().DataOffset()
+	for {
+		record, size, err := dataFile.ReadLogRecord(offset)
+		if errors.Is(err, io.EOF) {
+			return problems, nil
+		}
+		if err == nil {
+			if fn != nil {
+				if err = fn(record, offset, size); err != nil {
+					return problems, err
+				}
+			}
+			offset += size
+			continue
+		}
+		problem, err := diagnose(dataFile, offset, err, &resynced)
+		if err != nil {
+			return problems, err
+		}
+		problems = append(problems, problem)
+		offset += problem.Size
+	}
+}
+
+// diagnose 确定offset处损坏数据的类型和范围
+// resynced大于offset时是之前找到的offset之后第一条完整的LogRecord的位置，连续的损坏数据不用重复寻找
+func diagnose(dataFile DataFile, offset uint64, readErr error, resynced *uint64) (Problem, error) {
+	p := Problem{Offset: offset, Err: readErr}
+	end := uint64(dataFile.Size())
+	var declared uint64
+	info, err := dataFile.InspectLogRecord(offset)
+	switch {
+	case errors.Is(err, ErrUnknownRecordType):
+		p.Kind = ProblemUnknownType
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		p.Kind = ProblemTruncated
+	case err != nil:
+		return p, err
+	case info.CrcOK:
+		// crc校验通过说明长度是对的，直接跳过这一条
+		p.Kind, p.Key, p.Size = ProblemUndecodable, info.Key, info.Size
+		return p, nil
+	default:
+		p.Kind, p.Key, declared = ProblemCrcMismatch, info.Key, info.Size
+		// 通常只是value损坏，头部中的长度仍然是对的
+		if next := offset + declared; next == end || validAt(dataFile, next) {
+			p.Size = declared
+			return p, nil
+		}
+	}
+	if *resynced <= offset {
+		*resynced = resync(dataFile, offset+1, end)
+	}
+	next := *resynced
+	switch {
+	case p.Kind == ProblemCrcMismatch && next >= offset+declared:
+		// 长度之内没有完整的LogRecord，后面紧跟着的也是损坏的数据，分开报告
+		p.Size = declared
+	case next < end && p.Kind != ProblemUnknownType:
+		// 后面还有完整的LogRecord，说明头部中的长度也是错的
+		p.Kind, p.Size = ProblemOverlap, next-offset
+	default:
+		p.Size = next - offset
+	}
+	return p, nil
+}
+
+// resyncChunk resync每次读到内存中预先筛选的数据长度
+const resyncChunk = 64 << 10
+
+// resync 从offset开始逐个位置寻找后面第一条完整的LogRecord，返回它的位置，找不到时返回end
+// 先在读到内存的数据中检查头部，类型可以识别并且长度没有越过end的位置才做完整的解码和crc校验
+func resync(dataFile DataFile, offset, end uint64) uint64 {
+	m, ok := dataFile.(*DataFileImpl)
+	if !ok {
+		return resyncSlow(dataFile, offset, end)
+	}
+	headerSz := uint64(m.codec.maxHeaderSize())
+	buf := make([]byte, resyncChunk+headerSz)
+	for base := offset; base < end; base = offset {
+		// 多读一个头部的长度，这一段最后几个位置的头部也是完整的
+		n := uint64(len(buf))
+		if end-base < n {
+			n = end - base
+		}
+		if _, err := m.persistent.ReadFromDisk(buf[:n], base); err != nil {
+			// 读不出来时退回逐个位置完整检查
+			return resyncSlow(dataFile, offset, end)
+		}
+		for ; offset < end && offset < base+resyncChunk; offset++ {
+			bs := buf[offset-base : n]
+			header := bs
+			if uint64(len(header)) > headerSz {
+				header = header[:headerSz]
+			}
+			size, err := recordSize(header, m.codec)
+			if err != nil || size > end-offset {
+				continue
+			}
+			// 整条都在内存中时先校验crc，全是0之类的数据不用每个位置都完整解码
+			if size <= uint64(len(bs)) && crc32.ChecksumIEEE(bs[crcSz:size]) != defaultEndianness.Uint32(bs) {
+				continue
+			}
+			if validAt(dataFile, offset) {
+				return offset
+			}
+		}
+	}
+	return end
+}
+
+// resyncSlow 逐个位置完整检查，返回后面第一条完整的LogRecord的位置，找不到时返回end
+func resyncSlow(dataFile DataFile, offset, end uint64) uint64 {
+	for offset < end && !validAt(dataFile, offset) {
+		offset++
+	}
+	return offset
+}
+
+// IsTornTail 判断offset处的损坏数据是不是写到一半的文件末尾，也就是后面再也没有完整的LogRecord
+func IsTornTail(dataFile DataFile, offset uint64) bool {
+	end := uint64(dataFile.Size())
+	return resync(dataFile, offset+1, end) >= end
+}
+
+// validAt 判断offset处是否是一条完整的LogRecord
+func validAt(dataFile DataFile, offset uint64) bool {
+	_, _, err := dataFile.ReadLogRecord(offset)
+	return err == nil
+}
@@ -0,0 +1,122 @@
+package disk
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitcask-go/pkg/index"
+)
+
+func TestScanDataFile(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir, 1, true, 4<<20)
+	require.NoError(t, err)
+	var metas []*index.ValueMetadata
+	for i := 0; i < 6; i++ {
+		vm, err := m.Write([]byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprintf("value-%d", i)), false)
+		require.NoError(t, err)
+		metas = append(metas, vm)
+	}
+	require.NoError(t, m.Close())
+
+	content, err := os.ReadFile(DataFileName(dir, 1))
+	require.NoError(t, err)
+	// k1的value损坏
+	content[metas[1].ValuePos+metas[1].ValueSz-1] ^= 0xff
+	// k2头部中的ksz变大，越过了k3的开头
+	content[metas[2].ValuePos+crcSz+typeSz+tmStampSz] += 4
+	// k4的类型无法识别
+	content[metas[4].ValuePos+crcSz] = typeMask
+	// 末尾有不完整的LogRecord
+	content = append(content, 1, 2, 3)
+	require.NoError(t, os.WriteFile(DataFileName(dir, 1), content, 0600))
+
+	m, err = NewManager(dir, 1, false, 0)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, m.Close())
+	}()
+	var keys []string
+	problems, err := ScanDataFile(m, func(record *LogRecord, offset, size uint64) error {
+		keys = append(keys, string(record.Key()))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"k0", "k3", "k5"}, keys)
+	require.Len(t, problems, 4)
+
+	require.Equal(t, ProblemCrcMismatch, problems[0].Kind)
+	require.Equal(t, metas[1].ValuePos, problems[0].Offset)
+	require.Equal(t, metas[1].ValueSz, problems[0].Size)
+	require.Equal(t, []byte("k1"), problems[0].Key)
+	require.ErrorIs(t, problems[0].Err, ErrCrcCheckFailed)
+
+	require.Equal(t, ProblemOverlap, problems[1].Kind)
+	require.Equal(t, metas[2].ValuePos, problems[1].Offset)
+	require.Equal(t, metas[2].ValueSz, problems[1].Size)
+
+	require.Equal(t, ProblemUnknownType, problems[2].Kind)
+	require.Equal(t, metas[4].ValuePos, problems[2].Offset)
+	require.Equal(t, metas[4].ValueSz, problems[2].Size)
+
+	require.Equal(t, ProblemTruncated, problems[3].Kind)
+	require.Equal(t, metas[5].ValuePos+metas[5].ValueSz, problems[3].Offset)
+	require.Equal(t, uint64(3), problems[3].Size)
+	require.Equal(t, "truncated", problems[3].Kind.String())
+
+	// fn返回错误时停止
+	stop := fmt.Errorf("stop")
+	_, err = ScanDataFile(m, func(record *LogRecord, offset, size uint64) error {
+		return stop
+	})
+	require.ErrorIs(t, err, stop)
+}
+
+func TestScanDataFile_LargeDamage(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir, 1, true, 4<<20)
+	require.NoError(t, err)
+	var metas []*index.ValueMetadata
+	for _, k := range []string{"k0", "k1"} {
+		vm, err := m.Write([]byte(k), []byte("value-"+k), false)
+		require.NoError(t, err)
+		metas = append(metas, vm)
+	}
+	require.NoError(t, m.Close())
+
+	// k0和k1之间插入一大段全是0和随机的数据
+	content, err := os.ReadFile(DataFileName(dir, 1))
+	require.NoError(t, err)
+	garbage := make([]byte, 256<<10)
+	rnd := rand.New(rand.NewSource(1))
+	rnd.Read(garbage[len(garbage)/2:])
+	split := metas[1].ValuePos
+	content = append(append(append([]byte(nil), content[:split]...), garbage...), content[split:]...)
+	require.NoError(t, os.WriteFile(DataFileName(dir, 1), content, 0600))
+
+	m, err = NewManager(dir, 1, false, 0)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, m.Close())
+	}()
+	var keys []string
+	problems, err := ScanDataFile(m, func(record *LogRecord, offset, size uint64) error {
+		keys = append(keys, string(record.Key()))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"k0", "k1"}, keys)
+	// 损坏的数据首尾相接，正好覆盖插入的数据
+	offset := split
+	for _, p := range problems {
+		require.Equal(t, offset, p.Offset)
+		offset += p.Size
+	}
+	require.Equal(t, split+uint64(len(garbage)), offset)
+	require.False(t, IsTornTail(m, split))
+	require.True(t, IsTornTail(m, uint64(len(content))-1))
+}
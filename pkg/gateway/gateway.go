@@ -0,0 +1,384 @@
+// Package gateway 通过HTTP/JSON对外提供DB的读写，方便运维面板和脚本使用
+//
+//	GET    /kv/{key}              读取value，作为响应体原样返回
+//	PUT    /kv/{key}[?ttl=30s]    请求体作为value写入
+//	DELETE /kv/{key}              删除key
+//	GET    /kv?prefix=&limit=     按key的顺序列出，每行一个JSON对象，边遍历边返回
+//	POST   /batch                 原子地执行一组put和delete
+//	POST   /admin/merge           执行merge
+//	GET    /admin/stats           返回DB的统计信息
+//
+// 路径中的key按照URL转义，加上encoding=base64参数时路径中的key，以及请求和响应的JSON中的key和value都使用base64url编码，
+// 二进制的key和value需要使用base64，否则JSON中非UTF-8的字节会被替换掉
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	bitcask "bitcask-go"
+)
+
+const (
+	// maxBodySize PUT和POST /batch请求体的最大长度
+	maxBodySize = 64 << 20
+	// flushEvery 列出key时每写入这么多条刷新一次，让客户端尽早收到数据
+	flushEvery = 128
+)
+
+var (
+	errNotFound         = errors.New("key not found")
+	errMethodNotAllowed = errors.New("method not allowed")
+)
+
+// Handler 将HTTP请求映射到DB的操作，可以直接作为http.Server的Handler
+type Handler struct {
+	db *bitcask.DB
+}
+
+// NewHandler 创建使用db的Handler，Handler不负责关闭db
+func NewHandler(db *bitcask.DB) *Handler {
+	return &Handler{db: db}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	path := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(path, "/kv/"):
+		err = h.serveKey(w, r, strings.TrimPrefix(path, "/kv/"))
+	case path == "/kv":
+		err = allow(w, r, http.MethodGet)
+		if err == nil {
+			err = h.list(w, r)
+		}
+	case path == "/batch":
+		err = allow(w, r, http.MethodPost)
+		if err == nil {
+			err = h.batch(w, r)
+		}
+	case path == "/admin/merge":
+		err = allow(w, r, http.MethodPost)
+		if err == nil {
+			err = h.merge(w)
+		}
+	case path == "/admin/stats":
+		err = allow(w, r, http.MethodGet)
+		if err == nil {
+			err = h.stats(w)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+// allow 检查请求的方法，不允许时设置Allow头部
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) error {
+	for _, m := range methods {
+		if r.Method == m || (r.Method == http.MethodHead && m == http.MethodGet) {
+			return nil
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	return errMethodNotAllowed
+}
+
+// badRequest 请求的参数错误
+type badRequest struct {
+	msg string
+}
+
+func (e badRequest) Error() string {
+	return e.msg
+}
+
+func badRequestf(format string, args ...any) error {
+	return badRequest{msg: fmt.Sprintf(format, args...)}
+}
+
+// writeError 按照错误的类型返回对应的状态码，响应体是{"error": "..."}
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var br badRequest
+	var maxBytes *http.MaxBytesError
+	switch {
+	case errors.As(err, &br):
+		status = http.StatusBadRequest
+	case errors.As(err, &maxBytes):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, errNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errMethodNotAllowed):
+		status = http.StatusMethodNotAllowed
+	case errors.Is(err, bitcask.ErrReadOnly):
+		status = http.StatusForbidden
+	case errors.Is(err, bitcask.ErrMergeInProgress):
+		status = http.StatusConflict
+	case errors.Is(err, bitcask.ErrDBClosed):
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// codec 请求中key和value的编码方式，由encoding参数决定
+type codec struct {
+	base64 bool
+}
+
+func codecFor(r *http.Request) (codec, error) {
+	switch enc := r.URL.Query().Get("encoding"); enc {
+	case "":
+		return codec{}, nil
+	case "base64":
+		return codec{base64: true}, nil
+	default:
+		return codec{}, badRequestf("unknown encoding %q", enc)
+	}
+}
+
+func (c codec) encode(b []byte) string {
+	if c.base64 {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	return string(b)
+}
+
+func (c codec) decode(s string) ([]byte, error) {
+	if !c.base64 {
+		return []byte(s), nil
+	}
+	// 兼容带有padding的编码
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, badRequestf("invalid base64 %q", s)
+	}
+	return b, nil
+}
+
+// serveKey 处理/kv/{key}，escaped是路径中还没有反转义的key
+func (h *Handler) serveKey(w http.ResponseWriter, r *http.Request, escaped string) error {
+	c, err := codecFor(r)
+	if err != nil {
+		return err
+	}
+	raw, err := url.PathUnescape(escaped)
+	if err != nil {
+		return badRequestf("invalid key %q", escaped)
+	}
+	key, err := c.decode(raw)
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return badRequestf("empty key")
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		value, err := h.db.Get(key)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return errNotFound
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+		_, _ = w.Write(value)
+		return nil
+	case http.MethodPut:
+		var ttl time.Duration
+		if s := r.URL.Query().Get("ttl"); s != "" {
+			if ttl, err = time.ParseDuration(s); err != nil || ttl <= 0 {
+				return badRequestf("invalid ttl %q", s)
+			}
+		}
+		value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			return err
+		}
+		if ttl > 0 {
+			err = h.db.PutWithTTL(key, value, ttl)
+		} else {
+			err = h.db.Put(key, value)
+		}
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case http.MethodDelete:
+		existed, err := h.db.Del(key)
+		if err != nil {
+			return err
+		}
+		if !existed {
+			return errNotFound
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	default:
+		return allow(w, r, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// item 列出key时的一条结果
+type item struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// list 按key的顺序列出带有prefix前缀的key和value，最多limit条，limit为0时不限制
+// 响应是每行一个JSON对象的流，遍历过程中就开始返回，迭代器按批从索引快照中取key，取到limit条就停止
+// 开始返回之后出错时中断连接，客户端会读到不完整的响应而不是被截断的200
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) error {
+	c, err := codecFor(r)
+	if err != nil {
+		return err
+	}
+	query := r.URL.Query()
+	prefix, err := c.decode(query.Get("prefix"))
+	if err != nil {
+		return err
+	}
+	limit := 0
+	if s := query.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+			return badRequestf("invalid limit %q", s)
+		}
+	}
+
+	it := h.db.NewIterator(bitcask.IteratorOptions{Prefix: prefix})
+	defer it.Close()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for n := 0; it.Valid() && (limit == 0 || n < limit); it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			// 已经开始返回数据之后只能中断响应
+			if n == 0 {
+				return err
+			}
+			panic(http.ErrAbortHandler)
+		}
+		// 遍历期间被删除的key
+		if value == nil {
+			continue
+		}
+		if err = enc.Encode(item{Key: c.encode(it.Key()), Value: c.encode(value)}); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		if n++; n%flushEvery == 0 && flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return nil
+}
+
+// batchRequest POST /batch的请求体
+type batchRequest struct {
+	Ops []struct {
+		// Op put或者delete
+		Op    string  `json:"op"`
+		Key   string  `json:"key"`
+		Value *string `json:"value"`
+	} `json:"ops"`
+}
+
+// batch 所有操作作为一次批量写入原子地生效
+func (h *Handler) batch(w http.ResponseWriter, r *http.Request) error {
+	c, err := codecFor(r)
+	if err != nil {
+		return err
+	}
+	var req batchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&req); err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			return err
+		}
+		return badRequestf("invalid batch: %v", err)
+	}
+	wb := h.db.NewWriteBatch()
+	for i, op := range req.Ops {
+		key, err := c.decode(op.Key)
+		if err != nil {
+			return err
+		}
+		if len(key) == 0 {
+			return badRequestf("ops[%d]: empty key", i)
+		}
+		switch op.Op {
+		case "put":
+			if op.Value == nil {
+				return badRequestf("ops[%d]: put without value", i)
+			}
+			value, err := c.decode(*op.Value)
+			if err != nil {
+				return err
+			}
+			err = wb.Put(key, value)
+		case "delete":
+			err = wb.Delete(key)
+		default:
+			return badRequestf("ops[%d]: unknown op %q", i, op.Op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = wb.Commit(); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"applied": len(req.Ops)})
+	return nil
+}
+
+func (h *Handler) merge(w http.ResponseWriter) error {
+	if err := h.db.Merge(); err != nil {
+		return err
+	}
+	return h.stats(w)
+}
+
+// statsResponse GET /admin/stats的响应
+type statsResponse struct {
+	Keys           int   `json:"keys"`
+	DataFiles      int   `json:"data_files"`
+	DiskSize       int64 `json:"disk_size"`
+	DiscardedBytes int64 `json:"discarded_bytes"`
+}
+
+func (h *Handler) stats(w http.ResponseWriter) error {
+	stats, err := h.db.Stats()
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, statsResponse{
+		Keys:           stats.KeyNum,
+		DataFiles:      stats.DataFileNum,
+		DiskSize:       stats.DiskSize,
+		DiscardedBytes: stats.DiscardedBytes,
+	})
+	return nil
+}
@@ -0,0 +1,264 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bitcask "bitcask-go"
+	"bitcask-go/pkg/disk"
+)
+
+func startGateway(t *testing.T, opts ...bitcask.OptionsFunc) (*bitcask.DB, *httptest.Server) {
+	db, err := bitcask.Open(bitcask.NewOptions(append([]bitcask.OptionsFunc{
+		bitcask.DirOption(t.TempDir()),
+		bitcask.MaxSizeOption(4 << 10),
+	}, opts...)))
+	require.NoError(t, err)
+	srv := httptest.NewServer(NewHandler(db))
+	t.Cleanup(func() {
+		srv.Close()
+		require.NoError(t, db.Close())
+	})
+	return db, srv
+}
+
+// call 发送请求，返回状态码和响应体
+func call(t *testing.T, method, u string, body string) (int, string) {
+	req, err := http.NewRequest(method, u, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(bs)
+}
+
+// listItems 解析GET /kv返回的每一行
+func listItems(t *testing.T, body string) []item {
+	var items []item
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		var it item
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &it))
+		items = append(items, it)
+	}
+	return items
+}
+
+func TestHandler_Key(t *testing.T) {
+	_, srv := startGateway(t)
+
+	status, _ := call(t, http.MethodPut, srv.URL+"/kv/hello", "world")
+	require.Equal(t, http.StatusNoContent, status)
+	status, body := call(t, http.MethodGet, srv.URL+"/kv/hello", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "world", body)
+
+	// URL转义的key，可以包含/
+	key := "a/b c?%"
+	status, _ = call(t, http.MethodPut, srv.URL+"/kv/"+url.PathEscape(key)+"%2F", "escaped")
+	require.Equal(t, http.StatusNoContent, status)
+	status, body = call(t, http.MethodGet, srv.URL+"/kv/"+url.PathEscape(key+"/"), "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "escaped", body)
+
+	// base64编码的二进制key
+	bin := base64.RawURLEncoding.EncodeToString([]byte{0, 0xff, '\n'})
+	status, _ = call(t, http.MethodPut, srv.URL+"/kv/"+bin+"?encoding=base64", "\x00binary")
+	require.Equal(t, http.StatusNoContent, status)
+	status, body = call(t, http.MethodGet, srv.URL+"/kv/"+bin+"==?encoding=base64", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "\x00binary", body)
+
+	status, body = call(t, http.MethodDelete, srv.URL+"/kv/hello", "")
+	require.Equal(t, http.StatusNoContent, status, body)
+	status, body = call(t, http.MethodDelete, srv.URL+"/kv/hello", "")
+	require.Equal(t, http.StatusNotFound, status)
+	require.JSONEq(t, `{"error": "key not found"}`, body)
+	status, _ = call(t, http.MethodGet, srv.URL+"/kv/hello", "")
+	require.Equal(t, http.StatusNotFound, status)
+
+	// 带有过期时间的写入
+	status, _ = call(t, http.MethodPut, srv.URL+"/kv/short?ttl=50ms", "value")
+	require.Equal(t, http.StatusNoContent, status)
+	status, _ = call(t, http.MethodGet, srv.URL+"/kv/short", "")
+	require.Equal(t, http.StatusOK, status)
+	time.Sleep(100 * time.Millisecond)
+	status, _ = call(t, http.MethodGet, srv.URL+"/kv/short", "")
+	require.Equal(t, http.StatusNotFound, status)
+
+	// 错误的请求
+	for _, c := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodPut, "/kv/k?ttl=-1s", http.StatusBadRequest},
+		{http.MethodGet, "/kv/k?encoding=hex", http.StatusBadRequest},
+		{http.MethodGet, "/kv/!!!?encoding=base64", http.StatusBadRequest},
+		{http.MethodGet, "/kv/", http.StatusBadRequest},
+		{http.MethodPost, "/kv/k", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/batch", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/unknown", http.StatusNotFound},
+	} {
+		status, _ = call(t, c.method, srv.URL+c.path, "")
+		require.Equal(t, c.status, status, "%s %s", c.method, c.path)
+	}
+}
+
+func TestHandler_List(t *testing.T) {
+	_, srv := startGateway(t)
+	for i := 0; i < 300; i++ {
+		status, _ := call(t, http.MethodPut, fmt.Sprintf("%s/kv/user:%03d", srv.URL, i), fmt.Sprintf("value-%d", i))
+		require.Equal(t, http.StatusNoContent, status)
+	}
+	status, _ := call(t, http.MethodPut, srv.URL+"/kv/other", "value")
+	require.Equal(t, http.StatusNoContent, status)
+
+	resp, err := http.Get(srv.URL + "/kv?prefix=user:")
+	require.NoError(t, err)
+	require.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	bs, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	items := listItems(t, string(bs))
+	require.Len(t, items, 300)
+	require.Equal(t, item{Key: "user:000", Value: "value-0"}, items[0])
+	require.Equal(t, item{Key: "user:299", Value: "value-299"}, items[299])
+
+	status, body := call(t, http.MethodGet, srv.URL+"/kv?limit=2", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, []item{{Key: "other", Value: "value"}, {Key: "user:000", Value: "value-0"}}, listItems(t, body))
+
+	prefix := base64.RawURLEncoding.EncodeToString([]byte("user:29"))
+	status, body = call(t, http.MethodGet, srv.URL+"/kv?encoding=base64&limit=1&prefix="+prefix, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, []item{{
+		Key:   base64.RawURLEncoding.EncodeToString([]byte("user:290")),
+		Value: base64.RawURLEncoding.EncodeToString([]byte("value-290")),
+	}}, listItems(t, body))
+
+	status, _ = call(t, http.MethodGet, srv.URL+"/kv?limit=-1", "")
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestHandler_ListError(t *testing.T) {
+	dir := t.TempDir()
+	db, srv := startGateway(t, bitcask.DirOption(dir))
+	require.NoError(t, db.Put([]byte("a"), []byte("value")))
+	require.NoError(t, db.Put([]byte("b"), []byte("value")))
+	// 损坏最后写入的b
+	ids, err := disk.ListDataFileIDs(disk.FileStorage{}, dir)
+	require.NoError(t, err)
+	f, err := os.OpenFile(disk.DataFileName(dir, ids[len(ids)-1]), os.O_RDWR, 0600)
+	require.NoError(t, err)
+	info, err := f.Stat()
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("X"), info.Size()-1)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// 已经返回了a之后出错，连接被中断
+	resp, err := http.Get(srv.URL + "/kv")
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+	require.Error(t, err)
+
+	// 还没有返回任何数据时返回错误的状态码
+	status, _ := call(t, http.MethodGet, srv.URL+"/kv?prefix=b", "")
+	require.Equal(t, http.StatusInternalServerError, status)
+}
+
+func TestHandler_Batch(t *testing.T) {
+	_, srv := startGateway(t)
+	status, _ := call(t, http.MethodPut, srv.URL+"/kv/old", "value")
+	require.Equal(t, http.StatusNoContent, status)
+
+	status, body := call(t, http.MethodPost, srv.URL+"/batch", `{"ops": [
+		{"op": "put", "key": "a", "value": "1"},
+		{"op": "put", "key": "b", "value": ""},
+		{"op": "delete", "key": "old"}
+	]}`)
+	require.Equal(t, http.StatusOK, status, body)
+	require.JSONEq(t, `{"applied": 3}`, body)
+	status, body = call(t, http.MethodGet, srv.URL+"/kv", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, []item{{Key: "a", Value: "1"}, {Key: "b", Value: ""}}, listItems(t, body))
+
+	enc := base64.RawURLEncoding.EncodeToString
+	status, body = call(t, http.MethodPost, srv.URL+"/batch?encoding=base64",
+		fmt.Sprintf(`{"ops": [{"op": "put", "key": %q, "value": %q}]}`, enc([]byte{0xff}), enc([]byte{0})))
+	require.Equal(t, http.StatusOK, status, body)
+	status, body = call(t, http.MethodGet, srv.URL+"/kv/"+enc([]byte{0xff})+"?encoding=base64", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "\x00", body)
+
+	// 有一个操作不合法时整个批量写入都不生效
+	for _, req := range []string{
+		`{"ops": [{"op": "put", "key": "c", "value": "1"}, {"op": "incr", "key": "d"}]}`,
+		`{"ops": [{"op": "put", "key": "c", "value": "1"}, {"op": "put", "key": "d"}]}`,
+		`{"ops": [{"op": "put", "key": "c", "value": "1"}, {"op": "delete", "key": ""}]}`,
+		`{"ops": [{"op": "put", "key": "c", "value": "1", "ttl": "1s"}]}`,
+		`not json`,
+	} {
+		status, _ = call(t, http.MethodPost, srv.URL+"/batch", req)
+		require.Equal(t, http.StatusBadRequest, status, req)
+	}
+	status, _ = call(t, http.MethodGet, srv.URL+"/kv/c", "")
+	require.Equal(t, http.StatusNotFound, status)
+}
+
+func TestHandler_Admin(t *testing.T) {
+	db, srv := startGateway(t, bitcask.MaxSizeOption(256))
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 20; i++ {
+			require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%d", round))))
+		}
+	}
+
+	var before, after statsResponse
+	status, body := call(t, http.MethodGet, srv.URL+"/admin/stats", "")
+	require.Equal(t, http.StatusOK, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &before))
+	require.Equal(t, 20, before.Keys)
+	require.Greater(t, before.DataFiles, 1)
+
+	status, body = call(t, http.MethodPost, srv.URL+"/admin/merge", "")
+	require.Equal(t, http.StatusOK, status, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &after))
+	require.Equal(t, 20, after.Keys)
+	require.Less(t, after.DiskSize, before.DiskSize)
+
+	status, _ = call(t, http.MethodGet, srv.URL+"/admin/merge", "")
+	require.Equal(t, http.StatusMethodNotAllowed, status)
+}
+
+func TestHandler_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	db, err := bitcask.Open(bitcask.NewOptions([]bitcask.OptionsFunc{bitcask.DirOption(dir), bitcask.MaxSizeOption(4 << 10)}))
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key"), []byte("value")))
+	require.NoError(t, db.Close())
+
+	_, srv := startGateway(t, bitcask.DirOption(dir), bitcask.ReadOnlyOption(true))
+	status, body := call(t, http.MethodGet, srv.URL+"/kv/key", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "value", body)
+	status, body = call(t, http.MethodPut, srv.URL+"/kv/key", "new")
+	require.Equal(t, http.StatusForbidden, status)
+	require.JSONEq(t, `{"error": "db is opened in read-only mode"}`, body)
+}
@@ -57,3 +57,12 @@ func (b *Btree) Del(key []byte) error {
 	b.Unlock()
 	return nil
 }
+
+// Iterator 在索引的写时复制快照上按需遍历，创建迭代器只需要复制根节点，之后的修改不可见
+func (b *Btree) Iterator(opts IteratorOptions) Iterator {
+	// Clone会修改原来的树，不能和其他Clone并发执行
+	b.Lock()
+	tree := b.tree.Clone()
+	b.Unlock()
+	return newBtreeIterator(tree, opts)
+}
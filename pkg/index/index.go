@@ -16,6 +16,13 @@ type ValueMetadata struct {
 	ValuePos uint64
 	// 时间戳
 	TsTamp int64
+	// 过期时间，unix纳秒，0表示永不过期
+	ExpireAt int64
+}
+
+// IsExpired 判断在now(unix纳秒)时value是否已经过期
+func (v *ValueMetadata) IsExpired(now int64) bool {
+	return v.ExpireAt != 0 && v.ExpireAt <= now
 }
 
 func NewValueMetadata(fileID uint64, valueSz uint64, valuePos uint64, ts int64) *ValueMetadata {
@@ -30,6 +37,8 @@ type Indexer interface {
 	Set(key []byte, value *ValueMetadata) error
 	// Del - delete index value by key
 	Del(key []byte) error
+	// Iterator - iterate keys in order within [opts.Start, opts.End)
+	Iterator(opts IteratorOptions) Iterator
 }
 
 func checkKey(k []byte) error {
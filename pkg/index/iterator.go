@@ -0,0 +1,256 @@
+package index
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/google/btree"
+)
+
+// IteratorOptions 索引迭代器的配置
+type IteratorOptions struct {
+	// Start 不为nil时只遍历>=Start的key
+	Start []byte
+	// End 不为nil时只遍历<End的key
+	End []byte
+	// Reverse 是否按key从大到小遍历
+	Reverse bool
+}
+
+// Iterator 按key的顺序遍历索引，遍历的是创建迭代器时索引的快照，之后对索引的修改不可见
+type Iterator interface {
+	// Rewind 回到遍历顺序的第一个key
+	Rewind()
+	// Seek 正序时定位到第一个>=key的位置，逆序时定位到第一个<=key的位置
+	Seek(key []byte)
+	// Next 按遍历顺序移动到下一个key
+	Next()
+	// Prev 按遍历顺序移动到上一个key
+	Prev()
+	// Valid 当前位置是否有效
+	Valid() bool
+	// Key 返回当前位置的key
+	Key() []byte
+	// Value 返回当前位置的ValueMetadata
+	Value() *ValueMetadata
+	// Close 释放迭代器
+	Close()
+}
+
+// sliceIterator 基于有序快照的迭代器，items已经按照遍历顺序排好
+type sliceIterator struct {
+	items   []BTreeItem
+	reverse bool
+	cur     int
+}
+
+// newSliceIterator items需要按key从小到大排序
+func newSliceIterator(items []BTreeItem, reverse bool) *sliceIterator {
+	if reverse {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	return &sliceIterator{items: items, reverse: reverse}
+}
+
+func (s *sliceIterator) Rewind() {
+	s.cur = 0
+}
+
+func (s *sliceIterator) Seek(key []byte) {
+	s.cur = sort.Search(len(s.items), func(i int) bool {
+		if s.reverse {
+			return bytes.Compare(s.items[i].Key, key) <= 0
+		}
+		return bytes.Compare(s.items[i].Key, key) >= 0
+	})
+}
+
+func (s *sliceIterator) Next() {
+	if s.cur < len(s.items) {
+		s.cur++
+	}
+}
+
+func (s *sliceIterator) Prev() {
+	// cur为-1表示移动到了第一个之前
+	if s.cur >= 0 {
+		s.cur--
+	}
+}
+
+func (s *sliceIterator) Valid() bool {
+	return s.cur >= 0 && s.cur < len(s.items)
+}
+
+func (s *sliceIterator) Key() []byte {
+	return s.items[s.cur].Key
+}
+
+func (s *sliceIterator) Value() *ValueMetadata {
+	return s.items[s.cur].Val
+}
+
+func (s *sliceIterator) Close() {
+	s.items = nil
+	s.cur = 0
+}
+
+// btreeIteratorBatch btreeIterator每次从快照中取出的item个数
+const btreeIteratorBatch = 128
+
+// btreeIterator 基于btree快照的迭代器，每次按遍历顺序取出一批item，遍历到一批的边界时再取下一批
+// 只遍历一部分的迭代器不会复制整个范围内的key
+type btreeIterator struct {
+	tree *btree.BTree
+	opts IteratorOptions
+	// items 当前这一批，已经按照遍历顺序排好
+	items []BTreeItem
+	// cur 为-1表示移动到了第一个之前，为len(items)表示移动到了最后一个之后
+	cur int
+}
+
+func newBtreeIterator(tree *btree.BTree, opts IteratorOptions) *btreeIterator {
+	it := &btreeIterator{tree: tree, opts: opts}
+	it.Rewind()
+	return it
+}
+
+func (it *btreeIterator) Rewind() {
+	it.items, it.cur = it.forward(nil, true), 0
+}
+
+func (it *btreeIterator) Seek(key []byte) {
+	it.items, it.cur = it.forward(key, true), 0
+}
+
+func (it *btreeIterator) Next() {
+	switch {
+	case it.cur < len(it.items)-1:
+		it.cur++
+	case it.cur == len(it.items)-1:
+		if items := it.forward(it.items[it.cur].Key, false); len(items) > 0 {
+			it.items, it.cur = items, 0
+		} else {
+			it.cur++
+		}
+	}
+}
+
+func (it *btreeIterator) Prev() {
+	switch {
+	case it.cur > 0:
+		it.cur--
+	case it.cur == 0:
+		if items := it.backward(it.items[0].Key, false); len(items) > 0 {
+			for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+				items[i], items[j] = items[j], items[i]
+			}
+			it.items, it.cur = items, len(items)-1
+		} else {
+			it.cur--
+		}
+	}
+}
+
+func (it *btreeIterator) Valid() bool {
+	return it.cur >= 0 && it.cur < len(it.items)
+}
+
+func (it *btreeIterator) Key() []byte {
+	return it.items[it.cur].Key
+}
+
+func (it *btreeIterator) Value() *ValueMetadata {
+	return it.items[it.cur].Val
+}
+
+func (it *btreeIterator) Close() {
+	it.tree = nil
+	it.items = nil
+	it.cur = 0
+}
+
+// forward 按遍历顺序取出from之后的一批item，inclusive表示是否包括from，from为nil时从头开始
+func (it *btreeIterator) forward(from []byte, inclusive bool) []BTreeItem {
+	if it.opts.Reverse {
+		return it.descend(from, inclusive)
+	}
+	return it.ascend(from, inclusive)
+}
+
+// backward 按遍历顺序的反方向取出from之前的一批item
+func (it *btreeIterator) backward(from []byte, inclusive bool) []BTreeItem {
+	if it.opts.Reverse {
+		return it.ascend(from, inclusive)
+	}
+	return it.descend(from, inclusive)
+}
+
+// ascend 从小到大取出>=from(inclusive为false时>from)并且在范围内的一批item
+func (it *btreeIterator) ascend(from []byte, inclusive bool) []BTreeItem {
+	if it.tree == nil {
+		return nil
+	}
+	if from == nil || (it.opts.Start != nil && bytes.Compare(from, it.opts.Start) < 0) {
+		from, inclusive = it.opts.Start, true
+	}
+	items := make([]BTreeItem, 0, btreeIteratorBatch)
+	collect := func(i btree.Item) bool {
+		item := i.(BTreeItem)
+		if !inclusive && bytes.Equal(item.Key, from) {
+			return true
+		}
+		if it.opts.End != nil && bytes.Compare(item.Key, it.opts.End) >= 0 {
+			return false
+		}
+		items = append(items, item)
+		return len(items) < btreeIteratorBatch
+	}
+	if from == nil {
+		it.tree.Ascend(collect)
+	} else {
+		it.tree.AscendGreaterOrEqual(BTreeItem{Key: from}, collect)
+	}
+	return items
+}
+
+// descend 从大到小取出<=from(inclusive为false时<from)并且在范围内的一批item
+func (it *btreeIterator) descend(from []byte, inclusive bool) []BTreeItem {
+	if it.tree == nil {
+		return nil
+	}
+	if from == nil || (it.opts.End != nil && bytes.Compare(from, it.opts.End) >= 0) {
+		from, inclusive = it.opts.End, false
+	}
+	items := make([]BTreeItem, 0, btreeIteratorBatch)
+	collect := func(i btree.Item) bool {
+		item := i.(BTreeItem)
+		if !inclusive && bytes.Equal(item.Key, from) {
+			return true
+		}
+		if it.opts.Start != nil && bytes.Compare(item.Key, it.opts.Start) < 0 {
+			return false
+		}
+		items = append(items, item)
+		return len(items) < btreeIteratorBatch
+	}
+	if from == nil {
+		it.tree.Descend(collect)
+	} else {
+		it.tree.DescendLessOrEqual(BTreeItem{Key: from}, collect)
+	}
+	return items
+}
+
+// inRange 判断key是否在[opts.Start, opts.End)范围内
+func (opts IteratorOptions) inRange(key []byte) bool {
+	if opts.Start != nil && bytes.Compare(key, opts.Start) < 0 {
+		return false
+	}
+	if opts.End != nil && bytes.Compare(key, opts.End) >= 0 {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package index
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func collectKeys(it Iterator) []string {
+	var keys []string
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	return keys
+}
+
+func testIterator(t *testing.T, newIndexer func() Indexer) {
+	idx := newIndexer()
+	for i, k := range []string{"b", "d", "a", "c", "e", ""} {
+		require.NoError(t, idx.Set([]byte(k), NewValueMetadata(uint64(i), 1, 1, 1)))
+	}
+
+	it := idx.Iterator(IteratorOptions{})
+	require.Equal(t, []string{"", "a", "b", "c", "d", "e"}, collectKeys(it))
+	it.Rewind()
+	require.True(t, it.Valid())
+	require.Equal(t, []byte(""), it.Key())
+	require.Equal(t, uint64(5), it.Value().FileID)
+	it.Seek([]byte("bb"))
+	require.Equal(t, []byte("c"), it.Key())
+	it.Prev()
+	require.Equal(t, []byte("b"), it.Key())
+	it.Seek([]byte("z"))
+	require.False(t, it.Valid())
+	it.Close()
+
+	it = idx.Iterator(IteratorOptions{Reverse: true})
+	require.Equal(t, []string{"e", "d", "c", "b", "a", ""}, collectKeys(it))
+	it.Seek([]byte("bb"))
+	require.Equal(t, []byte("b"), it.Key())
+	it.Next()
+	require.Equal(t, []byte("a"), it.Key())
+	it.Prev()
+	it.Prev()
+	require.Equal(t, []byte("c"), it.Key())
+	it.Close()
+
+	// [Start, End)
+	it = idx.Iterator(IteratorOptions{Start: []byte("b"), End: []byte("d")})
+	require.Equal(t, []string{"b", "c"}, collectKeys(it))
+	it = idx.Iterator(IteratorOptions{Start: []byte("b"), End: []byte("d"), Reverse: true})
+	require.Equal(t, []string{"c", "b"}, collectKeys(it))
+	it = idx.Iterator(IteratorOptions{Start: []byte("d")})
+	require.Equal(t, []string{"d", "e"}, collectKeys(it))
+	it = idx.Iterator(IteratorOptions{End: []byte("b")})
+	require.Equal(t, []string{"", "a"}, collectKeys(it))
+	it = idx.Iterator(IteratorOptions{Start: []byte("d"), End: []byte("b")})
+	require.False(t, it.Valid())
+
+	// 快照之后的修改不可见
+	it = idx.Iterator(IteratorOptions{})
+	require.NoError(t, idx.Set([]byte("f"), NewValueMetadata(9, 1, 1, 1)))
+	require.NoError(t, idx.Del([]byte("a")))
+	require.Equal(t, []string{"", "a", "b", "c", "d", "e"}, collectKeys(it))
+
+	// 移动到第一个之前
+	it.Rewind()
+	it.Prev()
+	require.False(t, it.Valid())
+	it.Next()
+	require.True(t, it.Valid())
+	require.Equal(t, []byte(""), it.Key())
+}
+
+func TestBtree_Iterator(t *testing.T) {
+	testIterator(t, NewBtree)
+}
+
+func TestMap_Iterator(t *testing.T) {
+	testIterator(t, NewMap)
+}
+
+func TestBtree_IteratorAcrossBatches(t *testing.T) {
+	idx := NewBtree()
+	n := btreeIteratorBatch*2 + 10
+	var want []string
+	for i := 0; i < n; i++ {
+		k := fmt.Sprintf("key-%04d", i)
+		want = append(want, k)
+		require.NoError(t, idx.Set([]byte(k), NewValueMetadata(uint64(i), 1, 1, 1)))
+	}
+
+	it := idx.Iterator(IteratorOptions{})
+	// 创建迭代器时只取出第一批
+	require.Len(t, it.(*btreeIterator).items, btreeIteratorBatch)
+	require.Equal(t, want, collectKeys(it))
+	// 越过最后一个之后仍然可以往回走
+	it.Prev()
+	require.Equal(t, want[n-1], string(it.Key()))
+	for i := n - 2; i >= 0; i-- {
+		it.Prev()
+		require.Equal(t, want[i], string(it.Key()))
+	}
+	it.Prev()
+	require.False(t, it.Valid())
+	it.Next()
+	require.Equal(t, want[0], string(it.Key()))
+
+	// 在一批的边界附近来回移动
+	it.Seek([]byte(want[btreeIteratorBatch-1]))
+	it.Next()
+	require.Equal(t, want[btreeIteratorBatch], string(it.Key()))
+	it.Prev()
+	it.Prev()
+	require.Equal(t, want[btreeIteratorBatch-2], string(it.Key()))
+	it.Close()
+
+	it = idx.Iterator(IteratorOptions{Reverse: true})
+	var reversed []string
+	for i := n - 1; i >= 0; i-- {
+		reversed = append(reversed, want[i])
+	}
+	require.Equal(t, reversed, collectKeys(it))
+	it.Seek([]byte(want[n-btreeIteratorBatch-1]))
+	for i := n - btreeIteratorBatch; i < n; i++ {
+		it.Prev()
+		require.Equal(t, want[i], string(it.Key()))
+	}
+	it.Prev()
+	require.False(t, it.Valid())
+
+	// 范围的边界在后面的批次里
+	it = idx.Iterator(IteratorOptions{Start: []byte(want[5]), End: []byte(want[n-5])})
+	require.Equal(t, want[5:n-5], collectKeys(it))
+	it = idx.Iterator(IteratorOptions{Start: []byte(want[5]), End: []byte(want[n-5]), Reverse: true})
+	require.Equal(t, reversed[5:n-5], collectKeys(it))
+}
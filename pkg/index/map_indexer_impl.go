@@ -1,7 +1,9 @@
 package index
 
 import (
+	"bytes"
 	"encoding/hex"
+	"sort"
 	"sync"
 )
 
@@ -49,3 +51,23 @@ func (m *Map) Del(key []byte) error {
 	m.m.Delete(strKey)
 	return nil
 }
+
+// Iterator sync.Map是无序的，这里先拿到范围内所有的key再排序
+func (m *Map) Iterator(opts IteratorOptions) Iterator {
+	items := make([]BTreeItem, 0)
+	m.m.Range(func(k, v any) bool {
+		key, err := hex.DecodeString(k.(string))
+		if err != nil {
+			// dead code, key都是byteToString生成的
+			return true
+		}
+		if opts.inRange(key) {
+			items = append(items, BTreeItem{Key: key, Val: v.(*ValueMetadata)})
+		}
+		return true
+	})
+	sort.Slice(items, func(i, j int) bool {
+		return bytes.Compare(items[i].Key, items[j].Key) < 0
+	})
+	return newSliceIterator(items, opts.Reverse)
+}
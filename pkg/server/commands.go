@@ -0,0 +1,321 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	bitcask "bitcask-go"
+)
+
+// command 一个redis命令的实现
+type command struct {
+	// arity 包括命令名在内的参数个数，负数表示至少-arity个
+	arity int
+	run   func(s *Server, w *respWriter, args [][]byte)
+}
+
+// commands 支持的命令，key是小写的命令名，QUIT由serveConn处理
+var commands = map[string]command{
+	"ping":   {arity: -1, run: (*Server).ping},
+	"get":    {arity: 2, run: (*Server).get},
+	"set":    {arity: -3, run: (*Server).set},
+	"del":    {arity: -2, run: (*Server).del},
+	"exists": {arity: -2, run: (*Server).exists},
+	"keys":   {arity: 2, run: (*Server).keys},
+	"scan":   {arity: -2, run: (*Server).scan},
+	"mget":   {arity: -2, run: (*Server).mget},
+	"mset":   {arity: -3, run: (*Server).mset},
+	"expire": {arity: 3, run: (*Server).expire},
+	"info":   {arity: -1, run: (*Server).info},
+}
+
+const (
+	errSyntax        = "ERR syntax error"
+	errNotInteger    = "ERR value is not an integer or out of range"
+	defaultScanCount = 10
+)
+
+// exec 执行一条命令，回复写入w
+func (s *Server) exec(w *respWriter, args [][]byte) {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		wrongArgs(w, name)
+		return
+	}
+	s.totalCommands.Add(1)
+	cmd.run(s, w, args)
+}
+
+func wrongArgs(w *respWriter, name string) {
+	w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+}
+
+// dbError 回复DB返回的错误
+func dbError(w *respWriter, err error) {
+	w.error("ERR " + err.Error())
+}
+
+// parseTTL 解析EXPIRE和SET的过期时间，unit是数值的单位
+func parseTTL(arg []byte, unit time.Duration) (time.Duration, bool) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+func (s *Server) ping(w *respWriter, args [][]byte) {
+	switch len(args) {
+	case 1:
+		w.simple("PONG")
+	case 2:
+		w.bulk(args[1])
+	default:
+		wrongArgs(w, "ping")
+	}
+}
+
+func (s *Server) get(w *respWriter, args [][]byte) {
+	value, err := s.db.Get(args[1])
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	w.bulk(value)
+}
+
+// set SET key value [EX seconds | PX milliseconds]
+func (s *Server) set(w *respWriter, args [][]byte) {
+	var ttl time.Duration
+	for i := 3; i < len(args); i += 2 {
+		unit := time.Second
+		switch strings.ToLower(string(args[i])) {
+		case "ex":
+		case "px":
+			unit = time.Millisecond
+		default:
+			w.error(errSyntax)
+			return
+		}
+		if i+1 == len(args) || ttl != 0 {
+			w.error(errSyntax)
+			return
+		}
+		var ok bool
+		if ttl, ok = parseTTL(args[i+1], unit); !ok {
+			w.error(errNotInteger)
+			return
+		}
+		if ttl <= 0 {
+			w.error("ERR invalid expire time in 'set' command")
+			return
+		}
+	}
+	var err error
+	if ttl > 0 {
+		err = s.db.PutWithTTL(args[1], args[2], ttl)
+	} else {
+		err = s.db.Put(args[1], args[2])
+	}
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	w.simple("OK")
+}
+
+func (s *Server) del(w *respWriter, args [][]byte) {
+	var n int64
+	for _, key := range args[1:] {
+		existed, err := s.db.Del(key)
+		if err != nil {
+			dbError(w, err)
+			return
+		}
+		if existed {
+			n++
+		}
+	}
+	w.integer(n)
+}
+
+// exists 返回存在的key的个数，重复的key重复计数
+func (s *Server) exists(w *respWriter, args [][]byte) {
+	var n int64
+	for _, key := range args[1:] {
+		exists, err := s.db.Exists(key)
+		if err != nil {
+			dbError(w, err)
+			return
+		}
+		if exists {
+			n++
+		}
+	}
+	w.integer(n)
+}
+
+func (s *Server) keys(w *respWriter, args [][]byte) {
+	pattern := args[1]
+	it := s.db.NewIterator(bitcask.IteratorOptions{Prefix: globPrefix(pattern)})
+	defer it.Close()
+	var keys [][]byte
+	for ; it.Valid(); it.Next() {
+		if matchGlob(pattern, it.Key()) {
+			keys = append(keys, it.Key())
+		}
+	}
+	w.array(len(keys))
+	for _, key := range keys {
+		w.bulk(key)
+	}
+}
+
+// scan SCAN cursor [MATCH pattern] [COUNT count]
+// key按顺序遍历，游标是下一个要返回的key的十六进制编码，"0"表示从头开始或者已经遍历完
+// 每次调用从游标的位置开始遍历，遍历期间增删key不会导致重复或者漏掉没有改动的key
+func (s *Server) scan(w *respWriter, args [][]byte) {
+	var (
+		start []byte
+		err   error
+	)
+	if cursor := string(args[1]); cursor != "0" {
+		if start, err = hex.DecodeString(cursor); err != nil || len(start) == 0 {
+			w.error("ERR invalid cursor")
+			return
+		}
+	}
+	pattern, count := []byte("*"), int64(defaultScanCount)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			w.error(errSyntax)
+			return
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			if count, err = strconv.ParseInt(string(args[i+1]), 10, 64); err != nil {
+				w.error(errNotInteger)
+				return
+			}
+			if count < 1 {
+				w.error(errSyntax)
+				return
+			}
+		default:
+			w.error(errSyntax)
+			return
+		}
+	}
+
+	it := s.db.NewIterator(bitcask.IteratorOptions{Prefix: globPrefix(pattern), Start: start})
+	defer it.Close()
+	var keys [][]byte
+	for i := int64(0); i < count && it.Valid(); i++ {
+		if matchGlob(pattern, it.Key()) {
+			keys = append(keys, it.Key())
+		}
+		it.Next()
+	}
+	cursor := "0"
+	if it.Valid() {
+		cursor = hex.EncodeToString(it.Key())
+	}
+	w.array(2)
+	w.bulk([]byte(cursor))
+	w.array(len(keys))
+	for _, key := range keys {
+		w.bulk(key)
+	}
+}
+
+func (s *Server) mget(w *respWriter, args [][]byte) {
+	values := make([][]byte, 0, len(args)-1)
+	for _, key := range args[1:] {
+		value, err := s.db.Get(key)
+		if err != nil {
+			dbError(w, err)
+			return
+		}
+		values = append(values, value)
+	}
+	w.array(len(values))
+	for _, value := range values {
+		w.bulk(value)
+	}
+}
+
+// mset 所有key作为一次批量写入原子地生效
+func (s *Server) mset(w *respWriter, args [][]byte) {
+	if len(args)%2 == 0 {
+		wrongArgs(w, "mset")
+		return
+	}
+	wb := s.db.NewWriteBatch()
+	for i := 1; i < len(args); i += 2 {
+		if err := wb.Put(args[i], args[i+1]); err != nil {
+			dbError(w, err)
+			return
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		dbError(w, err)
+		return
+	}
+	w.simple("OK")
+}
+
+// expire 过期时间不是正数时和redis一样直接删除key
+func (s *Server) expire(w *respWriter, args [][]byte) {
+	ttl, ok := parseTTL(args[2], time.Second)
+	if !ok {
+		w.error(errNotInteger)
+		return
+	}
+	var (
+		existed bool
+		err     error
+	)
+	if ttl > 0 {
+		existed, err = s.db.Expire(args[1], ttl)
+	} else {
+		existed, err = s.db.Del(args[1])
+	}
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	if existed {
+		w.integer(1)
+	} else {
+		w.integer(0)
+	}
+}
+
+// info 返回redis格式的服务端和DB的统计信息，忽略section参数
+func (s *Server) info(w *respWriter, args [][]byte) {
+	stats, err := s.db.Stats()
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Server\r\nuptime_in_seconds:%d\r\n\r\n", int64(time.Since(s.startedAt).Seconds()))
+	fmt.Fprintf(&b, "# Clients\r\nconnected_clients:%d\r\n\r\n", s.connCount())
+	fmt.Fprintf(&b, "# Stats\r\ntotal_connections_received:%d\r\ntotal_commands_processed:%d\r\n\r\n",
+		s.totalConns.Load(), s.totalCommands.Load())
+	fmt.Fprintf(&b, "# Bitcask\r\ndata_files:%d\r\ndisk_size:%d\r\ndiscarded_bytes:%d\r\n\r\n",
+		stats.DataFileNum, stats.DiskSize, stats.DiscardedBytes)
+	fmt.Fprintf(&b, "# Keyspace\r\ndb0:keys=%d\r\n", stats.KeyNum)
+	w.bulk([]byte(b.String()))
+}
@@ -0,0 +1,90 @@
+package server
+
+// matchGlob 按照redis KEYS命令的规则判断key是否匹配pattern
+// 支持 * 任意多个字符、? 单个字符、[abc] [^abc] [a-z] 字符集合，\ 转义下一个字符
+func matchGlob(pattern, key []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if matchGlob(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+			key = key[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+			var ok bool
+			if ok, pattern = matchClass(pattern[1:], key[0]); !ok {
+				return false
+			}
+			key = key[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+			key = key[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(key) == 0
+}
+
+// matchClass 判断c是否在pattern开头的字符集合中，pattern从[之后开始，返回]之后剩下的pattern
+// 没有]结尾的字符集合一直到pattern末尾
+func matchClass(pattern []byte, c byte) (bool, []byte) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+	match := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			match = match || pattern[1] == c
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			match = match || (lo <= c && c <= hi)
+			pattern = pattern[3:]
+		default:
+			match = match || pattern[0] == c
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return match != not, pattern
+}
+
+// globPrefix 返回pattern开头不含通配符的部分，匹配的key一定以它开头，用来缩小遍历的范围
+func globPrefix(pattern []byte) []byte {
+	for i, c := range pattern {
+		switch c {
+		case '*', '?', '[', '\\':
+			return pattern[:i]
+		}
+	}
+	return pattern
+}
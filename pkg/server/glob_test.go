@@ -0,0 +1,45 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchGlob(t *testing.T) {
+	for _, c := range []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "user", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h**o", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`[\]]`, "]", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbY", false},
+	} {
+		require.Equal(t, c.match, matchGlob([]byte(c.pattern), []byte(c.key)), "%s %s", c.pattern, c.key)
+	}
+}
+
+func TestGlobPrefix(t *testing.T) {
+	require.Equal(t, []byte("user:"), globPrefix([]byte("user:*")))
+	require.Equal(t, []byte("h"), globPrefix([]byte("h[ae]llo")))
+	require.Equal(t, []byte("a"), globPrefix([]byte(`a\*`)))
+	require.Equal(t, []byte("key"), globPrefix([]byte("key")))
+	require.Empty(t, globPrefix([]byte("*")))
+}
@@ -0,0 +1,195 @@
+package server
+
+/*
+RESP2协议的解析和编码
+客户端发送的命令是bulk string组成的数组 *<参数个数>\r\n $<长度>\r\n<参数>\r\n ...，也可以是以空白分隔的一行inline命令
+服务端的回复有 +简单字符串、-错误、:整数、$bulk string($-1表示nil)、*数组 五种
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	// maxBulkLen 单个参数的最大长度，和redis的默认配置一致
+	maxBulkLen = 512 << 20
+	// maxArrayLen 一条命令最多的参数个数
+	maxArrayLen = 1 << 20
+	// maxInlineLen inline命令和协议中每一行的最大长度
+	maxInlineLen = 64 << 10
+	// bulkPreallocLen 不超过这个长度的参数一次分配好内存，更长的边读边分配，避免客户端声明很大的长度耗尽内存
+	bulkPreallocLen = 1 << 20
+)
+
+// ErrProtocol 客户端发送的数据不符合RESP协议，回复错误之后关闭连接
+var ErrProtocol = errors.New("protocol error")
+
+// respReader 从连接中读取命令
+type respReader struct {
+	r *bufio.Reader
+}
+
+func newRespReader(r io.Reader) *respReader {
+	return &respReader{r: bufio.NewReader(r)}
+}
+
+// buffered 返回已经读到缓冲区但还没有解析的字节数，大于0说明客户端流水线发送了更多的命令
+func (r *respReader) buffered() int {
+	return r.r.Buffered()
+}
+
+// readCommand 读取一条命令，返回命令名和参数，空行和空数组会被跳过
+func (r *respReader) readCommand() ([][]byte, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '*' {
+			// inline命令，参数引用了缓冲区，要拷贝出来
+			fields := bytes.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			args := make([][]byte, len(fields))
+			for i, field := range fields {
+				args[i] = append([]byte(nil), field...)
+			}
+			return args, nil
+		}
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || n > maxArrayLen {
+			return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+		}
+		if n <= 0 {
+			continue
+		}
+		args := make([][]byte, n)
+		for i := range args {
+			if args[i], err = r.readBulk(); err != nil {
+				return nil, err
+			}
+		}
+		return args, nil
+	}
+}
+
+// readBulk 读取一个 $<长度>\r\n<数据>\r\n 格式的参数
+func (r *respReader) readBulk() ([]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, fmt.Errorf("%w: expected '$', got %q", ErrProtocol, line)
+	}
+	size, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || size < 0 || size > maxBulkLen {
+		return nil, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+	}
+	var bs []byte
+	if size+2 <= bulkPreallocLen {
+		bs = make([]byte, size+2)
+		_, err = io.ReadFull(r.r, bs)
+	} else {
+		var buf bytes.Buffer
+		_, err = io.CopyN(&buf, r.r, size+2)
+		bs = buf.Bytes()
+	}
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(bs, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: bulk string is not terminated by CRLF", ErrProtocol)
+	}
+	return bs[:size], nil
+}
+
+// readLine 读取一行，返回的数据去掉了行尾的\r\n并且引用了缓冲区，只在下一次读取之前有效
+func (r *respReader) readLine() ([]byte, error) {
+	line, err := r.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		// 超过缓冲区的长行拼接起来
+		long := append([]byte(nil), line...)
+		for errors.Is(err, bufio.ErrBufferFull) && len(long) <= maxInlineLen {
+			line, err = r.r.ReadSlice('\n')
+			long = append(long, line...)
+		}
+		line = long
+	}
+	if len(line) > maxInlineLen {
+		return nil, fmt.Errorf("%w: too big inline request", ErrProtocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, nil
+}
+
+// respWriter 将回复编码后写入缓冲区，写入的错误在flush时返回
+type respWriter struct {
+	w *bufio.Writer
+}
+
+func newRespWriter(w io.Writer) *respWriter {
+	return &respWriter{w: bufio.NewWriter(w)}
+}
+
+// simple 写入简单字符串，s中不能有\r\n
+func (w *respWriter) simple(s string) {
+	w.w.WriteByte('+')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// error 写入错误，msg按照redis的惯例以ERR等错误类型开头
+func (w *respWriter) error(msg string) {
+	w.w.WriteByte('-')
+	w.w.WriteString(msg)
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) integer(n int64) {
+	w.w.WriteByte(':')
+	w.w.WriteString(strconv.FormatInt(n, 10))
+	w.w.WriteString("\r\n")
+}
+
+// bulk 写入bulk string，b为nil时写入nil
+func (w *respWriter) bulk(b []byte) {
+	if b == nil {
+		w.w.WriteString("$-1\r\n")
+		return
+	}
+	w.w.WriteByte('$')
+	w.w.WriteString(strconv.Itoa(len(b)))
+	w.w.WriteString("\r\n")
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+// array 写入数组的头部，之后需要再写入n个元素
+func (w *respWriter) array(n int) {
+	w.w.WriteByte('*')
+	w.w.WriteString(strconv.Itoa(n))
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRespReader(t *testing.T) {
+	r := newRespReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$4\r\na\r\nb\r\n" +
+		"\r\n*0\r\n" +
+		"  SET  key value\r\n" +
+		"*1\r\n$0\r\n\r\n"))
+	args, err := r.readCommand()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("GET"), []byte("a\r\nb")}, args)
+	// 空行和空数组被跳过
+	args, err = r.readCommand()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("SET"), []byte("key"), []byte("value")}, args)
+	args, err = r.readCommand()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{}}, args)
+	_, err = r.readCommand()
+	require.ErrorIs(t, err, io.EOF)
+
+	// 命令不完整
+	r = newRespReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$4\r\nab"))
+	_, err = r.readCommand()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	for _, input := range []string{
+		"*x\r\n",
+		"*2\r\n+GET\r\n",
+		"*1\r\n$-1\r\n",
+		"*1\r\n$3\r\nGETxx",
+		"*1\r\n$1000000000\r\n",
+		"*100000000\r\n",
+		strings.Repeat("a", maxInlineLen+1) + "\r\n",
+	} {
+		_, err = newRespReader(strings.NewReader(input)).readCommand()
+		require.ErrorIs(t, err, ErrProtocol, input)
+	}
+}
+
+func TestRespReader_LargeBulk(t *testing.T) {
+	value := bytes.Repeat([]byte("v"), bulkPreallocLen+10)
+	var b bytes.Buffer
+	b.WriteString("*2\r\n$3\r\nSET\r\n$1048586\r\n")
+	b.Write(value)
+	b.WriteString("\r\n")
+	args, err := newRespReader(&b).readCommand()
+	require.NoError(t, err)
+	require.Equal(t, value, args[1])
+}
+
+func TestRespWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := newRespWriter(&b)
+	w.simple("OK")
+	w.error("ERR oops")
+	w.integer(-42)
+	w.bulk([]byte("a\r\nb"))
+	w.bulk(nil)
+	w.array(2)
+	w.bulk([]byte{})
+	w.integer(1)
+	require.Zero(t, b.Len())
+	require.NoError(t, w.flush())
+	require.Equal(t, "+OK\r\n-ERR oops\r\n:-42\r\n$4\r\na\r\nb\r\n$-1\r\n*2\r\n$0\r\n\r\n:1\r\n", b.String())
+}
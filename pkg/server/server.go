@@ -0,0 +1,158 @@
+// Package server 使用redis的RESP2协议对外提供DB的读写，redis的客户端可以直接连接
+package server
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bitcask "bitcask-go"
+)
+
+// ErrServerClosed Close之后Serve返回的错误
+var ErrServerClosed = errors.New("server closed")
+
+// Server 在TCP连接上执行redis命令，可以同时服务多个连接
+// 每个连接按顺序执行客户端流水线发送的命令，缓冲区中的命令都执行完之后才一起发送回复
+type Server struct {
+	db *bitcask.DB
+	// mu 保护listeners、conns和closed
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	// wg 等待所有连接的goroutine退出
+	wg        sync.WaitGroup
+	startedAt time.Time
+	// totalConns和totalCommands是INFO命令返回的统计信息
+	totalConns    atomic.Int64
+	totalCommands atomic.Int64
+}
+
+// New 创建一个使用db的Server，Server不负责关闭db
+func New(db *bitcask.DB) *Server {
+	return &Server{
+		db:        db,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		startedAt: time.Now(),
+	}
+}
+
+// ListenAndServe 监听addr上的TCP连接并处理，Close之后返回ErrServerClosed
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve 接受l上的连接并处理，返回时l已经被关闭，Close之后返回ErrServerClosed
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		_ = l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		s.totalConns.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// Close 关闭所有的监听和连接，等待正在执行的命令完成，不会关闭DB
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if closeErr := l.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// serveConn 读取并执行连接上的命令，直到连接关闭、客户端发送QUIT或者出现协议错误
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	r, w := newRespReader(conn), newRespWriter(conn)
+	for {
+		args, err := r.readCommand()
+		if err != nil {
+			if errors.Is(err, ErrProtocol) {
+				w.error("ERR " + err.Error())
+				_ = w.flush()
+			}
+			return
+		}
+		quit := strings.EqualFold(string(args[0]), "quit")
+		if quit {
+			w.simple("OK")
+		} else {
+			s.exec(w, args)
+		}
+		// 客户端流水线发送的命令都执行完之后再一起回复
+		if r.buffered() == 0 || quit {
+			if err = w.flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// connCount 返回当前的连接数
+func (s *Server) connCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
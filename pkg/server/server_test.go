@@ -0,0 +1,318 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bitcask "bitcask-go"
+)
+
+// respError 测试客户端读到的错误回复
+type respError string
+
+// testClient 通过回环地址连接Server的最简单的RESP客户端
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startServer 在随机端口上启动Server，测试结束时关闭
+func startServer(t *testing.T) (*Server, string) {
+	db, err := bitcask.Open(bitcask.NewOptions([]bitcask.OptionsFunc{
+		bitcask.DirOption(t.TempDir()),
+		bitcask.MaxSizeOption(4 << 10),
+	}))
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := New(db)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		_ = s.Close()
+		require.ErrorIs(t, <-done, ErrServerClosed)
+		require.NoError(t, db.Close())
+	})
+	return s, l.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send 发送一条命令，不等待回复
+func (c *testClient) send(args ...string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c.conn, b.String())
+	require.NoError(c.t, err)
+}
+
+// do 发送一条命令并读取回复
+func (c *testClient) do(args ...string) any {
+	c.send(args...)
+	return c.read()
+}
+
+// read 读取一条回复，简单字符串返回string，错误返回respError，整数返回int64，bulk string返回[]byte或者nil，数组返回[]any
+func (c *testClient) read() any {
+	reply, err := readReply(c.r)
+	require.NoError(c.t, err)
+	return reply
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("reply is not terminated by CRLF")
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		bs := make([]byte, n+2)
+		if _, err = io.ReadFull(r, bs); err != nil {
+			return nil, err
+		}
+		return bs[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown reply %q", line)
+}
+
+// bulks 将字符串转换成和数组回复对应的[]any
+func bulks(values ...string) []any {
+	items := make([]any, len(values))
+	for i, v := range values {
+		items[i] = []byte(v)
+	}
+	return items
+}
+
+func TestServer_Commands(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	require.Equal(t, "PONG", c.do("PING"))
+	require.Equal(t, []byte("hello"), c.do("ping", "hello"))
+	require.Equal(t, "OK", c.do("SET", "key", "value"))
+	require.Equal(t, []byte("value"), c.do("GET", "key"))
+	require.Nil(t, c.do("GET", "missing"))
+
+	// key和value都是二进制安全的
+	require.Equal(t, "OK", c.do("SET", "bin\r\n\x00", ""))
+	require.Equal(t, []byte{}, c.do("GET", "bin\r\n\x00"))
+
+	require.Equal(t, "OK", c.do("MSET", "user:1", "alice", "user:2", "bob", "user:10", "carol"))
+	require.Equal(t, []any{[]byte("alice"), nil, []byte("carol")}, c.do("MGET", "user:1", "user:3", "user:10"))
+	require.Equal(t, int64(3), c.do("EXISTS", "user:1", "user:1", "user:2", "user:3"))
+	require.Equal(t, bulks("user:1", "user:10", "user:2"), c.do("KEYS", "user:*"))
+	require.Equal(t, bulks("user:1", "user:2"), c.do("KEYS", "user:?"))
+	require.Equal(t, bulks("key"), c.do("KEYS", "[jk]*"))
+
+	require.Equal(t, int64(2), c.do("DEL", "user:2", "user:3", "bin\r\n\x00"))
+	require.Equal(t, int64(0), c.do("EXISTS", "user:2"))
+
+	// 过期时间
+	require.Equal(t, "OK", c.do("SET", "short", "value", "PX", "50"))
+	require.Equal(t, int64(1), c.do("EXPIRE", "key", "100"))
+	require.Equal(t, int64(0), c.do("EXPIRE", "missing", "100"))
+	require.Equal(t, int64(1), c.do("EXPIRE", "user:10", "0"))
+	time.Sleep(100 * time.Millisecond)
+	require.Nil(t, c.do("GET", "short"))
+	require.Equal(t, []byte("value"), c.do("GET", "key"))
+	require.Nil(t, c.do("GET", "user:10"))
+
+	info, ok := c.do("INFO").([]byte)
+	require.True(t, ok)
+	require.Contains(t, string(info), "db0:keys=2\r\n")
+	require.Contains(t, string(info), "connected_clients:1\r\n")
+
+	// 错误
+	require.Equal(t, respError("ERR unknown command 'FOO'"), c.do("FOO"))
+	require.Equal(t, respError("ERR wrong number of arguments for 'get' command"), c.do("GET"))
+	require.Equal(t, respError("ERR wrong number of arguments for 'mset' command"), c.do("MSET", "a", "1", "b"))
+	require.Equal(t, respError("ERR syntax error"), c.do("SET", "a", "1", "NX"))
+	require.Equal(t, respError("ERR invalid expire time in 'set' command"), c.do("SET", "a", "1", "EX", "0"))
+	require.Equal(t, respError("ERR value is not an integer or out of range"), c.do("EXPIRE", "a", "x"))
+	require.Equal(t, respError("ERR invalid cursor"), c.do("SCAN", "x"))
+	// 出错之后连接仍然可用
+	require.Equal(t, "PONG", c.do("PING"))
+
+	require.Equal(t, "OK", c.do("QUIT"))
+	_, err := c.r.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestServer_Scan(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	var want []string
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		want = append(want, key)
+		require.Equal(t, "OK", c.do("SET", key, "value"))
+		require.Equal(t, "OK", c.do("SET", fmt.Sprintf("other-%02d", i), "value"))
+	}
+
+	var got []string
+	cursor, calls := "0", 0
+	for {
+		reply := c.do("SCAN", cursor, "MATCH", "key-*", "COUNT", "7").([]any)
+		for _, key := range reply[1].([]any) {
+			got = append(got, string(key.([]byte)))
+		}
+		calls++
+		if cursor = string(reply[0].([]byte)); cursor == "0" {
+			break
+		}
+	}
+	require.Equal(t, 4, calls)
+	sort.Strings(got)
+	require.Equal(t, want, got)
+
+	// 不带MATCH时遍历所有的key
+	reply := c.do("SCAN", "0", "COUNT", "100").([]any)
+	require.Equal(t, []byte("0"), reply[0])
+	require.Len(t, reply[1], 50)
+
+	// 遍历期间删除已经返回的key不会漏掉后面的key
+	got = got[:0]
+	cursor = "0"
+	for {
+		reply := c.do("SCAN", cursor, "MATCH", "key-*", "COUNT", "5").([]any)
+		for _, key := range reply[1].([]any) {
+			got = append(got, string(key.([]byte)))
+			require.Equal(t, int64(1), c.do("DEL", string(key.([]byte))))
+		}
+		if cursor = string(reply[0].([]byte)); cursor == "0" {
+			break
+		}
+	}
+	require.Equal(t, want, got)
+}
+
+func TestServer_Pipelining(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	// 一次发送所有的命令，再按顺序读取回复
+	var b strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&b, "*3\r\n$3\r\nSET\r\n$6\r\nkey%03d\r\n$8\r\nvalue%03d\r\n", i, i)
+		fmt.Fprintf(&b, "*2\r\n$3\r\nGET\r\n$6\r\nkey%03d\r\n", i)
+	}
+	// inline命令
+	b.WriteString("PING\r\nEXISTS key000 key199\n")
+	_, err := io.WriteString(c.conn, b.String())
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		require.Equal(t, "OK", c.read())
+		require.Equal(t, []byte(fmt.Sprintf("value%03d", i)), c.read())
+	}
+	require.Equal(t, "PONG", c.read())
+	require.Equal(t, int64(2), c.read())
+}
+
+func TestServer_ConcurrentClients(t *testing.T) {
+	_, addr := startServer(t)
+	done := make(chan struct{})
+	for n := 0; n < 4; n++ {
+		c := dial(t, addr)
+		go func(n int) {
+			defer func() { done <- struct{}{} }()
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("client%d-%d", n, i)
+				if c.do("SET", key, key) != "OK" {
+					t.Errorf("SET %s failed", key)
+					return
+				}
+			}
+		}(n)
+	}
+	for n := 0; n < 4; n++ {
+		<-done
+	}
+	c := dial(t, addr)
+	require.Len(t, c.do("KEYS", "client*"), 400)
+}
+
+func TestServer_ProtocolError(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	_, err := io.WriteString(c.conn, "*1\r\n#3\r\nGET\r\n")
+	require.NoError(t, err)
+	reply := c.read()
+	require.IsType(t, respError(""), reply)
+	require.Contains(t, string(reply.(respError)), "ERR protocol error: expected '$'")
+	// 协议错误之后连接被关闭
+	_, err = c.r.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestServer_Close(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	require.Equal(t, "PONG", c.do("PING"))
+	require.NoError(t, s.Close())
+	require.ErrorIs(t, s.Close(), ErrServerClosed)
+	// 已有的连接被关闭，也不再接受新的连接
+	_, err := c.r.ReadByte()
+	require.Error(t, err)
+	_, err = net.Dial("tcp", addr)
+	require.Error(t, err)
+	require.ErrorIs(t, s.Serve(nopListener{}), ErrServerClosed)
+}
+
+// nopListener Close之后再Serve时使用，不会被真正调用Accept
+type nopListener struct {
+	net.Listener
+}
+
+func (nopListener) Close() error {
+	return nil
+}
@@ -0,0 +1,186 @@
+package bitcast_go
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"bitcask-go/pkg/disk"
+)
+
+// repairDirName Repair时修复后的数据文件先写到这个子目录，全部写完后再逐个替换
+const repairDirName = "repair"
+
+// FileReport 一个数据文件的检查结果
+type FileReport struct {
+	FileID uint64
+	Path   string
+	Size   int64
+	// Records 完整的LogRecord的数量
+	Records int
+	// Problems 文件中所有损坏的数据，按照位置排序
+	Problems []disk.Problem
+	// Err 文件无法打开的原因，比如文件头损坏或者缺少密钥，这时没有检查其中的LogRecord
+	Err error
+}
+
+// OK 文件是否完好
+func (r *FileReport) OK() bool {
+	return r.Err == nil && len(r.Problems) == 0
+}
+
+// VerifyReport Verify和Repair的检查结果
+type VerifyReport struct {
+	Files []FileReport
+}
+
+// OK 所有数据文件是否都完好
+func (r *VerifyReport) OK() bool {
+	for i := range r.Files {
+		if !r.Files[i].OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify 离线检查opts.Dir下所有的数据文件，逐条校验其中的LogRecord，不修改目录中的任何文件
+// 和只读的DB一样持有目录的共享锁，不能和以读写方式打开的DB同时使用
+func Verify(opts *Options) (*VerifyReport, error) {
+	if _, err := os.Stat(opts.Dir); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(opts.Dir, true)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+	if err = checkPendingMerge(opts.Dir); err != nil {
+		return nil, err
+	}
+	return verifyDataFiles(opts)
+}
+
+// Repair 离线检查opts.Dir下所有的数据文件，将有损坏的数据文件中完整的LogRecord写入同ID的新文件替换原来的文件
+// 损坏的LogRecord被丢弃，被替换的文件的hint文件也会被删除，下次Open时从修复后的文件重建索引
+// 返回的是修复之前的检查结果，有文件无法打开时不做任何修改并返回错误
+func Repair(opts *Options) (*VerifyReport, error) {
+	if opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if _, err := os.Stat(opts.Dir); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(opts.Dir, false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+	s := opts.storage()
+	if err = recoverMerge(s, opts.Dir); err != nil {
+		return nil, err
+	}
+	report, err := verifyDataFiles(opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range report.Files {
+		if file.Err != nil {
+			return report, fmt.Errorf("repair %s: %w", file.Path, file.Err)
+		}
+	}
+
+	repairDir := filepath.Join(opts.Dir, repairDirName)
+	if err = disk.RemoveDataFiles(s, repairDir); err != nil {
+		return report, err
+	}
+	var damaged []uint64
+	for _, file := range report.Files {
+		if file.OK() {
+			continue
+		}
+		if err = salvageDataFile(opts, repairDir, file.FileID); err != nil {
+			return report, err
+		}
+		damaged = append(damaged, file.FileID)
+	}
+	if len(damaged) == 0 {
+		return report, nil
+	}
+	if err = syncDirs(s, repairDir); err != nil {
+		return report, err
+	}
+	// 逐个替换，中途崩溃时每个文件要么是原来的要么是修复后的，重新执行Repair即可
+	for _, fid := range damaged {
+		if err = os.Remove(disk.HintFileName(opts.Dir, fid)); err != nil && !os.IsNotExist(err) {
+			return report, err
+		}
+		if err = s.Rename(disk.DataFileName(repairDir, fid), disk.DataFileName(opts.Dir, fid)); err != nil {
+			return report, err
+		}
+	}
+	if err = syncDirs(s, opts.Dir); err != nil {
+		return report, err
+	}
+	return report, os.RemoveAll(repairDir)
+}
+
+// verifyDataFiles 检查目录下的每一个数据文件
+func verifyDataFiles(opts *Options) (*VerifyReport, error) {
+	fileIDs, err := disk.ListDataFileIDs(opts.storage(), opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{Files: make([]FileReport, 0, len(fileIDs))}
+	for _, fid := range fileIDs {
+		file := FileReport{FileID: fid, Path: disk.DataFileName(opts.Dir, fid)}
+		dataFile, err := disk.NewManager(opts.Dir, fid, false, 0, opts.encodingOptions()...)
+		if err != nil {
+			file.Err = err
+			report.Files = append(report.Files, file)
+			continue
+		}
+		file.Size = dataFile.Size()
+		file.Problems, err = disk.ScanDataFile(dataFile, func(*disk.LogRecord, uint64, uint64) error {
+			file.Records++
+			return nil
+		})
+		if closeErr := dataFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.Files = append(report.Files, file)
+	}
+	return report, nil
+}
+
+// salvageDataFile 将文件中完整的LogRecord按照原来的顺序写入repairDir下同ID的新文件
+// LogRecord的时间戳、过期时间和批量写入的序号都保持不变，编码按照当前的配置
+func salvageDataFile(opts *Options, repairDir string, fid uint64) (err error) {
+	src, err := disk.NewManager(opts.Dir, fid, false, 0, opts.encodingOptions()...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := src.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	dst, err := disk.NewManager(repairDir, fid, true, 0, opts.encodingOptions()...)
+	if err != nil {
+		return err
+	}
+	_, err = disk.ScanDataFile(src, func(record *disk.LogRecord, _, _ uint64) error {
+		_, err := dst.WriteLogRecord(record, true)
+		return err
+	})
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
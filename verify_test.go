@@ -0,0 +1,128 @@
+package bitcast_go
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitcask-go/pkg/disk"
+	"bitcask-go/pkg/index"
+)
+
+func TestVerifyAndRepair(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{
+		DirOption(t.TempDir()),
+		MaxSizeOption(256),
+	})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	// merge生成hint文件，之后的写入在新的文件中
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Put([]byte("key-05"), []byte("new-value-05")))
+	for i := 30; i < 40; i++ {
+		require.NoError(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	locate := func(key string) *index.ValueMetadata {
+		vMeta, err := db.index.Get([]byte(key))
+		require.NoError(t, err)
+		return vMeta
+	}
+	// key-05最新的值损坏后回退到merge文件中旧的值，key-12只有一个值，损坏后就丢失了
+	newer, merged := locate("key-05"), locate("key-12")
+	_, err = os.Stat(disk.HintFileName(opts.Dir, merged.FileID))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	report, err := Verify(opts)
+	require.NoError(t, err)
+	require.True(t, report.OK())
+
+	for _, vMeta := range []*index.ValueMetadata{newer, merged} {
+		name := disk.DataFileName(opts.Dir, vMeta.FileID)
+		content, err := os.ReadFile(name)
+		require.NoError(t, err)
+		content[vMeta.ValuePos+vMeta.ValueSz-1] ^= 0xff
+		require.NoError(t, os.WriteFile(name, content, 0600))
+	}
+
+	report, err = Verify(opts)
+	require.NoError(t, err)
+	require.False(t, report.OK())
+	var damaged []disk.Problem
+	for _, file := range report.Files {
+		require.NoError(t, file.Err)
+		damaged = append(damaged, file.Problems...)
+	}
+	require.Len(t, damaged, 2)
+	for _, p := range damaged {
+		require.Equal(t, disk.ProblemCrcMismatch, p.Kind)
+	}
+	require.Equal(t, []byte("key-12"), damaged[0].Key)
+	require.Equal(t, []byte("key-05"), damaged[1].Key)
+
+	// 只读打开时不能修复
+	readOnly := *opts
+	readOnly.ReadOnly = true
+	_, err = Repair(&readOnly)
+	require.ErrorIs(t, err, ErrReadOnly)
+
+	report, err = Repair(opts)
+	require.NoError(t, err)
+	require.False(t, report.OK())
+	_, err = os.Stat(disk.HintFileName(opts.Dir, merged.FileID))
+	require.True(t, os.IsNotExist(err))
+	report, err = Verify(opts)
+	require.NoError(t, err)
+	require.True(t, report.OK())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		val, err := db.Get([]byte(key))
+		require.NoError(t, err)
+		switch i {
+		case 5:
+			require.Equal(t, []byte("value-05"), val)
+		case 12:
+			require.Nil(t, val)
+		default:
+			require.Equal(t, []byte(fmt.Sprintf("value-%02d", i)), val, key)
+		}
+	}
+
+	// DB打开期间不能检查
+	_, err = Verify(opts)
+	require.ErrorIs(t, err, ErrDatabaseInUse)
+	require.NoError(t, db.Close())
+}
+
+func TestVerify_UnreadableFile(t *testing.T) {
+	opts := NewOptions([]OptionsFunc{DirOption(t.TempDir())})
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key"), []byte("value")))
+	require.NoError(t, db.Close())
+
+	// 文件头损坏，无法确定格式，不能修复
+	name := disk.DataFileName(opts.Dir, 1)
+	content, err := os.ReadFile(name)
+	require.NoError(t, err)
+	content[5] ^= 0xff
+	require.NoError(t, os.WriteFile(name, content, 0600))
+
+	report, err := Verify(opts)
+	require.NoError(t, err)
+	require.Len(t, report.Files, 1)
+	require.ErrorIs(t, report.Files[0].Err, disk.ErrInvalidFileHeader)
+	_, err = Repair(opts)
+	require.ErrorIs(t, err, disk.ErrInvalidFileHeader)
+	bs, err := os.ReadFile(name)
+	require.NoError(t, err)
+	require.Equal(t, content, bs)
+}